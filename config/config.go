@@ -1,71 +1,381 @@
 package config
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"llmgateway/internal/clientip"
 	"llmgateway/internal/models"
+	"llmgateway/internal/proxy"
+	"net/netip"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Config holds the application configuration
 type Config struct {
-	KeysConfig     models.KeysConfig
+	keys   atomic.Pointer[keySet] // Swapped as a whole on reload
+	keysMu sync.Mutex             // Serializes key set writers
+
+	KeysFilePath       string
+	KeysMasterKey      []byte // AES-256 key for enc:v1: api_key values (nil if not configured)
+	KeysReloadInterval int    // Seconds between keys file change checks (0 disables polling)
+	KeyExpiryWarning   int    // Hours before expires_at that a key is logged as expiring
+
+	AdminAPIKey string // Bearer token for /admin endpoints ("" disables them)
+
+	JWTJWKSURL       string // JWKS endpoint for bearer JWTs ("" with no file disables JWT auth)
+	JWTJWKSFile      string // JWKS file, as an alternative to JWTJWKSURL
+	JWTJWKSRefresh   int    // Seconds fetched signing keys are used before refetching
+	JWTIssuer        string // Required iss claim ("" accepts any)
+	JWTAudience      string // Required aud claim ("" accepts any)
+	JWTGroupsClaim   string // Claim listing the caller's groups
+	JWTLeewaySeconds int    // Clock skew tolerated on exp and nbf
+
+	TLSCertFile       string // Server certificate; HTTPS is served when set
+	TLSKeyFile        string
+	TLSClientCAFile   string // CA bundle verifying client certificates ("" disables mTLS)
+	TLSClientAuth     string // "none", "optional" or "require"
+	TLSReloadInterval int    // Seconds between certificate file change checks
+
+	TrustedProxies []netip.Prefix // Peers whose Forwarded/X-Forwarded-For headers identify the client
+
+	UpstreamHeaderAllowlist []string                              // Client headers forwarded to providers ("*" for all)
+	UpstreamHeaderDenylist  []string                              // Client headers never forwarded, even if allowed
+	UpstreamExtraHeaders    map[models.Provider]map[string]string // Headers set on every request to a provider
+	ResponseHeaderAllowlist []string                              // Provider response headers passed back to clients ("*" for all)
+
 	ServerPort     string
 	LogToFile      bool
 	LogFilePath    string
 	QuotaEnabled   bool
 	QuotaLimit     int64 // Max requests per hour per virtual key
 	RequestTimeout int   // Request timeout in seconds
+
+	QuotaChargedOutcomes []models.Outcome // Request outcomes that consume quota
+	QuotaBackend         string           // "memory" or "redis"
+	RedisAddr            string
+	RedisPassword        string
+	RedisDB              int
+	RedisKeyPrefix       string
+	RedisTimeoutMs       int
+
+	UsageRetentionHours int    // Hours of usage history kept for /usage
+	PricingFile         string // JSON model prices added to or overriding usage.DefaultPricing ("" for the defaults)
+
+	TracingEnabled     bool   // Export OpenTelemetry spans over OTLP
+	TracingServiceName string // service.name resource attribute
+
+	StateFilePath     string // Tracker snapshot file ("" disables persistence)
+	StateSaveInterval int    // Seconds between tracker snapshots
+
+	SchedulerMaxConcurrent int    // Max in-flight upstream requests per provider (0 = unlimited)
+	SchedulerPolicy        string // "weighted" or "strict"
+
+	ShutdownDrainDelay int // Seconds between failing readiness and closing the listeners
+	ShutdownTimeout    int // Seconds in-flight requests get to finish once the listeners are closed
 }
 
 // Load loads the configuration from environment variables
 // All config values can be set via environment variables:
 // - KEYS_FILE_PATH: path to keys.json (default: "keys.json")
+// - KEYS_MASTER_KEY: base64-encoded 32-byte key decrypting enc:v1: api_key values (default: "", none)
+// - KEYS_MASTER_KEY_FILE: file containing KEYS_MASTER_KEY, used when it is unset (default: "")
+// - KEYS_RELOAD_INTERVAL: seconds between checks of keys.json for changes (default: 5, 0 disables; SIGHUP always reloads)
+// - KEY_EXPIRY_WARNING_HOURS: warn in the log when a key expires within this many hours (default: 72)
+// - ADMIN_API_KEY: bearer token for the /admin key management API (default: "", disabled)
+// - JWT_JWKS_URL: JWKS endpoint whose keys sign accepted bearer JWTs (default: "", JWT auth disabled)
+// - JWT_JWKS_FILE: JWKS file, instead of JWT_JWKS_URL (default: "")
+// - JWT_JWKS_REFRESH_INTERVAL: seconds before signing keys are refetched (default: 3600)
+// - JWT_ISSUER: required iss claim (default: "", any)
+// - JWT_AUDIENCE: required aud claim (default: "", any)
+// - JWT_GROUPS_CLAIM: claim listing the caller's groups (default: "groups")
+// - JWT_LEEWAY_SECONDS: clock skew tolerated on exp and nbf (default: 60)
 // - SERVER_PORT: server port (default: "8080")
+// - TLS_CERT_FILE, TLS_KEY_FILE: serve HTTPS with this certificate and key (default: "", plain HTTP)
+// - TLS_CLIENT_CA_FILE: CA bundle verifying client certificates (default: "", not requested)
+// - TLS_CLIENT_AUTH: "none", "optional" or "require" a verified client certificate (default: "optional")
+// - TLS_RELOAD_INTERVAL: seconds between checks of the certificate files for changes (default: 30)
+// - TRUSTED_PROXIES: comma-separated CIDRs of proxies trusted to report the client IP (default: "", none)
+// - UPSTREAM_HEADER_ALLOWLIST: comma-separated client headers forwarded to providers, "*" for all (default: proxy.DefaultHeaderAllowlist)
+// - UPSTREAM_HEADER_DENYLIST: comma-separated client headers never forwarded (default: proxy.DefaultHeaderDenylist)
+// - UPSTREAM_EXTRA_HEADERS: JSON object of headers set per provider, e.g. {"openai": {"OpenAI-Organization": "org-..."}} (default: "")
+// - RESPONSE_HEADER_ALLOWLIST: comma-separated provider response headers passed back to clients, "*" for all (default: proxy.DefaultResponseHeaderAllowlist)
 // - LOG_TO_FILE: enable file logging (default: false)
 // - LOG_FILE_PATH: log file path (default: "gateway.log")
 // - QUOTA_ENABLED: enable rate limiting (default: true)
 // - QUOTA_LIMIT: max requests per hour per key (default: 100)
 // - REQUEST_TIMEOUT: request timeout in seconds (default: 30)
+// - QUOTA_CHARGED_OUTCOMES: comma-separated outcomes that consume quota (default: "success,upstream_error")
+// - QUOTA_BACKEND: "memory" or "redis" for quotas shared across replicas (default: "memory")
+// - REDIS_ADDR: redis host:port (default: "localhost:6379")
+// - REDIS_PASSWORD: redis password (default: "")
+// - REDIS_DB: redis database number (default: 0)
+// - REDIS_KEY_PREFIX: prefix for redis keys (default: "llmgateway:")
+// - REDIS_TIMEOUT_MS: redis command timeout in milliseconds (default: 200)
+// - USAGE_RETENTION_HOURS: hours of usage history kept for /usage (default: 720)
+// - PRICING_FILE: JSON file of model prices per million tokens, added to the built-in list (default: "")
+// - TRACING_ENABLED: export OpenTelemetry spans over OTLP/HTTP, configured by OTEL_EXPORTER_OTLP_* (default: false)
+// - OTEL_SERVICE_NAME: service name reported with spans (default: "llm-gateway")
+// - STATE_FILE_PATH: file to persist quotas, usage counters, latency histograms and /usage history across restarts (default: "", disabled)
+// - STATE_SAVE_INTERVAL: seconds between state snapshots (default: 60)
+// - SCHEDULER_MAX_CONCURRENT: max in-flight requests per provider (default: 0, unlimited)
+// - SCHEDULER_POLICY: "weighted" or "strict" priority dispatch (default: "weighted")
+// - SHUTDOWN_DRAIN_DELAY: seconds /ready reports draining before new connections are refused (default: 0)
+// - SHUTDOWN_TIMEOUT: seconds in-flight requests get to finish on shutdown (default: 60)
 func Load() (*Config, error) {
 	// Get keys file path from environment
 	keysFilePath := getEnvOrDefault("KEYS_FILE_PATH", "keys.json")
 
-	// Read keys.json file
-	data, err := os.ReadFile(keysFilePath)
+	keysConfig, err := LoadKeysFile(keysFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read keys file: %w", err)
+		return nil, err
 	}
 
-	// Parse JSON
-	var keysConfig models.KeysConfig
-	if err := json.Unmarshal(data, &keysConfig); err != nil {
-		return nil, fmt.Errorf("failed to parse keys file: %w", err)
-	}
-
-	// Validate that we have at least one virtual key
-	if len(keysConfig.VirtualKeys) == 0 {
-		return nil, fmt.Errorf("no virtual keys configured")
+	masterKey, err := LoadMasterKey()
+	if err != nil {
+		return nil, err
 	}
 
 	// Create config with all values from environment variables
 	cfg := &Config{
-		KeysConfig:     keysConfig,
-		ServerPort:     getEnvOrDefault("SERVER_PORT", "8080"),
+		KeysFilePath:       keysFilePath,
+		KeysMasterKey:      masterKey,
+		KeysReloadInterval: getEnvIntOrDefault("KEYS_RELOAD_INTERVAL", 5),
+		KeyExpiryWarning:   getEnvIntOrDefault("KEY_EXPIRY_WARNING_HOURS", 72),
+
+		AdminAPIKey: getEnvOrDefault("ADMIN_API_KEY", ""),
+
+		JWTJWKSURL:       getEnvOrDefault("JWT_JWKS_URL", ""),
+		JWTJWKSFile:      getEnvOrDefault("JWT_JWKS_FILE", ""),
+		JWTJWKSRefresh:   getEnvIntOrDefault("JWT_JWKS_REFRESH_INTERVAL", 3600),
+		JWTIssuer:        getEnvOrDefault("JWT_ISSUER", ""),
+		JWTAudience:      getEnvOrDefault("JWT_AUDIENCE", ""),
+		JWTGroupsClaim:   getEnvOrDefault("JWT_GROUPS_CLAIM", "groups"),
+		JWTLeewaySeconds: getEnvIntOrDefault("JWT_LEEWAY_SECONDS", 60),
+
+		ServerPort: getEnvOrDefault("SERVER_PORT", "8080"),
+
+		TLSCertFile:       getEnvOrDefault("TLS_CERT_FILE", ""),
+		TLSKeyFile:        getEnvOrDefault("TLS_KEY_FILE", ""),
+		TLSClientCAFile:   getEnvOrDefault("TLS_CLIENT_CA_FILE", ""),
+		TLSClientAuth:     getEnvOrDefault("TLS_CLIENT_AUTH", "optional"),
+		TLSReloadInterval: getEnvIntOrDefault("TLS_RELOAD_INTERVAL", 30),
+
 		LogToFile:      getEnvBoolOrDefault("LOG_TO_FILE", false),
 		LogFilePath:    getEnvOrDefault("LOG_FILE_PATH", "gateway.log"),
 		QuotaEnabled:   getEnvBoolOrDefault("QUOTA_ENABLED", true),
 		QuotaLimit:     getEnvInt64OrDefault("QUOTA_LIMIT", 100),
 		RequestTimeout: getEnvIntOrDefault("REQUEST_TIMEOUT", 30),
+
+		QuotaBackend:   getEnvOrDefault("QUOTA_BACKEND", "memory"),
+		RedisAddr:      getEnvOrDefault("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:  getEnvOrDefault("REDIS_PASSWORD", ""),
+		RedisDB:        getEnvIntOrDefault("REDIS_DB", 0),
+		RedisKeyPrefix: getEnvOrDefault("REDIS_KEY_PREFIX", "llmgateway:"),
+		RedisTimeoutMs: getEnvIntOrDefault("REDIS_TIMEOUT_MS", 200),
+
+		UsageRetentionHours: getEnvIntOrDefault("USAGE_RETENTION_HOURS", 720),
+		PricingFile:         getEnvOrDefault("PRICING_FILE", ""),
+
+		TracingEnabled:     getEnvBoolOrDefault("TRACING_ENABLED", false),
+		TracingServiceName: getEnvOrDefault("OTEL_SERVICE_NAME", "llm-gateway"),
+
+		StateFilePath:     getEnvOrDefault("STATE_FILE_PATH", ""),
+		StateSaveInterval: getEnvIntOrDefault("STATE_SAVE_INTERVAL", 60),
+
+		SchedulerMaxConcurrent: getEnvIntOrDefault("SCHEDULER_MAX_CONCURRENT", 0),
+		SchedulerPolicy:        getEnvOrDefault("SCHEDULER_POLICY", "weighted"),
+
+		ShutdownDrainDelay: getEnvIntOrDefault("SHUTDOWN_DRAIN_DELAY", 0),
+		ShutdownTimeout:    getEnvIntOrDefault("SHUTDOWN_TIMEOUT", 60),
+	}
+
+	// Resolve api_key references now so a missing secret fails startup
+	set, err := cfg.newKeySet(keysConfig)
+	if err != nil {
+		return nil, err
+	}
+	cfg.keys.Store(set)
+
+	if cfg.KeysReloadInterval < 0 {
+		return nil, fmt.Errorf("invalid KEYS_RELOAD_INTERVAL %d: must not be negative", cfg.KeysReloadInterval)
+	}
+
+	if cfg.KeyExpiryWarning < 0 {
+		return nil, fmt.Errorf("invalid KEY_EXPIRY_WARNING_HOURS %d: must not be negative", cfg.KeyExpiryWarning)
+	}
+
+	if cfg.JWTJWKSURL != "" && cfg.JWTJWKSFile != "" {
+		return nil, fmt.Errorf("JWT_JWKS_URL and JWT_JWKS_FILE are mutually exclusive")
+	}
+
+	if cfg.JWTJWKSRefresh <= 0 {
+		return nil, fmt.Errorf("invalid JWT_JWKS_REFRESH_INTERVAL %d: must be positive", cfg.JWTJWKSRefresh)
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if cfg.TLSClientCAFile != "" && cfg.TLSCertFile == "" {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	switch cfg.TLSClientAuth {
+	case "none", "optional":
+	case "require":
+		if cfg.TLSClientCAFile == "" {
+			return nil, fmt.Errorf("TLS_CLIENT_AUTH \"require\" needs TLS_CLIENT_CA_FILE")
+		}
+	default:
+		return nil, fmt.Errorf("invalid TLS_CLIENT_AUTH %q: must be \"none\", \"optional\" or \"require\"", cfg.TLSClientAuth)
+	}
+
+	if cfg.TLSReloadInterval <= 0 {
+		return nil, fmt.Errorf("invalid TLS_RELOAD_INTERVAL %d: must be positive", cfg.TLSReloadInterval)
+	}
+
+	if cfg.QuotaBackend != "memory" && cfg.QuotaBackend != "redis" {
+		return nil, fmt.Errorf("invalid QUOTA_BACKEND %q: must be \"memory\" or \"redis\"", cfg.QuotaBackend)
+	}
+
+	if cfg.StateSaveInterval <= 0 {
+		return nil, fmt.Errorf("invalid STATE_SAVE_INTERVAL %d: must be positive", cfg.StateSaveInterval)
+	}
+
+	if cfg.ShutdownDrainDelay < 0 {
+		return nil, fmt.Errorf("invalid SHUTDOWN_DRAIN_DELAY %d: must not be negative", cfg.ShutdownDrainDelay)
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %d: must be positive", cfg.ShutdownTimeout)
+	}
+
+	trustedProxies, err := clientip.ParsePrefixes(getEnvListOrDefault("TRUSTED_PROXIES", nil))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	cfg.TrustedProxies = trustedProxies
+
+	cfg.UpstreamHeaderAllowlist = getEnvListOrDefault("UPSTREAM_HEADER_ALLOWLIST", proxy.DefaultHeaderAllowlist)
+	cfg.UpstreamHeaderDenylist = getEnvListOrDefault("UPSTREAM_HEADER_DENYLIST", proxy.DefaultHeaderDenylist)
+	cfg.ResponseHeaderAllowlist = getEnvListOrDefault("RESPONSE_HEADER_ALLOWLIST", proxy.DefaultResponseHeaderAllowlist)
+	if value := os.Getenv("UPSTREAM_EXTRA_HEADERS"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.UpstreamExtraHeaders); err != nil {
+			return nil, fmt.Errorf("invalid UPSTREAM_EXTRA_HEADERS: %w", err)
+		}
+		for provider := range cfg.UpstreamExtraHeaders {
+			if provider.Endpoint() == "" {
+				return nil, fmt.Errorf("invalid UPSTREAM_EXTRA_HEADERS: unsupported provider %q", provider)
+			}
+		}
+	}
+
+	for _, value := range getEnvListOrDefault("QUOTA_CHARGED_OUTCOMES", []string{"success", "upstream_error"}) {
+		outcome := models.Outcome(value)
+		if !outcome.IsValid() {
+			return nil, fmt.Errorf("invalid QUOTA_CHARGED_OUTCOMES entry %q", value)
+		}
+		cfg.QuotaChargedOutcomes = append(cfg.QuotaChargedOutcomes, outcome)
+	}
+
+	if cfg.SchedulerPolicy != "weighted" && cfg.SchedulerPolicy != "strict" {
+		return nil, fmt.Errorf("invalid SCHEDULER_POLICY %q: must be \"weighted\" or \"strict\"", cfg.SchedulerPolicy)
 	}
 
 	return cfg, nil
 }
 
-// ValidateVirtualKey checks if a virtual key exists and returns its configuration
+// ValidateVirtualKey checks if a virtual key exists and is currently usable, and returns its configuration
 func (c *Config) ValidateVirtualKey(virtualKey string) (models.VirtualKeyConfig, bool) {
-	keyConfig, exists := c.KeysConfig.VirtualKeys[virtualKey]
-	return keyConfig, exists
+	_, keyConfig, err := c.AuthenticateVirtualKey(virtualKey)
+	return keyConfig, err == nil
+}
+
+// AuthenticateVirtualKey resolves a presented virtual key to its keys.json entry.
+// Plaintext entries are named by the key itself; hashed entries are named by the
+// key's lookup name and verified against key_hash in constant time. The returned
+// name identifies the key everywhere else so hashed keys never travel in plaintext.
+// Known keys that cannot be used right now fail with ErrKeyDisabled, ErrKeyExpired
+// or ErrKeyNotYetValid; anything else fails with ErrUnknownKey.
+func (c *Config) AuthenticateVirtualKey(virtualKey string) (string, models.VirtualKeyConfig, error) {
+	virtualKeys := c.KeysConfig().VirtualKeys
+
+	name := virtualKey
+	keyConfig, exists := virtualKeys[name]
+	if !exists || keyConfig.KeyHash != "" {
+		// A hashed entry must never match on its lookup name alone
+		name = KeyLookupName(virtualKey)
+		keyConfig, exists = virtualKeys[name]
+		if !exists || keyConfig.KeyHash == "" || !verifyKeyHash(keyConfig.KeyHash, virtualKey) {
+			return "", models.VirtualKeyConfig{}, ErrUnknownKey
+		}
+	}
+
+	if err := checkValidity(keyConfig, time.Now()); err != nil {
+		return "", models.VirtualKeyConfig{}, err
+	}
+	return name, keyConfig, nil
+}
+
+// JWTEnabled reports whether bearer JWTs are accepted alongside virtual keys
+func (c *Config) JWTEnabled() bool {
+	return c.JWTJWKSURL != "" || c.JWTJWKSFile != ""
+}
+
+// AuthenticateJWT maps the subject and groups of a verified JWT to the virtual key
+// whose profile the caller uses, by the first matching entry in jwt_mappings.
+// The mapped key's disabled and validity window still apply.
+func (c *Config) AuthenticateJWT(subject string, groups []string) (string, models.VirtualKeyConfig, error) {
+	keysConfig := c.KeysConfig()
+	for _, mapping := range keysConfig.JWTMappings {
+		if !mapping.Matches(subject, groups) {
+			continue
+		}
+		keyConfig, exists := keysConfig.VirtualKeys[mapping.VirtualKey]
+		if !exists {
+			break
+		}
+		if err := checkValidity(keyConfig, time.Now()); err != nil {
+			return "", models.VirtualKeyConfig{}, err
+		}
+		return mapping.VirtualKey, keyConfig, nil
+	}
+	return "", models.VirtualKeyConfig{}, ErrNoJWTMapping
+}
+
+// TLSEnabled reports whether the server speaks HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
+// AuthenticateClientCert maps a verified client certificate to a virtual key by the
+// first matching entry in cert_mappings. The mapped key's disabled and validity window still apply.
+func (c *Config) AuthenticateClientCert(cert *x509.Certificate) (string, models.VirtualKeyConfig, error) {
+	uris := make([]string, 0, len(cert.URIs))
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	keysConfig := c.KeysConfig()
+	for _, mapping := range keysConfig.CertMappings {
+		if !mapping.Matches(cert.Subject.CommonName, uris) {
+			continue
+		}
+		keyConfig, exists := keysConfig.VirtualKeys[mapping.VirtualKey]
+		if !exists {
+			break
+		}
+		if err := checkValidity(keyConfig, time.Now()); err != nil {
+			return "", models.VirtualKeyConfig{}, err
+		}
+		return mapping.VirtualKey, keyConfig, nil
+	}
+	return "", models.VirtualKeyConfig{}, ErrNoCertMapping
 }
 
 // Helper functions to get environment variables with defaults
@@ -76,6 +386,20 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvListOrDefault(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnvBoolOrDefault(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		return value == "true" || value == "1"
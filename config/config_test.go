@@ -1,6 +1,8 @@
 package config
 
 import (
+	"llmgateway/internal/models"
+	"net/netip"
 	"os"
 	"testing"
 
@@ -36,14 +38,14 @@ func TestLoad(t *testing.T) {
 	cfg, err := Load()
 	require.NoError(t, err)
 
-	assert.Len(t, cfg.KeysConfig.VirtualKeys, 2)
+	assert.Len(t, cfg.KeysConfig().VirtualKeys, 2)
 
-	openaiKey, exists := cfg.KeysConfig.VirtualKeys["vk_test_openai"]
+	openaiKey, exists := cfg.KeysConfig().VirtualKeys["vk_test_openai"]
 	assert.True(t, exists)
 	assert.Equal(t, "openai", string(openaiKey.Provider))
 	assert.Equal(t, "sk-test-key-123", openaiKey.APIKey)
 
-	anthropicKey, exists := cfg.KeysConfig.VirtualKeys["vk_test_anthropic"]
+	anthropicKey, exists := cfg.KeysConfig().VirtualKeys["vk_test_anthropic"]
 	assert.True(t, exists)
 	assert.Equal(t, "anthropic", string(anthropicKey.Provider))
 }
@@ -66,7 +68,7 @@ func TestLoadDefaultPath(t *testing.T) {
 
 	cfg, err := Load()
 	require.NoError(t, err)
-	assert.Len(t, cfg.KeysConfig.VirtualKeys, 1)
+	assert.Len(t, cfg.KeysConfig().VirtualKeys, 1)
 }
 
 func TestValidateVirtualKey(t *testing.T) {
@@ -170,3 +172,184 @@ func TestLoadEnvironmentVariables(t *testing.T) {
 	assert.Equal(t, int64(200), cfg.QuotaLimit)
 	assert.Equal(t, 60, cfg.RequestTimeout)
 }
+
+func TestLoadInvalidPriority(t *testing.T) {
+	testKeysJSON := `{
+		"virtual_keys": {
+			"vk_test": {
+				"provider": "openai",
+				"api_key": "sk-test-key",
+				"priority": "urgent"
+			}
+		}
+	}`
+
+	tmpFile, err := os.CreateTemp("", "keys-*.json")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.Write([]byte(testKeysJSON))
+	tmpFile.Close()
+
+	os.Setenv("KEYS_FILE_PATH", tmpFile.Name())
+	defer os.Unsetenv("KEYS_FILE_PATH")
+
+	_, err = Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown priority")
+}
+
+func TestLoadQuotaChargedOutcomes(t *testing.T) {
+	testKeysJSON := `{"virtual_keys": {"vk_test": {"provider": "openai", "api_key": "sk-test-key"}}}`
+
+	tmpFile, err := os.CreateTemp("", "keys-*.json")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.Write([]byte(testKeysJSON))
+	tmpFile.Close()
+
+	os.Setenv("KEYS_FILE_PATH", tmpFile.Name())
+	defer os.Unsetenv("KEYS_FILE_PATH")
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, []models.Outcome{models.OutcomeSuccess, models.OutcomeUpstreamError}, cfg.QuotaChargedOutcomes)
+
+	os.Setenv("QUOTA_CHARGED_OUTCOMES", "success, timeout")
+	defer os.Unsetenv("QUOTA_CHARGED_OUTCOMES")
+
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, []models.Outcome{models.OutcomeSuccess, models.OutcomeTimeout}, cfg.QuotaChargedOutcomes)
+
+	os.Setenv("QUOTA_CHARGED_OUTCOMES", "success,bogus")
+	_, err = Load()
+	require.Error(t, err)
+}
+
+func TestLoadTLSSettings(t *testing.T) {
+	testKeysJSON := `{"virtual_keys": {"vk_test": {"provider": "openai", "api_key": "sk-test-key"}}}`
+
+	tmpFile, err := os.CreateTemp("", "keys-*.json")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.Write([]byte(testKeysJSON))
+	tmpFile.Close()
+	t.Setenv("KEYS_FILE_PATH", tmpFile.Name())
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.False(t, cfg.TLSEnabled())
+	assert.Equal(t, "optional", cfg.TLSClientAuth)
+
+	t.Setenv("TLS_CERT_FILE", "/etc/gateway/tls.crt")
+	_, err = Load()
+	assert.ErrorContains(t, err, "must be set together")
+
+	t.Setenv("TLS_KEY_FILE", "/etc/gateway/tls.key")
+	t.Setenv("TLS_CLIENT_AUTH", "require")
+	_, err = Load()
+	assert.ErrorContains(t, err, "needs TLS_CLIENT_CA_FILE")
+
+	t.Setenv("TLS_CLIENT_CA_FILE", "/etc/gateway/clients.crt")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.True(t, cfg.TLSEnabled())
+
+	t.Setenv("TLS_CLIENT_AUTH", "sometimes")
+	_, err = Load()
+	assert.ErrorContains(t, err, "invalid TLS_CLIENT_AUTH")
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	testKeysJSON := `{"virtual_keys": {"vk_test": {"provider": "openai", "api_key": "sk-test-key"}}}`
+
+	tmpFile, err := os.CreateTemp("", "keys-*.json")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.Write([]byte(testKeysJSON))
+	tmpFile.Close()
+	t.Setenv("KEYS_FILE_PATH", tmpFile.Name())
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.TrustedProxies)
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}, cfg.TrustedProxies)
+
+	t.Setenv("TRUSTED_PROXIES", "load-balancer")
+	_, err = Load()
+	assert.ErrorContains(t, err, "invalid TRUSTED_PROXIES")
+}
+
+func TestLoadUpstreamHeaderSettings(t *testing.T) {
+	testKeysJSON := `{"virtual_keys": {"vk_test": {"provider": "openai", "api_key": "sk-test-key"}}}`
+
+	tmpFile, err := os.CreateTemp("", "keys-*.json")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.Write([]byte(testKeysJSON))
+	tmpFile.Close()
+	t.Setenv("KEYS_FILE_PATH", tmpFile.Name())
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Contains(t, cfg.UpstreamHeaderAllowlist, "Anthropic-Beta")
+	assert.Contains(t, cfg.UpstreamHeaderDenylist, "Cookie")
+	assert.Empty(t, cfg.UpstreamExtraHeaders)
+
+	t.Setenv("UPSTREAM_HEADER_ALLOWLIST", "*")
+	t.Setenv("UPSTREAM_EXTRA_HEADERS", `{"openai": {"OpenAI-Organization": "org-123"}}`)
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*"}, cfg.UpstreamHeaderAllowlist)
+	assert.Equal(t, map[models.Provider]map[string]string{models.ProviderOpenAI: {"OpenAI-Organization": "org-123"}}, cfg.UpstreamExtraHeaders)
+
+	t.Setenv("UPSTREAM_EXTRA_HEADERS", `{"mistral": {"X-Org": "1"}}`)
+	_, err = Load()
+	assert.ErrorContains(t, err, "unsupported provider")
+
+	t.Setenv("UPSTREAM_EXTRA_HEADERS", `not json`)
+	_, err = Load()
+	assert.ErrorContains(t, err, "invalid UPSTREAM_EXTRA_HEADERS")
+}
+
+func TestLoadShutdownSettings(t *testing.T) {
+	testKeysJSON := `{"virtual_keys": {"vk_test": {"provider": "openai", "api_key": "sk-test-key"}}}`
+
+	tmpFile, err := os.CreateTemp("", "keys-*.json")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	tmpFile.Write([]byte(testKeysJSON))
+	tmpFile.Close()
+	t.Setenv("KEYS_FILE_PATH", tmpFile.Name())
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, 0, cfg.ShutdownDrainDelay)
+	assert.Equal(t, 60, cfg.ShutdownTimeout)
+
+	t.Setenv("SHUTDOWN_DRAIN_DELAY", "10")
+	t.Setenv("SHUTDOWN_TIMEOUT", "120")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Equal(t, 10, cfg.ShutdownDrainDelay)
+	assert.Equal(t, 120, cfg.ShutdownTimeout)
+
+	t.Setenv("SHUTDOWN_TIMEOUT", "0")
+	_, err = Load()
+	assert.ErrorContains(t, err, "invalid SHUTDOWN_TIMEOUT")
+
+	t.Setenv("SHUTDOWN_TIMEOUT", "60")
+	t.Setenv("SHUTDOWN_DRAIN_DELAY", "-1")
+	_, err = Load()
+	assert.ErrorContains(t, err, "invalid SHUTDOWN_DRAIN_DELAY")
+}
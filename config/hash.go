@@ -0,0 +1,71 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// keyHashScheme prefixes stored key hashes so the algorithm can change later
+const keyHashScheme = "sha256"
+
+// MintVirtualKey generates a new random virtual key of the form vk_<id>_<secret>.
+// The returned lookup name is the vk_<id> part under which its hash is stored.
+func MintVirtualKey() (virtualKey, lookupName string) {
+	var id [6]byte
+	var secret [24]byte
+	rand.Read(id[:])
+	rand.Read(secret[:])
+	lookupName = "vk_" + hex.EncodeToString(id[:])
+	return lookupName + "_" + hex.EncodeToString(secret[:]), lookupName
+}
+
+// KeyLookupName returns the part of a virtual key that names its hashed entry in
+// keys.json: everything before the last underscore
+func KeyLookupName(virtualKey string) string {
+	if i := strings.LastIndex(virtualKey, "_"); i > 0 {
+		return virtualKey[:i]
+	}
+	return ""
+}
+
+// HashVirtualKey returns a salted hash of a virtual key for the key_hash field
+func HashVirtualKey(virtualKey string) string {
+	var salt [16]byte
+	rand.Read(salt[:])
+	return fmt.Sprintf("%s:%s:%s", keyHashScheme, hex.EncodeToString(salt[:]), hex.EncodeToString(digestKey(salt[:], virtualKey)))
+}
+
+// verifyKeyHash checks a virtual key against a stored hash in constant time
+func verifyKeyHash(keyHash, virtualKey string) bool {
+	salt, digest, err := parseKeyHash(keyHash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(digest, digestKey(salt, virtualKey)) == 1
+}
+
+// parseKeyHash splits a stored hash into its salt and digest
+func parseKeyHash(keyHash string) (salt, digest []byte, err error) {
+	parts := strings.Split(keyHash, ":")
+	if len(parts) != 3 || parts[0] != keyHashScheme {
+		return nil, nil, fmt.Errorf("key_hash must have the form %s:<salt>:<digest>", keyHashScheme)
+	}
+	if salt, err = hex.DecodeString(parts[1]); err != nil || len(salt) == 0 {
+		return nil, nil, fmt.Errorf("key_hash has an invalid salt")
+	}
+	if digest, err = hex.DecodeString(parts[2]); err != nil || len(digest) != sha256.Size {
+		return nil, nil, fmt.Errorf("key_hash has an invalid digest")
+	}
+	return salt, digest, nil
+}
+
+func digestKey(salt []byte, virtualKey string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(virtualKey))
+	return h.Sum(nil)
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"llmgateway/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMintVirtualKey(t *testing.T) {
+	virtualKey, lookupName := MintVirtualKey()
+
+	assert.True(t, strings.HasPrefix(virtualKey, lookupName+"_"))
+	assert.Equal(t, lookupName, KeyLookupName(virtualKey))
+
+	other, _ := MintVirtualKey()
+	assert.NotEqual(t, virtualKey, other)
+}
+
+func TestHashVirtualKeyIsSalted(t *testing.T) {
+	virtualKey, _ := MintVirtualKey()
+
+	first, second := HashVirtualKey(virtualKey), HashVirtualKey(virtualKey)
+	assert.NotEqual(t, first, second)
+	assert.True(t, verifyKeyHash(first, virtualKey))
+	assert.True(t, verifyKeyHash(second, virtualKey))
+	assert.False(t, verifyKeyHash(first, virtualKey+"x"))
+	assert.False(t, verifyKeyHash("sha256:zz:00", virtualKey))
+}
+
+func TestAuthenticateHashedKey(t *testing.T) {
+	virtualKey, lookupName := MintVirtualKey()
+	cfg := loadFromKeysJSON(t, `{"virtual_keys": {
+		"vk_plain": {"provider": "openai", "api_key": "sk-1"},
+		"`+lookupName+`": {"provider": "anthropic", "api_key": "sk-2", "key_hash": "`+HashVirtualKey(virtualKey)+`"}
+	}}`)
+
+	name, keyConfig, err := cfg.AuthenticateVirtualKey(virtualKey)
+	require.NoError(t, err)
+	assert.Equal(t, lookupName, name)
+	assert.Equal(t, models.ProviderAnthropic, keyConfig.Provider)
+
+	// The lookup name alone, or a wrong secret, must not authenticate
+	_, _, err = cfg.AuthenticateVirtualKey(lookupName)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, _, err = cfg.AuthenticateVirtualKey(lookupName + "_" + strings.Repeat("0", 48))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// Plaintext entries keep working
+	name, _, err = cfg.AuthenticateVirtualKey("vk_plain")
+	assert.NoError(t, err)
+	assert.Equal(t, "vk_plain", name)
+}
+
+func TestLoadRejectsMalformedKeyHash(t *testing.T) {
+	for _, keyHash := range []string{"md5:00:00", "sha256:nothex:00", "sha256:00ff:abcd"} {
+		err := ValidateKeys(models.KeysConfig{VirtualKeys: map[string]models.VirtualKeyConfig{
+			"vk_abc": {Provider: models.ProviderOpenAI, APIKey: "sk", KeyHash: keyHash},
+		}})
+		assert.Error(t, err, keyHash)
+	}
+}
@@ -0,0 +1,355 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"llmgateway/internal/clientip"
+	"llmgateway/internal/fileutil"
+	"llmgateway/internal/models"
+	"llmgateway/internal/policy"
+	"maps"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ErrInvalidKeys marks a key set that was rejected by validation
+var ErrInvalidKeys = errors.New("invalid key set")
+
+// Reasons a presented virtual key is rejected
+var (
+	ErrUnknownKey     = errors.New("invalid virtual key")
+	ErrKeyDisabled    = errors.New("virtual key is disabled")
+	ErrKeyExpired     = errors.New("virtual key has expired")
+	ErrKeyNotYetValid = errors.New("virtual key is not yet valid")
+	ErrNoJWTMapping   = errors.New("token is not mapped to a virtual key")
+	ErrNoCertMapping  = errors.New("client certificate is not mapped to a virtual key")
+)
+
+// ExpiringKey is a key whose expires_at falls within the warning window
+type ExpiringKey struct {
+	Key       string    `json:"key"` // Masked
+	Name      string    `json:"name,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// KeysDiff lists the virtual keys that differ between two key sets, along with
+// organizations and teams as "org:ID" and "team:ID", and "jwt_mappings" or "cert_mappings" if those changed. Keys are masked so the diff is safe to log.
+type KeysDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Empty reports whether the two key sets were identical
+func (d KeysDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// LoadKeysFile reads and validates a keys.json file
+func LoadKeysFile(path string) (models.KeysConfig, error) {
+	// Read keys.json file
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return models.KeysConfig{}, fmt.Errorf("failed to read keys file: %w", err)
+	}
+
+	// Parse JSON
+	var keysConfig models.KeysConfig
+	if err := json.Unmarshal(data, &keysConfig); err != nil {
+		return models.KeysConfig{}, fmt.Errorf("failed to parse keys file: %w", err)
+	}
+
+	if err := ValidateKeys(keysConfig); err != nil {
+		return models.KeysConfig{}, err
+	}
+	return keysConfig, nil
+}
+
+// ValidateKeys checks a key set before it is put into service
+func ValidateKeys(keysConfig models.KeysConfig) error {
+	// Validate that we have at least one virtual key
+	if len(keysConfig.VirtualKeys) == 0 {
+		return fmt.Errorf("no virtual keys configured")
+	}
+
+	for virtualKey, keyConfig := range keysConfig.VirtualKeys {
+		if keyConfig.Provider.Endpoint() == "" {
+			return fmt.Errorf("virtual key %s has unsupported provider %q", models.MaskKey(virtualKey), keyConfig.Provider)
+		}
+		// Validate priority classes
+		if !keyConfig.Priority.IsValid() {
+			return fmt.Errorf("virtual key %s has unknown priority %q", models.MaskKey(virtualKey), keyConfig.Priority)
+		}
+		if keyConfig.KeyHash != "" {
+			if _, _, err := parseKeyHash(keyConfig.KeyHash); err != nil {
+				return fmt.Errorf("virtual key %s: %w", models.MaskKey(virtualKey), err)
+			}
+		}
+		if err := policy.Validate(keyConfig.Policy); err != nil {
+			return fmt.Errorf("virtual key %s: policy: %w", models.MaskKey(virtualKey), err)
+		}
+		if keyConfig.NotBefore != nil && keyConfig.ExpiresAt != nil && !keyConfig.NotBefore.Before(*keyConfig.ExpiresAt) {
+			return fmt.Errorf("virtual key %s: not_before must be before expires_at", models.MaskKey(virtualKey))
+		}
+		if _, exists := keysConfig.Teams[keyConfig.Team]; keyConfig.Team != "" && !exists {
+			return fmt.Errorf("virtual key %s: unknown team %q", models.MaskKey(virtualKey), keyConfig.Team)
+		}
+		if _, err := clientip.ParsePrefixes(keyConfig.AllowedCIDRs); err != nil {
+			return fmt.Errorf("virtual key %s: allowed_cidrs: %w", models.MaskKey(virtualKey), err)
+		}
+	}
+
+	for i, mapping := range keysConfig.JWTMappings {
+		if mapping.Subject == "" && mapping.Group == "" {
+			return fmt.Errorf("jwt_mappings[%d]: subject or group is required", i)
+		}
+		if _, exists := keysConfig.VirtualKeys[mapping.VirtualKey]; !exists {
+			return fmt.Errorf("jwt_mappings[%d]: unknown virtual key %s", i, models.MaskKey(mapping.VirtualKey))
+		}
+	}
+
+	for i, mapping := range keysConfig.CertMappings {
+		if mapping.CommonName == "" && mapping.URI == "" {
+			return fmt.Errorf("cert_mappings[%d]: common_name or uri is required", i)
+		}
+		if _, exists := keysConfig.VirtualKeys[mapping.VirtualKey]; !exists {
+			return fmt.Errorf("cert_mappings[%d]: unknown virtual key %s", i, models.MaskKey(mapping.VirtualKey))
+		}
+	}
+
+	for id, orgConfig := range keysConfig.Organizations {
+		if err := validateGroup(orgConfig); err != nil {
+			return fmt.Errorf("organization %q: %w", id, err)
+		}
+	}
+	for id, teamConfig := range keysConfig.Teams {
+		if _, exists := keysConfig.Organizations[teamConfig.Organization]; teamConfig.Organization != "" && !exists {
+			return fmt.Errorf("team %q: unknown organization %q", id, teamConfig.Organization)
+		}
+		if err := validateGroup(teamConfig.GroupConfig); err != nil {
+			return fmt.Errorf("team %q: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// validateGroup checks the limits of an organization or team
+func validateGroup(group models.GroupConfig) error {
+	if group.QuotaLimit < 0 {
+		return fmt.Errorf("quota_limit must be non-negative")
+	}
+	if group.BudgetUSD < 0 {
+		return fmt.Errorf("budget_usd must be non-negative")
+	}
+	if err := policy.Validate(group.Policy); err != nil {
+		return fmt.Errorf("policy: %w", err)
+	}
+	return nil
+}
+
+// inheritMetadata merges each key's metadata over its team's and organization's,
+// so the resolved key carries every label that applies to it
+func inheritMetadata(keysConfig models.KeysConfig) {
+	for virtualKey, keyConfig := range keysConfig.VirtualKeys {
+		teamConfig, orgConfig := keysConfig.Lineage(keyConfig.Team)
+		if teamConfig == nil {
+			continue
+		}
+
+		metadata := make(map[string]string)
+		if orgConfig != nil {
+			maps.Copy(metadata, orgConfig.Metadata)
+		}
+		maps.Copy(metadata, teamConfig.Metadata)
+		maps.Copy(metadata, keyConfig.Metadata)
+		if len(metadata) == 0 {
+			continue
+		}
+		keyConfig.Metadata = metadata
+		keysConfig.VirtualKeys[virtualKey] = keyConfig
+	}
+}
+
+// keySet is a key set as written in the keys file alongside its resolved form
+type keySet struct {
+	raw      models.KeysConfig // As on file, with secret references intact
+	resolved models.KeysConfig // api_key values replaced by the secrets they reference
+}
+
+// KeysConfig returns the key set currently in service, with provider API keys resolved
+func (c *Config) KeysConfig() models.KeysConfig {
+	return c.keys.Load().resolved
+}
+
+// RawKeysConfig returns the key set currently in service as written in the keys file,
+// with secret references such as env:NAME left unresolved
+func (c *Config) RawKeysConfig() models.KeysConfig {
+	return c.keys.Load().raw
+}
+
+// SetKeysConfig validates a key set and atomically puts it into service.
+// Requests already past authentication keep the key config they were given.
+func (c *Config) SetKeysConfig(keysConfig models.KeysConfig) (KeysDiff, error) {
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+
+	set, err := c.newKeySet(keysConfig)
+	if err != nil {
+		return KeysDiff{}, err
+	}
+	return c.install(set), nil
+}
+
+// UpdateKeys applies update to a copy of the current key set, writes the result to
+// the keys file and puts it into service. Updates are serialized with each other and
+// with reloads; if update fails or the result is invalid, nothing changes.
+func (c *Config) UpdateKeys(update func(virtualKeys map[string]models.VirtualKeyConfig) error) (KeysDiff, error) {
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+
+	keysConfig := c.RawKeysConfig()
+	keysConfig.VirtualKeys = maps.Clone(keysConfig.VirtualKeys)
+	if err := update(keysConfig.VirtualKeys); err != nil {
+		return KeysDiff{}, err
+	}
+	set, err := c.newKeySet(keysConfig)
+	if err != nil {
+		return KeysDiff{}, fmt.Errorf("%w: %w", ErrInvalidKeys, err)
+	}
+
+	data, err := json.MarshalIndent(keysConfig, "", "  ")
+	if err != nil {
+		return KeysDiff{}, fmt.Errorf("failed to marshal keys: %w", err)
+	}
+	if err := fileutil.WriteFileAtomic(c.KeysFilePath, append(data, '\n'), 0600); err != nil {
+		return KeysDiff{}, fmt.Errorf("failed to write keys file: %w", err)
+	}
+
+	return c.install(set), nil
+}
+
+// ReloadKeys re-reads the keys file. If the new contents are invalid the
+// current key set stays in service and the error is returned.
+func (c *Config) ReloadKeys() (KeysDiff, error) {
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+
+	keysConfig, err := LoadKeysFile(c.KeysFilePath)
+	if err != nil {
+		return KeysDiff{}, err
+	}
+	set, err := c.newKeySet(keysConfig)
+	if err != nil {
+		return KeysDiff{}, err
+	}
+	return c.install(set), nil
+}
+
+// newKeySet validates a key set and resolves its secret references
+func (c *Config) newKeySet(keysConfig models.KeysConfig) (*keySet, error) {
+	if err := ValidateKeys(keysConfig); err != nil {
+		return nil, err
+	}
+	resolved, err := ResolveKeys(keysConfig, c.KeysMasterKey)
+	if err != nil {
+		return nil, err
+	}
+	inheritMetadata(resolved)
+	return &keySet{raw: keysConfig, resolved: resolved}, nil
+}
+
+// install puts a key set into service. Caller must hold c.keysMu.
+func (c *Config) install(set *keySet) KeysDiff {
+	previous := c.keys.Swap(set)
+	if previous == nil {
+		previous = &keySet{}
+	}
+	// Resolved values are compared so a rotated file: secret shows up as a change
+	return DiffKeys(previous.resolved, set.resolved)
+}
+
+// ExpiringKeys lists enabled keys that expire within the given window, soonest first
+func (c *Config) ExpiringKeys(within time.Duration) []ExpiringKey {
+	now := time.Now()
+	var expiring []ExpiringKey
+	for virtualKey, keyConfig := range c.KeysConfig().VirtualKeys {
+		if keyConfig.Disabled || keyConfig.ExpiresAt == nil {
+			continue
+		}
+		if keyConfig.ExpiresAt.After(now) && keyConfig.ExpiresAt.Before(now.Add(within)) {
+			expiring = append(expiring, ExpiringKey{
+				Key:       models.MaskKey(virtualKey),
+				Name:      keyConfig.Name,
+				ExpiresAt: *keyConfig.ExpiresAt,
+			})
+		}
+	}
+	sort.Slice(expiring, func(i, j int) bool { return expiring[i].ExpiresAt.Before(expiring[j].ExpiresAt) })
+	return expiring
+}
+
+// checkValidity reports why a known key cannot be used at the given time, if it cannot
+func checkValidity(keyConfig models.VirtualKeyConfig, now time.Time) error {
+	switch {
+	case keyConfig.Disabled:
+		return ErrKeyDisabled
+	case keyConfig.ExpiresAt != nil && !now.Before(*keyConfig.ExpiresAt):
+		return fmt.Errorf("%w (expired at %s)", ErrKeyExpired, keyConfig.ExpiresAt.UTC().Format(time.RFC3339))
+	case keyConfig.NotBefore != nil && now.Before(*keyConfig.NotBefore):
+		return fmt.Errorf("%w (valid from %s)", ErrKeyNotYetValid, keyConfig.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// DiffKeys compares two key sets
+func DiffKeys(previous, current models.KeysConfig) KeysDiff {
+	var diff KeysDiff
+	for virtualKey, keyConfig := range current.VirtualKeys {
+		old, exists := previous.VirtualKeys[virtualKey]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, models.MaskKey(virtualKey))
+		case !reflect.DeepEqual(old, keyConfig):
+			diff.Changed = append(diff.Changed, models.MaskKey(virtualKey))
+		}
+	}
+	for virtualKey := range previous.VirtualKeys {
+		if _, exists := current.VirtualKeys[virtualKey]; !exists {
+			diff.Removed = append(diff.Removed, models.MaskKey(virtualKey))
+		}
+	}
+	diffGroups(&diff, "org:", previous.Organizations, current.Organizations)
+	diffGroups(&diff, "team:", previous.Teams, current.Teams)
+	if !reflect.DeepEqual(previous.JWTMappings, current.JWTMappings) {
+		diff.Changed = append(diff.Changed, "jwt_mappings")
+	}
+	if !reflect.DeepEqual(previous.CertMappings, current.CertMappings) {
+		diff.Changed = append(diff.Changed, "cert_mappings")
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// diffGroups adds the organizations or teams that differ to diff, labelled with prefix
+func diffGroups[G any](diff *KeysDiff, prefix string, previous, current map[string]G) {
+	for id, group := range current {
+		old, exists := previous[id]
+		switch {
+		case !exists:
+			diff.Added = append(diff.Added, prefix+id)
+		case !reflect.DeepEqual(old, group):
+			diff.Changed = append(diff.Changed, prefix+id)
+		}
+	}
+	for id := range previous {
+		if _, exists := current[id]; !exists {
+			diff.Removed = append(diff.Removed, prefix+id)
+		}
+	}
+}
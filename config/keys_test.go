@@ -0,0 +1,356 @@
+package config
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"llmgateway/internal/models"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadFromKeysJSON(t *testing.T, keysJSON string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(keysJSON), 0644))
+
+	os.Setenv("KEYS_FILE_PATH", path)
+	t.Cleanup(func() { os.Unsetenv("KEYS_FILE_PATH") })
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestReloadKeys(t *testing.T) {
+	cfg := loadFromKeysJSON(t, `{"virtual_keys": {
+		"vk_kept_key_0001": {"provider": "openai", "api_key": "sk-1"},
+		"vk_changed_0002": {"provider": "openai", "api_key": "sk-2"},
+		"vk_removed_0003": {"provider": "anthropic", "api_key": "sk-3"}
+	}}`)
+
+	require.NoError(t, os.WriteFile(cfg.KeysFilePath, []byte(`{"virtual_keys": {
+		"vk_kept_key_0001": {"provider": "openai", "api_key": "sk-1"},
+		"vk_changed_0002": {"provider": "openai", "api_key": "sk-2-rotated"},
+		"vk_added_key_0004": {"provider": "anthropic", "api_key": "sk-4"}
+	}}`), 0644))
+
+	diff, err := cfg.ReloadKeys()
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{models.MaskKey("vk_added_key_0004")}, diff.Added)
+	assert.Equal(t, []string{models.MaskKey("vk_removed_0003")}, diff.Removed)
+	assert.Equal(t, []string{models.MaskKey("vk_changed_0002")}, diff.Changed)
+
+	_, valid := cfg.ValidateVirtualKey("vk_removed_0003")
+	assert.False(t, valid)
+	keyConfig, valid := cfg.ValidateVirtualKey("vk_changed_0002")
+	assert.True(t, valid)
+	assert.Equal(t, "sk-2-rotated", keyConfig.APIKey)
+}
+
+func TestReloadKeysKeepsCurrentOnInvalidFile(t *testing.T) {
+	cfg := loadFromKeysJSON(t, `{"virtual_keys": {"vk_valid": {"provider": "openai", "api_key": "sk-1"}}}`)
+
+	for _, contents := range []string{
+		`{"virtual_keys": {`,
+		`{"virtual_keys": {}}`,
+		`{"virtual_keys": {"vk_new": {"provider": "openai", "api_key": "sk", "priority": "urgent"}}}`,
+		`{"virtual_keys": {"vk_new": {"provider": "gemini", "api_key": "sk"}}}`,
+	} {
+		require.NoError(t, os.WriteFile(cfg.KeysFilePath, []byte(contents), 0644))
+
+		_, err := cfg.ReloadKeys()
+		require.Error(t, err, contents)
+
+		_, valid := cfg.ValidateVirtualKey("vk_valid")
+		assert.True(t, valid)
+		assert.Len(t, cfg.KeysConfig().VirtualKeys, 1)
+	}
+}
+
+func TestSetKeysConfigConcurrentWithLookups(t *testing.T) {
+	cfg := loadFromKeysJSON(t, `{"virtual_keys": {"vk_a": {"provider": "openai", "api_key": "sk-a"}}}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				keyConfig, valid := cfg.ValidateVirtualKey("vk_a")
+				assert.True(t, valid)
+				assert.Equal(t, models.ProviderOpenAI, keyConfig.Provider)
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		_, err := cfg.SetKeysConfig(models.KeysConfig{VirtualKeys: map[string]models.VirtualKeyConfig{
+			"vk_a": {Provider: models.ProviderOpenAI, APIKey: "sk-a"},
+		}})
+		require.NoError(t, err)
+	}
+	wg.Wait()
+}
+
+func TestDiffKeysIdentical(t *testing.T) {
+	keys := models.KeysConfig{VirtualKeys: map[string]models.VirtualKeyConfig{
+		"vk_a": {Provider: models.ProviderOpenAI, APIKey: "sk-a"},
+	}}
+	assert.True(t, DiffKeys(keys, keys).Empty())
+}
+
+func TestAuthenticateValidityWindow(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	cfg := loadFromKeysJSON(t, `{"virtual_keys": {
+		"vk_active": {"provider": "openai", "api_key": "sk", "not_before": "`+past+`", "expires_at": "`+future+`"},
+		"vk_disabled": {"provider": "openai", "api_key": "sk", "disabled": true},
+		"vk_expired": {"provider": "openai", "api_key": "sk", "expires_at": "`+past+`"},
+		"vk_pending": {"provider": "openai", "api_key": "sk", "not_before": "`+future+`"}
+	}}`)
+
+	_, _, err := cfg.AuthenticateVirtualKey("vk_active")
+	assert.NoError(t, err)
+
+	_, _, err = cfg.AuthenticateVirtualKey("vk_disabled")
+	assert.ErrorIs(t, err, ErrKeyDisabled)
+
+	_, _, err = cfg.AuthenticateVirtualKey("vk_expired")
+	assert.ErrorIs(t, err, ErrKeyExpired)
+	assert.Contains(t, err.Error(), past)
+
+	_, _, err = cfg.AuthenticateVirtualKey("vk_pending")
+	assert.ErrorIs(t, err, ErrKeyNotYetValid)
+	assert.Contains(t, err.Error(), future)
+
+	_, _, err = cfg.AuthenticateVirtualKey("vk_missing")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestValidateKeysRejectsInvertedWindow(t *testing.T) {
+	start := time.Now()
+	end := start.Add(-time.Minute)
+	err := ValidateKeys(models.KeysConfig{VirtualKeys: map[string]models.VirtualKeyConfig{
+		"vk_a": {Provider: models.ProviderOpenAI, APIKey: "sk", NotBefore: &start, ExpiresAt: &end},
+	}})
+	assert.Error(t, err)
+}
+
+func TestExpiringKeys(t *testing.T) {
+	soon := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	sooner := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	later := time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	cfg := loadFromKeysJSON(t, `{"virtual_keys": {
+		"vk_contractor_01": {"provider": "openai", "api_key": "sk", "name": "contractor", "expires_at": "`+soon+`"},
+		"vk_hackathon_02": {"provider": "openai", "api_key": "sk", "expires_at": "`+sooner+`"},
+		"vk_disabled_03": {"provider": "openai", "api_key": "sk", "expires_at": "`+soon+`", "disabled": true},
+		"vk_later_key_04": {"provider": "openai", "api_key": "sk", "expires_at": "`+later+`"},
+		"vk_expired_k_05": {"provider": "openai", "api_key": "sk", "expires_at": "`+past+`"},
+		"vk_permanent_06": {"provider": "openai", "api_key": "sk"}
+	}}`)
+
+	expiring := cfg.ExpiringKeys(72 * time.Hour)
+	require.Len(t, expiring, 2)
+	assert.Equal(t, models.MaskKey("vk_hackathon_02"), expiring[0].Key)
+	assert.Equal(t, models.MaskKey("vk_contractor_01"), expiring[1].Key)
+	assert.Equal(t, "contractor", expiring[1].Name)
+}
+
+func TestLoadRejectsInvalidPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {
+		"vk_a": {"provider": "openai", "api_key": "sk", "policy": {"allowed_models": ["gpt-["]}}
+	}}`), 0600))
+	t.Setenv("KEYS_FILE_PATH", path)
+
+	_, err := Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid model pattern")
+}
+
+func TestValidateKeysHierarchy(t *testing.T) {
+	key := func(team string) map[string]models.VirtualKeyConfig {
+		return map[string]models.VirtualKeyConfig{"vk_a": {Provider: models.ProviderOpenAI, APIKey: "sk", Team: team}}
+	}
+
+	for name, tc := range map[string]struct {
+		keysConfig models.KeysConfig
+		err        string
+	}{
+		"unknown team": {
+			keysConfig: models.KeysConfig{VirtualKeys: key("search")},
+			err:        `unknown team "search"`,
+		},
+		"unknown organization": {
+			keysConfig: models.KeysConfig{
+				VirtualKeys: key("search"),
+				Teams:       map[string]models.TeamConfig{"search": {Organization: "acme"}},
+			},
+			err: `unknown organization "acme"`,
+		},
+		"negative budget": {
+			keysConfig: models.KeysConfig{
+				VirtualKeys:   key(""),
+				Organizations: map[string]models.GroupConfig{"acme": {BudgetUSD: -1}},
+			},
+			err: "budget_usd must be non-negative",
+		},
+		"invalid team policy": {
+			keysConfig: models.KeysConfig{
+				VirtualKeys: key(""),
+				Teams:       map[string]models.TeamConfig{"search": {GroupConfig: models.GroupConfig{Policy: &models.RequestPolicy{MaxTokens: -1}}}},
+			},
+			err: `team "search": policy`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateKeys(tc.keysConfig)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestKeysInheritMetadata(t *testing.T) {
+	cfg := loadFromKeysJSON(t, `{
+		"organizations": {"acme": {"metadata": {"cost_center": "100", "env": "prod"}}},
+		"teams": {"search": {"organization": "acme", "quota_limit": 50, "metadata": {"owner": "search@acme"}}},
+		"virtual_keys": {
+			"vk_search_0001": {"provider": "openai", "api_key": "sk", "team": "search", "metadata": {"env": "staging"}},
+			"vk_loner_00002": {"provider": "openai", "api_key": "sk"}
+		}
+	}`)
+
+	keyConfig, valid := cfg.ValidateVirtualKey("vk_search_0001")
+	require.True(t, valid)
+	assert.Equal(t, map[string]string{"cost_center": "100", "env": "staging", "owner": "search@acme"}, keyConfig.Metadata)
+	assert.Equal(t, map[string]string{"env": "staging"}, cfg.RawKeysConfig().VirtualKeys["vk_search_0001"].Metadata,
+		"inherited labels are not written back to the keys file")
+
+	keyConfig, _ = cfg.ValidateVirtualKey("vk_loner_00002")
+	assert.Nil(t, keyConfig.Metadata)
+	assert.Equal(t, int64(50), cfg.KeysConfig().Teams["search"].QuotaLimit)
+}
+
+func TestDiffKeysIncludesGroups(t *testing.T) {
+	previous := models.KeysConfig{
+		Organizations: map[string]models.GroupConfig{"acme": {}},
+		Teams:         map[string]models.TeamConfig{"search": {}, "ads": {}},
+	}
+	current := models.KeysConfig{
+		Organizations: map[string]models.GroupConfig{"acme": {BudgetUSD: 100}},
+		Teams:         map[string]models.TeamConfig{"search": {}, "infra": {}},
+	}
+
+	diff := DiffKeys(previous, current)
+	assert.Equal(t, []string{"team:infra"}, diff.Added)
+	assert.Equal(t, []string{"team:ads"}, diff.Removed)
+	assert.Equal(t, []string{"org:acme"}, diff.Changed)
+}
+
+func TestValidateKeysJWTMappings(t *testing.T) {
+	virtualKeys := map[string]models.VirtualKeyConfig{"vk_a": {Provider: models.ProviderOpenAI, APIKey: "sk"}}
+
+	err := ValidateKeys(models.KeysConfig{VirtualKeys: virtualKeys, JWTMappings: []models.JWTMapping{{VirtualKey: "vk_a"}}})
+	assert.ErrorContains(t, err, "subject or group is required")
+
+	err = ValidateKeys(models.KeysConfig{VirtualKeys: virtualKeys, JWTMappings: []models.JWTMapping{{Subject: "svc", VirtualKey: "vk_missing"}}})
+	assert.ErrorContains(t, err, "unknown virtual key")
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	cfg := loadFromKeysJSON(t, `{
+		"virtual_keys": {
+			"vk_search_profile": {"provider": "openai", "api_key": "sk"},
+			"vk_admins_profile": {"provider": "openai", "api_key": "sk"},
+			"vk_off_profile_01": {"provider": "openai", "api_key": "sk", "disabled": true}
+		},
+		"jwt_mappings": [
+			{"subject": "svc-search", "group": "prod", "virtual_key": "vk_search_profile"},
+			{"group": "admins", "virtual_key": "vk_admins_profile"},
+			{"subject": "svc-retired", "virtual_key": "vk_off_profile_01"}
+		]
+	}`)
+
+	name, _, err := cfg.AuthenticateJWT("svc-search", []string{"prod", "admins"})
+	require.NoError(t, err)
+	assert.Equal(t, "vk_search_profile", name, "the first matching mapping wins")
+
+	name, _, err = cfg.AuthenticateJWT("svc-search", []string{"admins"})
+	require.NoError(t, err)
+	assert.Equal(t, "vk_admins_profile", name, "all fields of a mapping must match")
+
+	_, _, err = cfg.AuthenticateJWT("svc-retired", nil)
+	assert.ErrorIs(t, err, ErrKeyDisabled)
+
+	_, _, err = cfg.AuthenticateJWT("nobody", nil)
+	assert.ErrorIs(t, err, ErrNoJWTMapping)
+}
+
+func TestValidateKeysCertMappings(t *testing.T) {
+	virtualKeys := map[string]models.VirtualKeyConfig{"vk_a": {Provider: models.ProviderOpenAI, APIKey: "sk"}}
+
+	err := ValidateKeys(models.KeysConfig{VirtualKeys: virtualKeys, CertMappings: []models.CertMapping{{VirtualKey: "vk_a"}}})
+	assert.ErrorContains(t, err, "common_name or uri is required")
+
+	err = ValidateKeys(models.KeysConfig{VirtualKeys: virtualKeys, CertMappings: []models.CertMapping{{CommonName: "svc", VirtualKey: "vk_missing"}}})
+	assert.ErrorContains(t, err, "unknown virtual key")
+}
+
+func TestAuthenticateClientCert(t *testing.T) {
+	cfg := loadFromKeysJSON(t, `{
+		"virtual_keys": {
+			"vk_search_profile": {"provider": "openai", "api_key": "sk"},
+			"vk_mesh_profile01": {"provider": "openai", "api_key": "sk"},
+			"vk_off_profile_01": {"provider": "openai", "api_key": "sk", "disabled": true}
+		},
+		"cert_mappings": [
+			{"common_name": "svc-search", "virtual_key": "vk_search_profile"},
+			{"uri": "spiffe://prod/ns/ml/sa/notebook", "virtual_key": "vk_mesh_profile01"},
+			{"common_name": "svc-retired", "virtual_key": "vk_off_profile_01"}
+		]
+	}`)
+
+	cert := func(commonName string, uris ...string) *x509.Certificate {
+		c := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+		for _, raw := range uris {
+			u, err := url.Parse(raw)
+			require.NoError(t, err)
+			c.URIs = append(c.URIs, u)
+		}
+		return c
+	}
+
+	name, _, err := cfg.AuthenticateClientCert(cert("svc-search"))
+	require.NoError(t, err)
+	assert.Equal(t, "vk_search_profile", name)
+
+	name, _, err = cfg.AuthenticateClientCert(cert("pod-7f9c", "spiffe://prod/ns/ml/sa/notebook"))
+	require.NoError(t, err)
+	assert.Equal(t, "vk_mesh_profile01", name)
+
+	_, _, err = cfg.AuthenticateClientCert(cert("svc-retired"))
+	assert.ErrorIs(t, err, ErrKeyDisabled)
+
+	_, _, err = cfg.AuthenticateClientCert(cert("stranger"))
+	assert.ErrorIs(t, err, ErrNoCertMapping)
+}
+
+func TestValidateKeysAllowedCIDRs(t *testing.T) {
+	keysConfig := models.KeysConfig{VirtualKeys: map[string]models.VirtualKeyConfig{
+		"vk_a": {Provider: models.ProviderOpenAI, APIKey: "sk", AllowedCIDRs: []string{"10.0.0.0/8", "192.0.2.7"}},
+	}}
+	require.NoError(t, ValidateKeys(keysConfig))
+
+	keysConfig.VirtualKeys["vk_a"] = models.VirtualKeyConfig{Provider: models.ProviderOpenAI, APIKey: "sk", AllowedCIDRs: []string{"10.0.0.0/40"}}
+	assert.ErrorContains(t, ValidateKeys(keysConfig), "allowed_cidrs")
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"llmgateway/internal/models"
+	"maps"
+	"os"
+	"strings"
+)
+
+// Prefixes of secret references accepted in api_key values
+const (
+	secretEnvPrefix       = "env:"
+	secretFilePrefix      = "file:"
+	secretEncryptedPrefix = "enc:v1:"
+)
+
+// IsSecretReference reports whether an api_key value refers to a secret rather than containing it
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretEnvPrefix) ||
+		strings.HasPrefix(value, secretFilePrefix) ||
+		strings.HasPrefix(value, secretEncryptedPrefix)
+}
+
+// ResolveKeys returns a copy of a key set with every api_key reference replaced by its secret
+func ResolveKeys(keysConfig models.KeysConfig, masterKey []byte) (models.KeysConfig, error) {
+	resolved := keysConfig
+	resolved.VirtualKeys = maps.Clone(keysConfig.VirtualKeys)
+	for virtualKey, keyConfig := range resolved.VirtualKeys {
+		apiKey, err := ResolveSecret(keyConfig.APIKey, masterKey)
+		if err != nil {
+			return models.KeysConfig{}, fmt.Errorf("virtual key %s: api_key: %w", models.MaskKey(virtualKey), err)
+		}
+		keyConfig.APIKey = apiKey
+		resolved.VirtualKeys[virtualKey] = keyConfig
+	}
+	return resolved, nil
+}
+
+// ResolveSecret returns the secret a value refers to:
+// - env:NAME reads the environment variable NAME
+// - file:PATH reads PATH, ignoring surrounding whitespace
+// - enc:v1:DATA decrypts DATA with the master key
+// Any other value is returned unchanged.
+func ResolveSecret(value string, masterKey []byte) (string, error) {
+	var secret string
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		secret = os.Getenv(name)
+		if secret == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+	case strings.HasPrefix(value, secretFilePrefix):
+		data, err := os.ReadFile(strings.TrimPrefix(value, secretFilePrefix))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+		if secret == "" {
+			return "", fmt.Errorf("secret file %s is empty", strings.TrimPrefix(value, secretFilePrefix))
+		}
+	case strings.HasPrefix(value, secretEncryptedPrefix):
+		plaintext, err := decryptSecret(strings.TrimPrefix(value, secretEncryptedPrefix), masterKey)
+		if err != nil {
+			return "", err
+		}
+		secret = plaintext
+	default:
+		secret = value
+	}
+	return secret, nil
+}
+
+// EncryptSecret encrypts a secret with AES-256-GCM, returning an enc:v1: value
+func EncryptSecret(plaintext string, masterKey []byte) (string, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretEncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret reverses EncryptSecret
+func decryptSecret(encoded string, masterKey []byte) (string, error) {
+	if masterKey == nil {
+		return "", errors.New("encrypted value requires KEYS_MASTER_KEY or KEYS_MASTER_KEY_FILE")
+	}
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("failed to decrypt value: wrong master key or corrupted data")
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(masterKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadMasterKey reads the base64-encoded 32-byte master key from KEYS_MASTER_KEY or,
+// if unset, from the file named by KEYS_MASTER_KEY_FILE. It returns nil if neither is set.
+func LoadMasterKey() ([]byte, error) {
+	encoded := os.Getenv("KEYS_MASTER_KEY")
+	if encoded == "" {
+		path := os.Getenv("KEYS_MASTER_KEY_FILE")
+		if path == "" {
+			return nil, nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+
+	masterKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(masterKey))
+	}
+	return masterKey, nil
+}
+
+// SealAPIKey prepares an api_key value for the keys file: plaintext is encrypted
+// when a master key is configured, and references are kept as they are
+func (c *Config) SealAPIKey(value string) (string, error) {
+	if c.KeysMasterKey == nil || IsSecretReference(value) {
+		return value, nil
+	}
+	return EncryptSecret(value, c.KeysMasterKey)
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"llmgateway/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMasterKey = bytes.Repeat([]byte{7}, 32)
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	encrypted, err := EncryptSecret("sk-live-123", testMasterKey)
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "sk-live-123")
+	assert.True(t, IsSecretReference(encrypted))
+
+	secret, err := ResolveSecret(encrypted, testMasterKey)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-live-123", secret)
+
+	_, err = ResolveSecret(encrypted, bytes.Repeat([]byte{8}, 32))
+	assert.Error(t, err)
+	_, err = ResolveSecret(encrypted, nil)
+	assert.Error(t, err)
+	_, err = ResolveSecret("enc:v1:not-base64!", testMasterKey)
+	assert.Error(t, err)
+}
+
+func TestResolveSecretReferences(t *testing.T) {
+	t.Setenv("TEST_OPENAI_KEY", "sk-from-env")
+	path := filepath.Join(t.TempDir(), "openai")
+	require.NoError(t, os.WriteFile(path, []byte("sk-from-file\n"), 0600))
+
+	secret, err := ResolveSecret("env:TEST_OPENAI_KEY", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-from-env", secret)
+
+	secret, err = ResolveSecret("file:"+path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-from-file", secret)
+
+	secret, err = ResolveSecret("sk-plain", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-plain", secret)
+
+	_, err = ResolveSecret("env:TEST_UNSET_KEY", nil)
+	assert.Error(t, err)
+	_, err = ResolveSecret("file:"+filepath.Join(t.TempDir(), "missing"), nil)
+	assert.Error(t, err)
+}
+
+func TestLoadResolvesSecretReferences(t *testing.T) {
+	t.Setenv("KEYS_MASTER_KEY", base64.StdEncoding.EncodeToString(testMasterKey))
+	t.Setenv("TEST_OPENAI_KEY", "sk-from-env")
+	encrypted, err := EncryptSecret("sk-decrypted", testMasterKey)
+	require.NoError(t, err)
+
+	cfg := loadFromKeysJSON(t, `{"virtual_keys": {
+		"vk_env": {"provider": "openai", "api_key": "env:TEST_OPENAI_KEY"},
+		"vk_enc": {"provider": "anthropic", "api_key": "`+encrypted+`"}
+	}}`)
+
+	keyConfig, valid := cfg.ValidateVirtualKey("vk_env")
+	require.True(t, valid)
+	assert.Equal(t, "sk-from-env", keyConfig.APIKey)
+	keyConfig, valid = cfg.ValidateVirtualKey("vk_enc")
+	require.True(t, valid)
+	assert.Equal(t, "sk-decrypted", keyConfig.APIKey)
+
+	// The raw view keeps the references, and so does the file after an update
+	assert.Equal(t, "env:TEST_OPENAI_KEY", cfg.RawKeysConfig().VirtualKeys["vk_env"].APIKey)
+	_, err = cfg.UpdateKeys(func(virtualKeys map[string]models.VirtualKeyConfig) error {
+		keyConfig := virtualKeys["vk_env"]
+		keyConfig.Name = "renamed"
+		virtualKeys["vk_env"] = keyConfig
+		return nil
+	})
+	require.NoError(t, err)
+	onFile, err := LoadKeysFile(cfg.KeysFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, "env:TEST_OPENAI_KEY", onFile.VirtualKeys["vk_env"].APIKey)
+	assert.Equal(t, encrypted, onFile.VirtualKeys["vk_enc"].APIKey)
+}
+
+func TestLoadFailsOnUnresolvableSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {"vk_env": {"provider": "openai", "api_key": "env:TEST_UNSET_KEY"}}}`), 0600))
+	t.Setenv("KEYS_FILE_PATH", path)
+
+	_, err := Load()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TEST_UNSET_KEY")
+}
+
+func TestReloadKeepsKeysOnUnresolvableSecret(t *testing.T) {
+	cfg := loadFromKeysJSON(t, `{"virtual_keys": {"vk_valid": {"provider": "openai", "api_key": "sk-1"}}}`)
+
+	require.NoError(t, os.WriteFile(cfg.KeysFilePath, []byte(`{"virtual_keys": {"vk_new": {"provider": "openai", "api_key": "env:TEST_UNSET_KEY"}}}`), 0600))
+	_, err := cfg.ReloadKeys()
+	require.Error(t, err)
+
+	_, valid := cfg.ValidateVirtualKey("vk_valid")
+	assert.True(t, valid)
+}
+
+func TestSealAPIKey(t *testing.T) {
+	cfg := &Config{}
+	sealed, err := cfg.SealAPIKey("sk-plain")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-plain", sealed)
+
+	cfg.KeysMasterKey = testMasterKey
+	sealed, err = cfg.SealAPIKey("sk-plain")
+	require.NoError(t, err)
+	secret, err := ResolveSecret(sealed, testMasterKey)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-plain", secret)
+
+	sealed, err = cfg.SealAPIKey("env:OPENAI_KEY")
+	require.NoError(t, err)
+	assert.Equal(t, "env:OPENAI_KEY", sealed)
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	masterKey, err := LoadMasterKey()
+	require.NoError(t, err)
+	assert.Nil(t, masterKey)
+
+	path := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(testMasterKey)+"\n"), 0600))
+	t.Setenv("KEYS_MASTER_KEY_FILE", path)
+	masterKey, err = LoadMasterKey()
+	require.NoError(t, err)
+	assert.Equal(t, testMasterKey, masterKey)
+
+	t.Setenv("KEYS_MASTER_KEY", base64.StdEncoding.EncodeToString([]byte("too short")))
+	_, err = LoadMasterKey()
+	assert.Error(t, err)
+}
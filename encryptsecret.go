@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"llmgateway/config"
+	"os"
+	"strings"
+)
+
+// runEncryptSecret implements "gateway encrypt-secret": it encrypts a provider API key
+// read from stdin with the master key and prints the enc:v1: value for keys.json
+func runEncryptSecret(args []string) int {
+	flags := flag.NewFlagSet("encrypt-secret", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: KEYS_MASTER_KEY=... gateway encrypt-secret < secret.txt\n")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	masterKey, err := config.LoadMasterKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if masterKey == nil {
+		fmt.Fprintf(os.Stderr, "set KEYS_MASTER_KEY or KEYS_MASTER_KEY_FILE (generate one with: openssl rand -base64 32)\n")
+		return 1
+	}
+
+	// The secret is read from stdin so it does not end up in shell history
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintf(os.Stderr, "Secret: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	secret := strings.TrimSpace(line)
+	if secret == "" {
+		fmt.Fprintf(os.Stderr, "no secret read from stdin: %v\n", err)
+		return 1
+	}
+
+	encrypted, err := config.EncryptSecret(secret, masterKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Println(encrypted)
+	return 0
+}
@@ -0,0 +1,120 @@
+package apierror
+
+import (
+	"encoding/json"
+	"llmgateway/internal/models"
+	"net/http"
+	"strconv"
+)
+
+// Code identifies what went wrong. Codes are part of the API: clients may branch on them,
+// so existing values never change meaning.
+type Code string
+
+const (
+	CodeInvalidRequest      Code = "invalid_request"       // Malformed or unreadable request
+	CodeMissingCredentials  Code = "missing_credentials"   // No virtual key, JWT or client certificate
+	CodeInvalidKey          Code = "invalid_key"           // Unknown, disabled, expired or unmapped credentials
+	CodeIPNotAllowed        Code = "ip_not_allowed"        // Client IP outside the key's allowed_cidrs
+	CodePolicyViolation     Code = "policy_violation"      // Request denied by a key, team or organization policy
+	CodeQuotaExceeded       Code = "quota_exceeded"        // Hourly request quota used up
+	CodeBudgetExceeded      Code = "budget_exceeded"       // Monthly team or organization budget spent
+	CodeCapacityExhausted   Code = "capacity_exhausted"    // Timed out waiting for an upstream slot
+	CodeUpstreamTimeout     Code = "upstream_timeout"      // The provider did not answer in time
+	CodeUpstreamUnavailable Code = "upstream_unavailable"  // The provider could not be reached
+	CodeUpstreamRateLimited Code = "upstream_rate_limited" // The provider returned 429
+	CodeUpstreamError       Code = "upstream_error"        // The provider returned any other error
+	CodeNotFound            Code = "not_found"
+	CodeMethodNotAllowed    Code = "method_not_allowed"
+	CodeInternal            Code = "internal_error"
+)
+
+// Error is the body of every error response, wrapped as {"error": {...}}. Type is the
+// OpenAI-style category of the HTTP status, so that SDKs raise their usual exceptions;
+// Code is the gateway's own, finer reason.
+type Error struct {
+	Message   string `json:"message"`
+	Type      string `json:"type"`
+	Code      Code   `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Set when the error came from a provider: its name and its original error body
+	Provider      models.Provider `json:"provider,omitempty"`
+	ProviderError json.RawMessage `json:"provider_error,omitempty"`
+}
+
+// New creates an error
+func New(code Code, message string) Error {
+	return Error{Message: message, Code: code}
+}
+
+// FromUpstream translates a provider's error response. The message is taken from the
+// provider's body, which OpenAI and Anthropic both put in error.message, and the whole
+// body is kept in ProviderError. A body that is not JSON is kept as a JSON string.
+func FromUpstream(provider models.Provider, status int, body []byte) Error {
+	e := Error{
+		Message:  "provider returned status " + strconv.Itoa(status),
+		Code:     upstreamCode(status),
+		Provider: provider,
+	}
+	if len(body) == 0 {
+		return e
+	}
+
+	var parsed struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Valid(body) {
+		e.ProviderError = json.RawMessage(body)
+		if json.Unmarshal(body, &parsed) == nil && parsed.Error.Message != "" {
+			e.Message = parsed.Error.Message
+		}
+	} else {
+		e.ProviderError, _ = json.Marshal(string(body))
+	}
+	return e
+}
+
+// upstreamCode maps a provider's error status to a gateway code
+func upstreamCode(status int) Code {
+	switch status {
+	case http.StatusTooManyRequests:
+		return CodeUpstreamRateLimited
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return CodeUpstreamTimeout
+	default:
+		return CodeUpstreamError
+	}
+}
+
+// Write writes an error response, filling in Type from the status if it is unset
+func Write(w http.ResponseWriter, status int, e Error) {
+	if e.Type == "" {
+		e.Type = TypeFor(status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]Error{"error": e})
+}
+
+// TypeFor returns the error type OpenAI uses for an HTTP status
+func TypeFor(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	default:
+		if status < 500 {
+			return "invalid_request_error"
+		}
+		return "api_error"
+	}
+}
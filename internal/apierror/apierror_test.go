@@ -0,0 +1,100 @@
+package apierror
+
+import (
+	"encoding/json"
+	"llmgateway/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	e := New(CodeQuotaExceeded, "quota exceeded: 100 requests per hour limit reached")
+	e.RequestID = "req-1"
+	Write(rec, http.StatusTooManyRequests, e)
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error": {
+		"message": "quota exceeded: 100 requests per hour limit reached",
+		"type": "rate_limit_error",
+		"code": "quota_exceeded",
+		"request_id": "req-1"
+	}}`, rec.Body.String())
+}
+
+func TestFromUpstream(t *testing.T) {
+	for name, tc := range map[string]struct {
+		provider models.Provider
+		status   int
+		body     string
+		want     Error
+	}{
+		"openai rate limit": {
+			provider: models.ProviderOpenAI,
+			status:   http.StatusTooManyRequests,
+			body:     `{"error":{"message":"Rate limit reached for gpt-4o","type":"requests","code":"rate_limit_exceeded"}}`,
+			want: Error{
+				Message:       "Rate limit reached for gpt-4o",
+				Code:          CodeUpstreamRateLimited,
+				Provider:      models.ProviderOpenAI,
+				ProviderError: json.RawMessage(`{"error":{"message":"Rate limit reached for gpt-4o","type":"requests","code":"rate_limit_exceeded"}}`),
+			},
+		},
+		"anthropic invalid request": {
+			provider: models.ProviderAnthropic,
+			status:   http.StatusBadRequest,
+			body:     `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: field required"}}`,
+			want: Error{
+				Message:       "max_tokens: field required",
+				Code:          CodeUpstreamError,
+				Provider:      models.ProviderAnthropic,
+				ProviderError: json.RawMessage(`{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: field required"}}`),
+			},
+		},
+		"non-JSON gateway timeout": {
+			provider: models.ProviderOpenAI,
+			status:   http.StatusGatewayTimeout,
+			body:     "upstream request timeout",
+			want: Error{
+				Message:       "provider returned status 504",
+				Code:          CodeUpstreamTimeout,
+				Provider:      models.ProviderOpenAI,
+				ProviderError: json.RawMessage(`"upstream request timeout"`),
+			},
+		},
+		"empty body": {
+			provider: models.ProviderAnthropic,
+			status:   http.StatusInternalServerError,
+			want: Error{
+				Message:  "provider returned status 500",
+				Code:     CodeUpstreamError,
+				Provider: models.ProviderAnthropic,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, FromUpstream(tc.provider, tc.status, []byte(tc.body)))
+		})
+	}
+}
+
+func TestFromUpstreamRoundTrip(t *testing.T) {
+	// The provider's error survives intact inside the gateway envelope
+	rec := httptest.NewRecorder()
+	Write(rec, http.StatusTooManyRequests, FromUpstream(models.ProviderAnthropic, http.StatusTooManyRequests,
+		[]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"Number of requests has exceeded your rate limit"}}`)))
+
+	var body struct {
+		Error Error `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, CodeUpstreamRateLimited, body.Error.Code)
+	assert.Equal(t, "rate_limit_error", body.Error.Type)
+	assert.Equal(t, models.ProviderAnthropic, body.Error.Provider)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"rate_limit_error","message":"Number of requests has exceeded your rate limit"}}`, string(body.Error.ProviderError))
+}
@@ -0,0 +1,110 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ParsePrefixes parses CIDR ranges. A bare address is taken as a single-host range.
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", value)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Contains reports whether any of the ranges contains the address
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return slices.ContainsFunc(prefixes, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
+
+// Resolve returns the address of the client that sent a request.
+// Forwarding headers are only believed when the connecting peer is a trusted proxy:
+// the Forwarded (or, without it, X-Forwarded-For) chain is walked from the nearest hop
+// outwards, and the first address that is not itself a trusted proxy is the client.
+// Resolve returns the zero Addr if the peer address cannot be parsed.
+func Resolve(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	client := parseHost(r.RemoteAddr)
+	if !client.IsValid() || !Contains(trusted, client) {
+		return client
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if hops == nil {
+		hops = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHost(hops[i])
+		if !hop.IsValid() {
+			// An unparseable or obfuscated hop ends the chain at the last trusted proxy
+			return client
+		}
+		client = hop
+		if !Contains(trusted, client) {
+			return client
+		}
+	}
+	return client
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded headers, nearest hop last.
+// It returns nil if no header carries one.
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// xForwardedFor splits X-Forwarded-For headers into hops, nearest hop last
+func xForwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHost parses an address with or without a port, e.g. "192.0.2.1:443" or "[2001:db8::1]"
+func parseHost(value string) netip.Addr {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,101 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32", " 198.51.100.1/24 "})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+	}, prefixes)
+
+	_, err = ParsePrefixes([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParsePrefixes([]string{"office"})
+	assert.Error(t, err)
+}
+
+func TestContains(t *testing.T) {
+	prefixes, err := ParsePrefixes([]string{"10.0.0.0/8", "2001:db8::/32"})
+	require.NoError(t, err)
+
+	assert.True(t, Contains(prefixes, netip.MustParseAddr("10.1.2.3")))
+	assert.True(t, Contains(prefixes, netip.MustParseAddr("::ffff:10.1.2.3")), "IPv4-mapped addresses match IPv4 ranges")
+	assert.True(t, Contains(prefixes, netip.MustParseAddr("2001:db8::5")))
+	assert.False(t, Contains(prefixes, netip.MustParseAddr("192.0.2.1")))
+	assert.False(t, Contains(nil, netip.MustParseAddr("10.1.2.3")))
+}
+
+func TestResolve(t *testing.T) {
+	trusted, err := ParsePrefixes([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		"direct connection": {
+			remoteAddr: "203.0.113.9:51234",
+			want:       "203.0.113.9",
+		},
+		"untrusted peer cannot spoof": {
+			remoteAddr: "203.0.113.9:51234",
+			headers:    map[string]string{"X-Forwarded-For": "10.1.1.1"},
+			want:       "203.0.113.9",
+		},
+		"trusted proxy": {
+			remoteAddr: "10.0.0.2:443",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.4"},
+			want:       "198.51.100.4",
+		},
+		"chain of trusted proxies": {
+			remoteAddr: "10.0.0.2:443",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.4, 10.0.0.7"},
+			want:       "198.51.100.4",
+		},
+		"client-supplied prefix is ignored": {
+			remoteAddr: "10.0.0.2:443",
+			headers:    map[string]string{"X-Forwarded-For": "10.9.9.9, 198.51.100.4"},
+			want:       "198.51.100.4",
+		},
+		"forwarded header": {
+			remoteAddr: "10.0.0.2:443",
+			headers:    map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.0.0.7`},
+			want:       "2001:db8::1",
+		},
+		"forwarded takes precedence": {
+			remoteAddr: "10.0.0.2:443",
+			headers:    map[string]string{"Forwarded": "for=198.51.100.4", "X-Forwarded-For": "192.0.2.1"},
+			want:       "198.51.100.4",
+		},
+		"obfuscated hop stops at the proxy": {
+			remoteAddr: "10.0.0.2:443",
+			headers:    map[string]string{"Forwarded": "for=_hidden"},
+			want:       "10.0.0.2",
+		},
+		"no forwarding header": {
+			remoteAddr: "10.0.0.2:443",
+			want:       "10.0.0.2",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for header, value := range tc.headers {
+				req.Header.Set(header, value)
+			}
+			assert.Equal(t, netip.MustParseAddr(tc.want), Resolve(req, trusted))
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"llmgateway/config"
+	"llmgateway/internal/apierror"
+	"llmgateway/internal/models"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errKeyNotFound is returned from key updates addressing an unknown key ID
+var errKeyNotFound = errors.New("virtual key not found")
+
+// createKeyRequest is the body of POST /admin/keys
+type createKeyRequest struct {
+	Name      string          `json:"name"`
+	Provider  models.Provider `json:"provider"`
+	APIKey    string          `json:"api_key"`
+	Priority  models.Priority `json:"priority"`
+	NotBefore *time.Time      `json:"not_before"`
+	ExpiresAt *time.Time      `json:"expires_at"`
+
+	Policy   *models.RequestPolicy `json:"policy"`
+	Team     string                `json:"team"`
+	Metadata map[string]string     `json:"metadata"`
+
+	AllowedCIDRs []string `json:"allowed_cidrs"`
+}
+
+// updateKeyRequest is the body of PATCH /admin/keys/{id}; omitted fields are left unchanged
+type updateKeyRequest struct {
+	Name      *string                        `json:"name"`
+	Provider  *models.Provider               `json:"provider"`
+	APIKey    *string                        `json:"api_key"`
+	Priority  *models.Priority               `json:"priority"`
+	Disabled  *bool                          `json:"disabled"`
+	NotBefore optional[time.Time]            `json:"not_before"` // null clears it
+	ExpiresAt optional[time.Time]            `json:"expires_at"` // null clears it
+	Policy    optional[models.RequestPolicy] `json:"policy"`     // Replaces the whole policy; null clears it
+	Team      *string                        `json:"team"`       // "" removes the key from its team
+	Metadata  optional[map[string]string]    `json:"metadata"`   // Replaces all labels; null clears them
+
+	AllowedCIDRs optional[[]string] `json:"allowed_cidrs"` // Replaces all ranges; null allows any client IP
+}
+
+// optional distinguishes a field set to null from one left out
+type optional[T any] struct {
+	set   bool
+	value *T
+}
+
+func (o *optional[T]) UnmarshalJSON(data []byte) error {
+	o.set = true
+	return json.Unmarshal(data, &o.value)
+}
+
+// AdminKeys handles the /admin/keys endpoint
+// - GET: list all virtual keys
+// - POST: create a virtual key with a generated secret, returned only in this response.
+// Only a salted hash of the new key is stored, and the api_key is encrypted if a master key is configured.
+func (h *Handler) AdminKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		virtualKeys := h.config.RawKeysConfig().VirtualKeys
+		keys := make([]models.VirtualKeyInfo, 0, len(virtualKeys))
+		for virtualKey, keyConfig := range virtualKeys {
+			keys = append(keys, keyInfo(virtualKey, keyConfig))
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+		writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+
+	case http.MethodPost:
+		var req createKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if req.APIKey == "" {
+			h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "missing required field: api_key")
+			return
+		}
+		apiKey, err := h.config.SealAPIKey(req.APIKey)
+		if err != nil {
+			h.writeError(w, r, http.StatusInternalServerError, apierror.CodeInternal, "failed to encrypt api_key")
+			return
+		}
+
+		virtualKey, lookupName := config.MintVirtualKey()
+		keyConfig := models.VirtualKeyConfig{
+			Name:     req.Name,
+			Provider: req.Provider,
+			APIKey:   apiKey,
+			Priority: req.Priority,
+			KeyHash:  config.HashVirtualKey(virtualKey),
+
+			NotBefore: req.NotBefore,
+			ExpiresAt: req.ExpiresAt,
+			Policy:    req.Policy,
+			Team:      req.Team,
+			Metadata:  req.Metadata,
+
+			AllowedCIDRs: req.AllowedCIDRs,
+		}
+		if !h.updateKeys(w, r, func(virtualKeys map[string]models.VirtualKeyConfig) error {
+			virtualKeys[lookupName] = keyConfig
+			return nil
+		}) {
+			return
+		}
+
+		info := keyInfo(lookupName, keyConfig)
+		info.Key = virtualKey
+		writeJSON(w, http.StatusCreated, info)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		h.writeError(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// AdminKey handles the /admin/keys/{id} endpoints
+// - GET /admin/keys/{id}: inspect a key
+// - PATCH /admin/keys/{id}: update name, provider, api_key, priority, disabled, not_before, expires_at, policy, team, metadata or allowed_cidrs
+// - DELETE /admin/keys/{id}: delete a key
+// - POST /admin/keys/{id}/disable, /admin/keys/{id}/enable: toggle a key
+func (h *Handler) AdminKey(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/keys/"), "/")
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		virtualKey, keyConfig, found := h.findKey(id)
+		if !found {
+			h.writeError(w, r, http.StatusNotFound, apierror.CodeNotFound, errKeyNotFound.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, keyInfo(virtualKey, keyConfig))
+
+	case action == "" && r.Method == http.MethodPatch:
+		var req updateKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if req.APIKey != nil {
+			apiKey, err := h.config.SealAPIKey(*req.APIKey)
+			if err != nil {
+				h.writeError(w, r, http.StatusInternalServerError, apierror.CodeInternal, "failed to encrypt api_key")
+				return
+			}
+			req.APIKey = &apiKey
+		}
+		h.modifyKey(w, r, id, func(keyConfig *models.VirtualKeyConfig) {
+			if req.Name != nil {
+				keyConfig.Name = *req.Name
+			}
+			if req.Provider != nil {
+				keyConfig.Provider = *req.Provider
+			}
+			if req.APIKey != nil {
+				keyConfig.APIKey = *req.APIKey
+			}
+			if req.Priority != nil {
+				keyConfig.Priority = *req.Priority
+			}
+			if req.Disabled != nil {
+				keyConfig.Disabled = *req.Disabled
+			}
+			if req.NotBefore.set {
+				keyConfig.NotBefore = req.NotBefore.value
+			}
+			if req.ExpiresAt.set {
+				keyConfig.ExpiresAt = req.ExpiresAt.value
+			}
+			if req.Policy.set {
+				keyConfig.Policy = req.Policy.value
+			}
+			if req.Team != nil {
+				keyConfig.Team = *req.Team
+			}
+			if req.Metadata.set {
+				keyConfig.Metadata = nil
+				if req.Metadata.value != nil {
+					keyConfig.Metadata = *req.Metadata.value
+				}
+			}
+			if req.AllowedCIDRs.set {
+				keyConfig.AllowedCIDRs = nil
+				if req.AllowedCIDRs.value != nil {
+					keyConfig.AllowedCIDRs = *req.AllowedCIDRs.value
+				}
+			}
+		})
+
+	case action == "" && r.Method == http.MethodDelete:
+		if !h.updateKeys(w, r, func(virtualKeys map[string]models.VirtualKeyConfig) error {
+			for virtualKey := range virtualKeys {
+				if models.KeyID(virtualKey) == id {
+					delete(virtualKeys, virtualKey)
+					return nil
+				}
+			}
+			return errKeyNotFound
+		}) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case (action == "disable" || action == "enable") && r.Method == http.MethodPost:
+		h.modifyKey(w, r, id, func(keyConfig *models.VirtualKeyConfig) {
+			keyConfig.Disabled = action == "disable"
+		})
+
+	case action == "" || action == "disable" || action == "enable":
+		if action == "" {
+			w.Header().Set("Allow", "GET, PATCH, DELETE")
+		} else {
+			w.Header().Set("Allow", "POST")
+		}
+		h.writeError(w, r, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+
+	default:
+		h.writeError(w, r, http.StatusNotFound, apierror.CodeNotFound, "not found")
+	}
+}
+
+// modifyKey applies modify to the key with the given ID and writes back the result
+func (h *Handler) modifyKey(w http.ResponseWriter, r *http.Request, id string, modify func(*models.VirtualKeyConfig)) {
+	var (
+		updatedKey    string
+		updatedConfig models.VirtualKeyConfig
+	)
+	if !h.updateKeys(w, r, func(virtualKeys map[string]models.VirtualKeyConfig) error {
+		for virtualKey, keyConfig := range virtualKeys {
+			if models.KeyID(virtualKey) == id {
+				modify(&keyConfig)
+				virtualKeys[virtualKey] = keyConfig
+				updatedKey, updatedConfig = virtualKey, keyConfig
+				return nil
+			}
+		}
+		return errKeyNotFound
+	}) {
+		return
+	}
+	writeJSON(w, http.StatusOK, keyInfo(updatedKey, updatedConfig))
+}
+
+// updateKeys applies a change to the key set, writing an error response and
+// returning false if it could not be applied
+func (h *Handler) updateKeys(w http.ResponseWriter, r *http.Request, update func(map[string]models.VirtualKeyConfig) error) bool {
+	diff, err := h.config.UpdateKeys(update)
+	switch {
+	case errors.Is(err, errKeyNotFound):
+		h.writeError(w, r, http.StatusNotFound, apierror.CodeNotFound, err.Error())
+		return false
+	case errors.Is(err, config.ErrInvalidKeys):
+		h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return false
+	case err != nil:
+		h.logger.LogError("Failed to update virtual keys", err)
+		h.writeError(w, r, http.StatusInternalServerError, apierror.CodeInternal, "failed to save virtual keys")
+		return false
+	}
+
+	h.logger.LogInfo("Updated virtual keys via admin API", map[string]any{
+		"added":   diff.Added,
+		"removed": diff.Removed,
+		"changed": diff.Changed,
+	})
+	return true
+}
+
+// findKey looks up a virtual key by its ID
+func (h *Handler) findKey(id string) (string, models.VirtualKeyConfig, bool) {
+	for virtualKey, keyConfig := range h.config.RawKeysConfig().VirtualKeys {
+		if models.KeyID(virtualKey) == id {
+			return virtualKey, keyConfig, true
+		}
+	}
+	return "", models.VirtualKeyConfig{}, false
+}
+
+// keyInfo describes a key with its secrets masked. virtualKey is the keys.json entry name.
+func keyInfo(virtualKey string, keyConfig models.VirtualKeyConfig) models.VirtualKeyInfo {
+	return models.VirtualKeyInfo{
+		ID:       models.KeyID(virtualKey),
+		Key:      models.MaskKey(virtualKey),
+		Name:     keyConfig.Name,
+		Provider: keyConfig.Provider,
+		APIKey:   describeAPIKey(keyConfig.APIKey),
+		Priority: keyConfig.Priority.OrDefault(),
+		Disabled: keyConfig.Disabled,
+		Hashed:   keyConfig.KeyHash != "",
+
+		NotBefore: keyConfig.NotBefore,
+		ExpiresAt: keyConfig.ExpiresAt,
+		Policy:    keyConfig.Policy,
+
+		Team:     keyConfig.Team,
+		Metadata: keyConfig.Metadata,
+
+		AllowedCIDRs: keyConfig.AllowedCIDRs,
+	}
+}
+
+// describeAPIKey shows env: and file: references as they are, and masks anything secret
+func describeAPIKey(apiKey string) string {
+	switch {
+	case strings.HasPrefix(apiKey, "enc:"):
+		return "enc:..."
+	case config.IsSecretReference(apiKey):
+		return apiKey
+	default:
+		return models.MaskKey(apiKey)
+	}
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
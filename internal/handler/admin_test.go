@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"encoding/json"
+	"llmgateway/config"
+	"llmgateway/internal/logger"
+	"llmgateway/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const existingKey = "vk_existing_openai"
+
+func newAdminTestHandler(t *testing.T) (*Handler, *config.Config) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {
+		"vk_existing_openai": {"provider": "openai", "api_key": "sk-existing-secret-1234"}
+	}}`), 0600))
+
+	os.Setenv("KEYS_FILE_PATH", path)
+	t.Cleanup(func() { os.Unsetenv("KEYS_FILE_PATH") })
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	log, err := logger.NewLogger(false, "")
+	require.NoError(t, err)
+
+	return NewHandler(cfg, log, nil, nil, nil, nil), cfg
+}
+
+func serveAdmin(h *Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	if path == "/admin/keys" {
+		h.AdminKeys(rec, req)
+	} else {
+		h.AdminKey(rec, req)
+	}
+	return rec
+}
+
+// fileKeys reads back the keys file as persisted
+func fileKeys(t *testing.T, cfg *config.Config) map[string]models.VirtualKeyConfig {
+	t.Helper()
+	keysConfig, err := config.LoadKeysFile(cfg.KeysFilePath)
+	require.NoError(t, err)
+	return keysConfig.VirtualKeys
+}
+
+func TestAdminCreateKey(t *testing.T) {
+	h, cfg := newAdminTestHandler(t)
+
+	rec := serveAdmin(h, http.MethodPost, "/admin/keys", `{"name": "search team", "provider": "anthropic", "api_key": "sk-ant-secret-5678", "priority": "batch"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var created models.VirtualKeyInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Key, "vk_"))
+	assert.Equal(t, models.KeyID(config.KeyLookupName(created.Key)), created.ID)
+	assert.True(t, created.Hashed)
+	assert.Equal(t, "search team", created.Name)
+	assert.Equal(t, models.PriorityBatch, created.Priority)
+	assert.NotContains(t, rec.Body.String(), "sk-ant-secret-5678")
+
+	// Usable immediately and persisted, without the plaintext key on file
+	keyConfig, valid := cfg.ValidateVirtualKey(created.Key)
+	assert.True(t, valid)
+	assert.Equal(t, "sk-ant-secret-5678", keyConfig.APIKey)
+	data, err := os.ReadFile(cfg.KeysFilePath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), created.Key)
+	assert.NotEmpty(t, fileKeys(t, cfg)[config.KeyLookupName(created.Key)].KeyHash)
+}
+
+func TestAdminCreateKeyRejectsInvalid(t *testing.T) {
+	h, cfg := newAdminTestHandler(t)
+
+	for _, body := range []string{
+		`{"provider": "openai"}`,
+		`{"provider": "gemini", "api_key": "sk"}`,
+		`{"provider": "openai", "api_key": "sk", "priority": "urgent"}`,
+		`not json`,
+	} {
+		rec := serveAdmin(h, http.MethodPost, "/admin/keys", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+	assert.Len(t, cfg.KeysConfig().VirtualKeys, 1)
+}
+
+func TestAdminListAndInspectMaskSecrets(t *testing.T) {
+	h, _ := newAdminTestHandler(t)
+
+	rec := serveAdmin(h, http.MethodGet, "/admin/keys", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), existingKey)
+	assert.NotContains(t, rec.Body.String(), "sk-existing-secret-1234")
+
+	var list struct {
+		Keys []models.VirtualKeyInfo `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Keys, 1)
+	assert.Equal(t, models.KeyID(existingKey), list.Keys[0].ID)
+
+	rec = serveAdmin(h, http.MethodGet, "/admin/keys/"+models.KeyID(existingKey), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "sk-existing-secret-1234")
+
+	rec = serveAdmin(h, http.MethodGet, "/admin/keys/unknown", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminUpdateKey(t *testing.T) {
+	h, cfg := newAdminTestHandler(t)
+	path := "/admin/keys/" + models.KeyID(existingKey)
+
+	rec := serveAdmin(h, http.MethodPatch, path, `{"api_key": "sk-rotated-9999", "priority": "interactive"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	keyConfig, valid := cfg.ValidateVirtualKey(existingKey)
+	require.True(t, valid)
+	assert.Equal(t, "sk-rotated-9999", keyConfig.APIKey)
+	assert.Equal(t, models.PriorityInteractive, keyConfig.Priority)
+	assert.Equal(t, models.ProviderOpenAI, keyConfig.Provider)
+	assert.Equal(t, "sk-rotated-9999", fileKeys(t, cfg)[existingKey].APIKey)
+
+	rec = serveAdmin(h, http.MethodPatch, "/admin/keys/unknown", `{"name": "x"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminDisableEnableKey(t *testing.T) {
+	h, cfg := newAdminTestHandler(t)
+	path := "/admin/keys/" + models.KeyID(existingKey)
+
+	rec := serveAdmin(h, http.MethodPost, path+"/disable", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	_, valid := cfg.ValidateVirtualKey(existingKey)
+	assert.False(t, valid)
+	assert.True(t, fileKeys(t, cfg)[existingKey].Disabled)
+
+	rec = serveAdmin(h, http.MethodPost, path+"/enable", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	_, valid = cfg.ValidateVirtualKey(existingKey)
+	assert.True(t, valid)
+
+	rec = serveAdmin(h, http.MethodGet, path+"/disable", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestAdminDeleteKey(t *testing.T) {
+	h, cfg := newAdminTestHandler(t)
+
+	rec := serveAdmin(h, http.MethodPost, "/admin/keys", `{"provider": "openai", "api_key": "sk-new"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	rec = serveAdmin(h, http.MethodDelete, "/admin/keys/"+models.KeyID(existingKey), "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	_, valid := cfg.ValidateVirtualKey(existingKey)
+	assert.False(t, valid)
+	assert.NotContains(t, fileKeys(t, cfg), existingKey)
+
+	rec = serveAdmin(h, http.MethodDelete, "/admin/keys/"+models.KeyID(existingKey), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminKeyValidityWindow(t *testing.T) {
+	h, cfg := newAdminTestHandler(t)
+	path := "/admin/keys/" + models.KeyID(existingKey)
+
+	rec := serveAdmin(h, http.MethodPatch, path, `{"expires_at": "2000-01-01T00:00:00Z"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"expires_at":"2000-01-01T00:00:00Z"`)
+	_, valid := cfg.ValidateVirtualKey(existingKey)
+	assert.False(t, valid)
+
+	// Leaving the field out keeps it; null clears it
+	rec = serveAdmin(h, http.MethodPatch, path, `{"name": "renamed"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotNil(t, fileKeys(t, cfg)[existingKey].ExpiresAt)
+
+	rec = serveAdmin(h, http.MethodPatch, path, `{"expires_at": null}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, fileKeys(t, cfg)[existingKey].ExpiresAt)
+	_, valid = cfg.ValidateVirtualKey(existingKey)
+	assert.True(t, valid)
+}
+
+func TestAdminAssignKeyToTeam(t *testing.T) {
+	h, cfg := newAdminTestHandler(t)
+
+	rec := serveAdmin(h, http.MethodPatch, "/admin/keys/"+models.KeyID(existingKey), `{"team": "search"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "teams must exist")
+
+	keysConfig := cfg.RawKeysConfig()
+	keysConfig.Teams = map[string]models.TeamConfig{"search": {GroupConfig: models.GroupConfig{QuotaLimit: 10}}}
+	_, err := cfg.SetKeysConfig(keysConfig)
+	require.NoError(t, err)
+
+	rec = serveAdmin(h, http.MethodPatch, "/admin/keys/"+models.KeyID(existingKey), `{"team": "search", "metadata": {"owner": "search@acme"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var info models.VirtualKeyInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, "search", info.Team)
+	assert.Equal(t, map[string]string{"owner": "search@acme"}, info.Metadata)
+
+	// Teams survive the rewrite of the keys file
+	persisted, err := config.LoadKeysFile(cfg.KeysFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), persisted.Teams["search"].QuotaLimit)
+	assert.Equal(t, "search", persisted.VirtualKeys[existingKey].Team)
+}
+
+func TestAdminRestrictKeyToCIDRs(t *testing.T) {
+	h, cfg := newAdminTestHandler(t)
+	path := "/admin/keys/" + models.KeyID(existingKey)
+
+	rec := serveAdmin(h, http.MethodPatch, path, `{"allowed_cidrs": ["office"]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "ranges are validated")
+
+	rec = serveAdmin(h, http.MethodPatch, path, `{"allowed_cidrs": ["198.51.100.0/24"]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var info models.VirtualKeyInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, []string{"198.51.100.0/24"}, info.AllowedCIDRs)
+
+	rec = serveAdmin(h, http.MethodPatch, path, `{"allowed_cidrs": null}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	_, keyConfig, err := cfg.AuthenticateVirtualKey(existingKey)
+	require.NoError(t, err)
+	assert.Empty(t, keyConfig.AllowedCIDRs)
+}
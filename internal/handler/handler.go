@@ -3,90 +3,195 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"llmgateway/config"
+	"llmgateway/internal/apierror"
 	"llmgateway/internal/logger"
+	"llmgateway/internal/metrics"
 	"llmgateway/internal/middleware"
 	"llmgateway/internal/models"
+	"llmgateway/internal/policy"
 	"llmgateway/internal/proxy"
+	"llmgateway/internal/scheduler"
+	"llmgateway/internal/tracing"
 	"llmgateway/internal/tracker"
+	"llmgateway/internal/usage"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Handler manages HTTP request handling
 type Handler struct {
-	config  *config.Config
-	logger  *logger.Logger
-	tracker *tracker.Tracker
+	config    *config.Config
+	logger    *logger.Logger
+	tracker   *tracker.Tracker
+	scheduler *scheduler.Scheduler
+	usage     *usage.Store
+	metrics   *metrics.Registry
+	headers   *proxy.HeaderPolicy         // Which client headers reach the providers
+	responses *proxy.ResponseHeaderPolicy // Which provider headers reach the client
 }
 
 // NewHandler creates a new handler instance
-func NewHandler(cfg *config.Config, log *logger.Logger, track *tracker.Tracker) *Handler {
+func NewHandler(cfg *config.Config, log *logger.Logger, track *tracker.Tracker, sched *scheduler.Scheduler, usageStore *usage.Store, registry *metrics.Registry) *Handler {
 	return &Handler{
-		config:  cfg,
-		logger:  log,
-		tracker: track,
+		config:    cfg,
+		logger:    log,
+		tracker:   track,
+		scheduler: sched,
+		usage:     usageStore,
+		metrics:   registry,
+		headers:   proxy.NewHeaderPolicy(cfg.UpstreamHeaderAllowlist, cfg.UpstreamHeaderDenylist, cfg.UpstreamExtraHeaders),
+		responses: proxy.NewResponseHeaderPolicy(cfg.ResponseHeaderAllowlist),
 	}
 }
 
 // ChatCompletions handles the /chat/completions endpoint
 func (h *Handler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
+	span := trace.SpanFromContext(r.Context())
+	requestID, _ := middleware.GetRequestID(r.Context())
+	span.SetAttributes(attribute.String("gateway.request_id", requestID))
 
 	// Get virtual key and config from context (set by auth middleware)
 	virtualKey, ok := middleware.GetVirtualKey(r.Context())
 	if !ok {
-		h.writeError(w, http.StatusUnauthorized, "authentication failed")
+		h.tracker.RecordOutcome("", "", models.OutcomeAuthFailure, 0)
+		h.writeError(w, r, http.StatusUnauthorized, apierror.CodeInvalidKey, "authentication failed")
 		return
 	}
 
 	keyConfig, ok := middleware.GetKeyConfig(r.Context())
 	if !ok {
-		h.writeError(w, http.StatusUnauthorized, "authentication failed")
+		h.tracker.RecordOutcome("", virtualKey, models.OutcomeAuthFailure, 0)
+		h.writeError(w, r, http.StatusUnauthorized, apierror.CodeInvalidKey, "authentication failed")
 		return
 	}
 
-	// Check quota if enabled
+	// The key's team and organization share in its quota, budget and policy
+	keysConfig := h.config.KeysConfig()
+	teamConfig, orgConfig := keysConfig.Lineage(keyConfig.Team)
+	groups := tracker.GroupsFor(keysConfig, keyConfig)
+
+	// Reserve quota if enabled; the reservation is committed or released once the outcome is known
+	var reservation *tracker.Reservation
 	if h.config.QuotaEnabled {
-		allowed, err := h.tracker.CheckQuota(virtualKey)
-		if !allowed {
-			h.writeError(w, http.StatusTooManyRequests, err.Error())
+		_, quotaSpan := tracing.Start(r.Context(), "gateway.quota")
+		res, err := h.tracker.Reserve(virtualKey, groups...)
+		quotaSpan.End()
+		if err != nil {
+			h.tracker.RecordOutcome(keyConfig.Provider, virtualKey, models.OutcomeQuotaExceeded, 0)
+			code := apierror.CodeQuotaExceeded
+			if errors.Is(err, tracker.ErrBudgetExceeded) {
+				code = apierror.CodeBudgetExceeded
+			}
+			h.writeError(w, r, http.StatusTooManyRequests, code, err.Error())
 			return
 		}
+		reservation = res
 	}
 
+	// Every exit path from here on settles the reservation and records its outcome
+	outcome := models.OutcomeValidationError
+	upstreamStatus := 0
+	defer func() {
+		h.tracker.Settle(reservation, outcome)
+		h.tracker.RecordOutcome(keyConfig.Provider, virtualKey, outcome, upstreamStatus)
+		span.SetAttributes(attribute.String("gateway.outcome", string(outcome)))
+	}()
+
 	// Read the request body
+	_, validateSpan := tracing.Start(r.Context(), "gateway.validate")
 	requestBody, err := io.ReadAll(r.Body)
 	if err != nil {
-		h.writeError(w, http.StatusBadRequest, "failed to read request body")
+		tracing.RecordError(validateSpan, err)
+		validateSpan.End()
+		h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "failed to read request body")
 		return
 	}
 	defer r.Body.Close()
 
 	// Validate request format
 	if err := proxy.ValidateRequestFormat(requestBody); err != nil {
-		h.writeError(w, http.StatusBadRequest, "invalid request format: "+err.Error())
+		tracing.RecordError(validateSpan, err)
+		validateSpan.End()
+		h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid request format: "+err.Error())
 		return
 	}
 
-	// Parse request body for logging
+	// Parse request body for policy checks and logging
 	var requestData map[string]any
 	json.Unmarshal(requestBody, &requestData)
+	model, _ := requestData["model"].(string)
+
+	// Enforce the model and parameter policies of the key, its team and its organization
+	if level, err := checkPolicies(keyConfig, teamConfig, orgConfig, requestData); err != nil {
+		tracing.RecordError(validateSpan, err)
+		validateSpan.End()
+		h.writeError(w, r, http.StatusForbidden, apierror.CodePolicyViolation, "request denied by "+level+" policy: "+err.Error())
+		return
+	}
+
+	// A model without a price would be charged $0 and never trip a budget
+	if g, found := budgetWithoutPrice(h.config.QuotaEnabled, groups, h.usage, model); found {
+		err := fmt.Errorf("model %q has no configured price, so it cannot be charged against the %s %s budget", model, g.Kind, g.ID)
+		tracing.RecordError(validateSpan, err)
+		validateSpan.End()
+		h.writeError(w, r, http.StatusForbidden, apierror.CodePolicyViolation, err.Error())
+		return
+	}
+	validateSpan.End()
+	span.SetAttributes(
+		tracing.AttrGenAISystem.String(string(keyConfig.Provider)),
+		tracing.AttrGenAIRequestModel.String(model),
+	)
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(h.config.RequestTimeout)*time.Second)
 	defer cancel()
 
-	// Proxy the request to the appropriate provider
-	responseBody, statusCode, err := proxy.ProxyRequest(
-		ctx,
+	// Wait for an upstream slot according to the key's priority class
+	_, queueSpan := tracing.Start(ctx, "gateway.queue", attribute.String("gateway.priority", string(keyConfig.Priority.OrDefault())))
+	release, err := h.scheduler.Acquire(ctx, keyConfig.Provider, keyConfig.Priority)
+	queueSpan.End()
+	if err != nil {
+		outcome = models.OutcomeTimeout
+		h.writeError(w, r, http.StatusServiceUnavailable, apierror.CodeCapacityExhausted, "upstream capacity saturated: "+err.Error())
+		return
+	}
+
+	// Proxy the request to the appropriate provider, noting when its first response byte arrives.
+	// The transport may report it after a timeout has already returned, hence the atomic.
+	var firstByte atomic.Int64
+	upstreamCtx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { firstByte.Store(time.Now().UnixNano()) },
+	})
+	upstreamStart := time.Now()
+	responseBody, statusCode, responseHeader, err := proxy.ProxyRequest(
+		upstreamCtx,
 		keyConfig.Provider,
 		keyConfig.APIKey,
 		requestBody,
 		r.Header,
+		h.headers,
 		time.Duration(h.config.RequestTimeout)*time.Second,
 	)
+	upstreamMs := time.Since(upstreamStart).Milliseconds()
+	ttftMs := int64(-1)
+	if at := firstByte.Load(); at != 0 {
+		ttftMs = time.Unix(0, at).Sub(upstreamStart).Milliseconds()
+	}
+	upstreamStatus = statusCode
+	release()
 
 	duration := time.Since(startTime)
 	durationMs := duration.Milliseconds()
@@ -97,36 +202,101 @@ func (h *Handler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
 		json.Unmarshal(responseBody, &responseData)
 	}
 
+	// Label metrics, latency and usage so that clients cannot create unbounded series
+	// (which are also persisted) by naming arbitrary models
+	modelLabel := h.modelLabel(model, responseData)
+
 	// Create log entry
+	subject, _ := middleware.GetSubject(r.Context())
 	logEntry := models.LogEntry{
-		Timestamp:  startTime.Format(time.RFC3339),
-		VirtualKey: virtualKey,
-		Provider:   keyConfig.Provider,
-		Method:     r.Method,
-		Status:     statusCode,
-		DurationMs: durationMs,
-		Request:    requestData,
-		Response:   responseData,
+		Timestamp:         startTime.Format(time.RFC3339),
+		RequestID:         requestID,
+		UpstreamRequestID: proxy.UpstreamRequestID(responseHeader),
+		VirtualKey:        virtualKey,
+		Subject:           subject,
+		Team:              keyConfig.Team,
+		Organization:      organization(teamConfig),
+		Provider:          keyConfig.Provider,
+		Method:            r.Method,
+		Status:            statusCode,
+		DurationMs:        durationMs,
+		Request:           requestData,
+		Response:          responseData,
 	}
 
 	if err != nil {
+		outcome = classifyProxyError(err)
+		status, code := http.StatusBadGateway, apierror.CodeUpstreamUnavailable
+		if outcome == models.OutcomeTimeout {
+			status, code = http.StatusGatewayTimeout, apierror.CodeUpstreamTimeout
+		}
 		logEntry.Error = err.Error()
-		logEntry.Status = http.StatusBadGateway
+		logEntry.Status = status
+		h.observe(usage.Record{
+			Timestamp:  startTime,
+			VirtualKey: virtualKey,
+			Provider:   keyConfig.Provider,
+			Model:      modelLabel,
+			DurationMs: durationMs,
+			Error:      true,
+		}, status, 0, groups)
 		h.logger.LogInteraction(logEntry)
-		h.writeError(w, http.StatusBadGateway, "failed to proxy request: "+err.Error())
+		h.writeError(w, r, status, code, "failed to proxy request: "+err.Error())
 		return
 	}
 
+	outcome = models.OutcomeSuccess
+	if statusCode >= 400 {
+		outcome = models.OutcomeUpstreamError
+	}
+
 	// Record the request in tracker for statistics
 	h.tracker.RecordRequest(keyConfig.Provider, durationMs)
+	h.tracker.RecordLatency(keyConfig.Provider, modelLabel, durationMs, upstreamMs, ttftMs)
+	inputTokens, outputTokens := usage.ExtractTokens(keyConfig.Provider, responseData)
+	span.SetAttributes(
+		tracing.AttrGenAIInputTokens.Int64(inputTokens),
+		tracing.AttrGenAIOutputTokens.Int64(outputTokens),
+		tracing.AttrGenAIFinishReasons.StringSlice(tracing.FinishReasons(keyConfig.Provider, responseData)),
+	)
+	h.observe(usage.Record{
+		Timestamp:    startTime,
+		VirtualKey:   virtualKey,
+		Provider:     keyConfig.Provider,
+		Model:        modelLabel,
+		DurationMs:   durationMs,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		Error:        outcome == models.OutcomeUpstreamError,
+	}, statusCode, usage.ExtractCachedTokens(keyConfig.Provider, responseData), groups)
+	h.tracker.RecordGroupUsage(groups, h.usage.Cost(modelLabel, inputTokens, outputTokens))
 
 	// Log the interaction
 	h.logger.LogInteraction(logEntry)
 
-	// Write the response back to the client
+	// Write the response back to the client with the provider's status and passed-through headers.
+	// Provider errors are translated into the gateway's envelope, keeping the original body.
+	_, writeSpan := tracing.Start(r.Context(), "gateway.write_response")
+	h.writeUpstreamHeaders(w.Header(), keyConfig.Provider, responseHeader, reservation)
+	if statusCode >= 400 {
+		e := apierror.FromUpstream(keyConfig.Provider, statusCode, responseBody)
+		e.RequestID = requestID
+		apierror.Write(w, statusCode, e)
+	} else {
+		w.WriteHeader(statusCode)
+		w.Write(responseBody)
+	}
+	writeSpan.End()
+}
+
+// Root describes the service at / and answers every path no other route matches
+func (h *Handler) Root(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		h.writeError(w, r, http.StatusNotFound, apierror.CodeNotFound, "unknown endpoint "+r.URL.Path)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	w.Write(responseBody)
+	fmt.Fprintf(w, `{"service":"LLM Gateway","version":"1.0.0","endpoints":["/chat/completions","/health","/ready","/metrics","/metrics/prometheus","/usage"]}`)
 }
 
 // Health handles the /health endpoint
@@ -139,7 +309,7 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 
 	// Check each provider's health using the first available key for that provider
 	providerKeys := make(map[models.Provider]string)
-	for _, keyConfig := range h.config.KeysConfig.VirtualKeys {
+	for _, keyConfig := range h.config.KeysConfig().VirtualKeys {
 		if _, exists := providerKeys[keyConfig.Provider]; !exists {
 			providerKeys[keyConfig.Provider] = keyConfig.APIKey
 		}
@@ -167,23 +337,176 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 }
 
 // Metrics handles the /metrics endpoint
+// Prometheus scrapers are served the text exposition format; everyone else gets JSON.
 func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	if wantsPrometheus(r.Header.Get("Accept")) {
+		h.PrometheusMetrics(w, r)
+		return
+	}
+
 	stats := h.tracker.GetStats()
+	stats.Queues = h.scheduler.Stats()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
-// writeError writes a JSON error response
-func (h *Handler) writeError(w http.ResponseWriter, statusCode int, message string) {
+// PrometheusMetrics handles the /metrics/prometheus endpoint
+func (h *Handler) PrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := h.metrics.Write(w, h.scheduler.Stats()); err != nil {
+		h.logger.LogError("Failed to write prometheus metrics", err)
+	}
+}
+
+// Usage handles the /usage endpoint, which is served behind admin authentication
+// Query parameters:
+// - from, to: RFC3339 time range (default: the last 24 hours)
+// - bucket: "hour" or "day" (default: "hour")
+// - key_id, provider, model: optional filters (key_id is the models.KeyID of a virtual key)
+func (h *Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Virtual keys must not travel in URLs, where they end up in access logs
+	if query.Has("virtual_key") {
+		h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "virtual_key is not accepted: filter by key_id instead")
+		return
+	}
+
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid to: must be RFC3339")
+			return
+		}
+		to = parsed
+	}
+
+	from := to.Add(-24 * time.Hour)
+	if value := query.Get("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid from: must be RFC3339")
+			return
+		}
+		from = parsed
+	}
+
+	bucket := query.Get("bucket")
+	if bucket == "" {
+		bucket = usage.BucketHour
+	}
+
+	report, err := h.usage.Query(from, to, bucket, usage.Filter{
+		KeyID:    query.Get("key_id"),
+		Provider: models.Provider(query.Get("provider")),
+		Model:    query.Get("model"),
+	})
+	if err != nil {
+		h.writeError(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	errorResponse := map[string]any{
-		"error": map[string]any{
-			"message": message,
-			"type":    "api_error",
-			"code":    statusCode,
-		},
-	}
-	json.NewEncoder(w).Encode(errorResponse)
+	json.NewEncoder(w).Encode(report)
+}
+
+// observe feeds a completed request to the usage store and the metrics registry,
+// rolling it up under the team and organization in groups
+func (h *Handler) observe(rec usage.Record, statusCode int, cachedTokens int64, groups []tracker.Group) {
+	h.usage.Record(rec)
+	obs := metrics.Observation{
+		Provider:     rec.Provider,
+		Model:        rec.Model,
+		VirtualKey:   rec.VirtualKey,
+		StatusCode:   statusCode,
+		DurationMs:   rec.DurationMs,
+		InputTokens:  rec.InputTokens,
+		OutputTokens: rec.OutputTokens,
+		CachedTokens: cachedTokens,
+		CostUSD:      h.usage.Cost(rec.Model, rec.InputTokens, rec.OutputTokens),
+	}
+	for _, g := range groups {
+		switch g.Kind {
+		case tracker.GroupTeam:
+			obs.Team = g.ID
+		case tracker.GroupOrganization:
+			obs.Organization = g.ID
+		}
+	}
+	h.metrics.Observe(obs)
+}
+
+// modelLabel returns the model a request is recorded under: the one the provider reports if
+// the response names one, else the requested one, or usage.OtherModel if it has no known price
+func (h *Handler) modelLabel(requested string, response map[string]any) string {
+	if reported, _ := response["model"].(string); reported != "" {
+		requested = reported
+	}
+	return h.usage.ModelLabel(requested)
+}
+
+// organization returns the organization a team belongs to, or "" for no team
+func organization(teamConfig *models.TeamConfig) string {
+	if teamConfig == nil {
+		return ""
+	}
+	return teamConfig.Organization
+}
+
+// budgetWithoutPrice returns the first group with an enforced monthly budget when model
+// has no price to charge against it
+func budgetWithoutPrice(quotaEnabled bool, groups []tracker.Group, store *usage.Store, model string) (tracker.Group, bool) {
+	if !quotaEnabled || store.Priced(model) {
+		return tracker.Group{}, false
+	}
+	for _, g := range groups {
+		if g.BudgetUSD > 0 {
+			return g, true
+		}
+	}
+	return tracker.Group{}, false
+}
+
+// checkPolicies checks a request against the key's policy and those of its team and
+// organization, returning the level whose policy denied it
+func checkPolicies(keyConfig models.VirtualKeyConfig, teamConfig *models.TeamConfig, orgConfig *models.GroupConfig, request map[string]any) (string, error) {
+	if err := policy.Check(keyConfig.Policy, request); err != nil {
+		return "key", err
+	}
+	if teamConfig != nil {
+		if err := policy.Check(teamConfig.Policy, request); err != nil {
+			return "team", err
+		}
+	}
+	if orgConfig != nil {
+		if err := policy.Check(orgConfig.Policy, request); err != nil {
+			return "organization", err
+		}
+	}
+	return "", nil
+}
+
+// wantsPrometheus reports whether an Accept header asks for a Prometheus exposition format
+func wantsPrometheus(accept string) bool {
+	return strings.Contains(accept, "application/openmetrics-text") ||
+		strings.Contains(accept, "text/plain;version=0.0.4") ||
+		strings.Contains(accept, "text/plain; version=0.0.4")
+}
+
+// classifyProxyError distinguishes gateway timeouts from other transport failures
+func classifyProxyError(err error) models.Outcome {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return models.OutcomeTimeout
+	}
+	return models.OutcomeTransportError
+}
+
+// writeError writes an error response in the gateway's error envelope
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, statusCode int, code apierror.Code, message string) {
+	e := apierror.New(code, message)
+	e.RequestID, _ = middleware.GetRequestID(r.Context())
+	apierror.Write(w, statusCode, e)
 }
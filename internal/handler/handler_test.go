@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"llmgateway/config"
+	"llmgateway/internal/apierror"
+	"llmgateway/internal/logger"
+	"llmgateway/internal/metrics"
+	"llmgateway/internal/middleware"
+	"llmgateway/internal/models"
+	"llmgateway/internal/scheduler"
+	"llmgateway/internal/tracker"
+	"llmgateway/internal/usage"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeError reads the error envelope of a response
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) apierror.Error {
+	t.Helper()
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var body struct {
+		Error apierror.Error `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body), rec.Body.String())
+	return body.Error
+}
+
+func TestRoot(t *testing.T) {
+	h := &Handler{}
+
+	rec := httptest.NewRecorder()
+	h.Root(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"service":"LLM Gateway"`)
+
+	rec = httptest.NewRecorder()
+	h.Root(rec, httptest.NewRequest(http.MethodPost, "/v1/unknown", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	e := decodeError(t, rec)
+	assert.Equal(t, apierror.CodeNotFound, e.Code)
+	assert.Equal(t, "not_found_error", e.Type)
+}
+
+func TestUsageFiltersByKeyID(t *testing.T) {
+	store := usage.NewStore(time.Hour, usage.DefaultPricing)
+	store.Record(usage.Record{Timestamp: time.Now(), VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o"})
+	store.Record(usage.Record{Timestamp: time.Now(), VirtualKey: "vk_user2_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o"})
+	h := &Handler{usage: store}
+
+	rec := httptest.NewRecorder()
+	h.Usage(rec, httptest.NewRequest(http.MethodGet, "/usage?key_id="+models.KeyID("vk_user1_openai"), nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var report models.UsageReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Len(t, report.Buckets, 1)
+	require.Len(t, report.Buckets[0].Groups, 1)
+	assert.Equal(t, models.KeyID("vk_user1_openai"), report.Buckets[0].Groups[0].KeyID)
+
+	// Plaintext keys are refused rather than ending up in access logs
+	rec = httptest.NewRecorder()
+	h.Usage(rec, httptest.NewRequest(http.MethodGet, "/usage?virtual_key=vk_user1_openai", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, apierror.CodeInvalidRequest, decodeError(t, rec).Code)
+}
+
+// roundTripFunc answers upstream requests in place of the provider
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// stubUpstream routes requests to the providers through respond for the rest of the test
+func stubUpstream(t *testing.T, respond roundTripFunc) {
+	t.Helper()
+	original := http.DefaultTransport
+	http.DefaultTransport = respond
+	t.Cleanup(func() { http.DefaultTransport = original })
+}
+
+// newChatTestHandler serves /chat/completions behind authentication, with a quota of one
+// request per hour per key. vk_chat_openai's policy only allows gpt-4o-mini; vk_budget_openai
+// belongs to a team with a monthly budget.
+func newChatTestHandler(t *testing.T) (http.Handler, *tracker.Tracker) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"virtual_keys": {
+			"vk_chat_openai": {"provider": "openai", "api_key": "sk-chat", "policy": {"allowed_models": ["gpt-4o-mini"]}},
+			"vk_budget_openai": {"provider": "openai", "api_key": "sk-budget", "team": "search"}
+		},
+		"teams": {"search": {"budget_usd": 100}}
+	}`), 0600))
+	t.Setenv("KEYS_FILE_PATH", path)
+	t.Setenv("QUOTA_LIMIT", "1")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	log, err := logger.NewLogger(false, "")
+	require.NoError(t, err)
+
+	track := tracker.NewTracker(cfg.QuotaEnabled, cfg.QuotaLimit)
+	h := NewHandler(cfg, log, track, scheduler.NewScheduler(0, scheduler.PolicyWeighted), usage.NewStore(time.Hour, usage.DefaultPricing), metrics.NewRegistry())
+	return middleware.AuthMiddleware(cfg, track, nil, log)(http.HandlerFunc(h.ChatCompletions)), track
+}
+
+func postChat(handler http.Handler, model string) *httptest.ResponseRecorder {
+	return postChatAs(handler, "vk_chat_openai", model)
+}
+
+func postChatAs(handler http.Handler, virtualKey, model string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/chat/completions",
+		strings.NewReader(`{"model": "`+model+`", "messages": [{"role": "user", "content": "hi"}]}`))
+	req.Header.Set("Authorization", "Bearer "+virtualKey)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// requestsCharged returns how many requests the key's current quota window has been charged
+func requestsCharged(track *tracker.Tracker) int64 {
+	return track.Snapshot().Quotas[models.KeyID("vk_chat_openai")].RequestCount
+}
+
+func TestChatCompletionsQuotaExhausted(t *testing.T) {
+	handler, track := newChatTestHandler(t)
+	stubUpstream(t, func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"choices": []}`)), Request: r}, nil
+	})
+
+	require.Equal(t, http.StatusOK, postChat(handler, "gpt-4o-mini").Code)
+	assert.Equal(t, int64(1), requestsCharged(track))
+
+	rec := postChat(handler, "gpt-4o-mini")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	e := decodeError(t, rec)
+	assert.Equal(t, apierror.CodeQuotaExceeded, e.Code)
+	assert.Equal(t, "rate_limit_error", e.Type)
+	assert.Equal(t, int64(1), requestsCharged(track), "a refused request is not charged")
+	assert.Equal(t, int64(1), track.GetStats().OutcomesByKey[models.KeyID("vk_chat_openai")].Outcomes[models.OutcomeQuotaExceeded])
+}
+
+func TestChatCompletionsPolicyDenied(t *testing.T) {
+	handler, track := newChatTestHandler(t)
+	stubUpstream(t, func(r *http.Request) (*http.Response, error) {
+		t.Error("a denied request must not reach the provider")
+		return nil, io.EOF
+	})
+
+	rec := postChat(handler, "gpt-4o")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	e := decodeError(t, rec)
+	assert.Equal(t, apierror.CodePolicyViolation, e.Code)
+	assert.Equal(t, "permission_error", e.Type)
+
+	// The reservation was released: the key's only slot is free again
+	assert.Equal(t, int64(0), requestsCharged(track))
+	res, err := track.Reserve("vk_chat_openai")
+	require.NoError(t, err)
+	track.Settle(res, models.OutcomeValidationError)
+}
+
+func TestChatCompletionsUpstreamError(t *testing.T) {
+	handler, track := newChatTestHandler(t)
+	stubUpstream(t, func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"error": {"message": "The server had an error", "type": "server_error"}}`)), Request: r}, nil
+	})
+
+	rec := postChat(handler, "gpt-4o-mini")
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	e := decodeError(t, rec)
+	assert.Equal(t, apierror.CodeUpstreamError, e.Code)
+	assert.Equal(t, "api_error", e.Type)
+	assert.Equal(t, "The server had an error", e.Message)
+	assert.Equal(t, models.ProviderOpenAI, e.Provider)
+	assert.JSONEq(t, `{"error": {"message": "The server had an error", "type": "server_error"}}`, string(e.ProviderError))
+
+	// Upstream errors are charged by default, so the reservation was committed
+	assert.Equal(t, int64(1), requestsCharged(track))
+	_, err := track.Reserve("vk_chat_openai")
+	assert.ErrorIs(t, err, tracker.ErrQuotaExceeded)
+}
+
+func TestChatCompletionsUpstreamUnreachable(t *testing.T) {
+	handler, track := newChatTestHandler(t)
+	stubUpstream(t, func(r *http.Request) (*http.Response, error) {
+		return nil, io.ErrUnexpectedEOF
+	})
+
+	rec := postChat(handler, "gpt-4o-mini")
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, apierror.CodeUpstreamUnavailable, decodeError(t, rec).Code)
+
+	// Transport errors are not charged, so the reservation was released
+	assert.Equal(t, int64(0), requestsCharged(track))
+	_, err := track.Reserve("vk_chat_openai")
+	assert.NoError(t, err)
+}
+
+func TestChatCompletionsRefusesUnpricedModelUnderBudget(t *testing.T) {
+	handler, track := newChatTestHandler(t)
+	upstreamCalls := 0
+	stubUpstream(t, func(r *http.Request) (*http.Response, error) {
+		upstreamCalls++
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"choices": [], "usage": {"prompt_tokens": 10, "completion_tokens": 5}}`)), Request: r}, nil
+	})
+
+	// An unpriced model would cost $0 and never trip the team's budget, so it is refused
+	rec := postChatAs(handler, "vk_budget_openai", "gpt-unreleased")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	e := decodeError(t, rec)
+	assert.Equal(t, apierror.CodePolicyViolation, e.Code)
+	assert.Contains(t, e.Message, "team search budget")
+	assert.Equal(t, 0, upstreamCalls)
+	assert.Equal(t, int64(0), track.Snapshot().Quotas[models.KeyID("vk_budget_openai")].RequestCount, "the reservation was released")
+
+	// Priced models are charged against the budget as usual
+	require.Equal(t, http.StatusOK, postChatAs(handler, "vk_budget_openai", "gpt-4o").Code)
+	assert.Greater(t, track.GetStats().Teams["search"].MonthSpendUSD, 0.0)
+}
+
+func TestChatCompletionsLabelsByReportedModel(t *testing.T) {
+	handler, track := newChatTestHandler(t)
+	stubUpstream(t, func(r *http.Request) (*http.Response, error) {
+		var request struct {
+			Model string `json:"model"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		reported := map[string]string{"gpt-4o-mini": "gpt-4o-mini-2024-07-18", "gpt-4o": "ft:gpt-4o:acme::custom"}[request.Model]
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"model": "` + reported + `", "choices": []}`)), Request: r}, nil
+	})
+
+	require.Equal(t, http.StatusOK, postChat(handler, "gpt-4o-mini").Code)
+	require.Equal(t, http.StatusOK, postChatAs(handler, "vk_budget_openai", "gpt-4o").Code)
+
+	// The provider's model is recorded, and models without a known price share one label
+	var labels []string
+	for _, latency := range track.Snapshot().Latencies {
+		labels = append(labels, latency.Model)
+	}
+	assert.ElementsMatch(t, []string{"gpt-4o-mini-2024-07-18", usage.OtherModel}, labels)
+}
+
+func TestChatCompletionsRecordsTimeToFirstByte(t *testing.T) {
+	handler, track := newChatTestHandler(t)
+	stubUpstream(t, func(r *http.Request) (*http.Response, error) {
+		// Report the first byte as a real transport would
+		httptrace.ContextClientTrace(r.Context()).GotFirstResponseByte()
+		time.Sleep(20 * time.Millisecond)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"choices": []}`)), Request: r}, nil
+	})
+
+	require.Equal(t, http.StatusOK, postChat(handler, "gpt-4o-mini").Code)
+
+	latencies := track.Snapshot().Latencies
+	require.Len(t, latencies, 1)
+	assert.Equal(t, int64(1), latencies[0].TTFT.Count())
+	latency := track.GetStats().Latency[models.ProviderOpenAI]["gpt-4o-mini"]
+	assert.Less(t, latency.TTFT.P99, latency.Upstream.P50, "the first byte arrived before the response was read")
+}
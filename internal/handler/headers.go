@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"llmgateway/internal/models"
+	"llmgateway/internal/proxy"
+	"llmgateway/internal/tracker"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// UpstreamRequestIDHeader carries the provider's request ID; X-Request-ID is the gateway's own
+const UpstreamRequestIDHeader = "X-Upstream-Request-ID"
+
+// writeUpstreamHeaders sets the headers of a proxied response: the provider's content type,
+// the provider headers the response policy passes through, and the caller's gateway quota
+// in place of the provider's request rate limits
+func (h *Handler) writeUpstreamHeaders(header http.Header, provider models.Provider, upstream http.Header, reservation *tracker.Reservation) {
+	for key, values := range h.responses.Filter(upstream) {
+		header[key] = values
+	}
+	if id := proxy.UpstreamRequestID(upstream); id != "" {
+		header.Set(UpstreamRequestIDHeader, id)
+	}
+	if status, ok := reservation.Status(); ok {
+		rewriteRateLimits(header, provider, status, h.responses, time.Now())
+	}
+
+	contentType := upstream.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	header.Set("Content-Type", contentType)
+}
+
+// rewriteRateLimits replaces the provider's request rate-limit headers with the caller's
+// gateway quota, the tightest of the key's and its groups', in the provider's own format so that SDKs read them as usual. The provider's
+// figures describe the gateway's provider account, shared by every virtual key, so they
+// say little about how many requests this caller has left. Token limits are left as they are.
+func rewriteRateLimits(header http.Header, provider models.Provider, status tracker.QuotaStatus, policy *proxy.ResponseHeaderPolicy, now time.Time) {
+	var limit, remaining, reset, resetValue string
+	switch provider {
+	case models.ProviderOpenAI:
+		limit, remaining, reset = "X-Ratelimit-Limit-Requests", "X-Ratelimit-Remaining-Requests", "X-Ratelimit-Reset-Requests"
+		resetValue = max(status.ResetAt.Sub(now), 0).Round(time.Second).String()
+	case models.ProviderAnthropic:
+		limit, remaining, reset = "Anthropic-Ratelimit-Requests-Limit", "Anthropic-Ratelimit-Requests-Remaining", "Anthropic-Ratelimit-Requests-Reset"
+		resetValue = status.ResetAt.UTC().Format(time.RFC3339)
+	default:
+		return
+	}
+
+	set := func(name, value string) {
+		if policy.Allows(name) {
+			header.Set(name, value)
+		}
+	}
+	set(limit, strconv.FormatInt(status.Limit, 10))
+	set(remaining, strconv.FormatInt(max(status.Remaining, 0), 10))
+	set(reset, resetValue)
+}
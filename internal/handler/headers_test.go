@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"llmgateway/internal/models"
+	"llmgateway/internal/proxy"
+	"llmgateway/internal/tracker"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteUpstreamHeaders(t *testing.T) {
+	h := &Handler{responses: proxy.NewResponseHeaderPolicy(proxy.DefaultResponseHeaderAllowlist)}
+	upstream := http.Header{
+		"Content-Type":                   {"text/event-stream; charset=utf-8"},
+		"X-Request-Id":                   {"req_abc123"},
+		"Openai-Processing-Ms":           {"412"},
+		"X-Ratelimit-Limit-Tokens":       {"30000"},
+		"X-Ratelimit-Remaining-Requests": {"499"},
+		"Set-Cookie":                     {"__cf_bm=abc"},
+		"Cf-Ray":                         {"8a1b2c3d"},
+	}
+
+	header := http.Header{}
+	header.Set("X-Request-ID", "gateway-id")
+	h.writeUpstreamHeaders(header, models.ProviderOpenAI, upstream, nil)
+
+	assert.Equal(t, http.Header{
+		"Content-Type":                   {"text/event-stream; charset=utf-8"},
+		"X-Request-Id":                   {"gateway-id"},
+		"X-Upstream-Request-Id":          {"req_abc123"},
+		"Openai-Processing-Ms":           {"412"},
+		"X-Ratelimit-Limit-Tokens":       {"30000"},
+		"X-Ratelimit-Remaining-Requests": {"499"},
+	}, header, "without a gateway quota the provider's rate limits pass through")
+
+	// The content type defaults to JSON when the provider sends none
+	header = http.Header{}
+	h.writeUpstreamHeaders(header, models.ProviderOpenAI, http.Header{}, nil)
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+}
+
+func TestWriteUpstreamHeadersRewritesRateLimits(t *testing.T) {
+	h := &Handler{responses: proxy.NewResponseHeaderPolicy(proxy.DefaultResponseHeaderAllowlist)}
+	track := tracker.NewTracker(true, 100)
+	reservation, err := track.Reserve("vk_test")
+	require.NoError(t, err)
+
+	header := http.Header{}
+	h.writeUpstreamHeaders(header, models.ProviderOpenAI, http.Header{
+		"X-Ratelimit-Limit-Requests":     {"10000"},
+		"X-Ratelimit-Remaining-Requests": {"9999"},
+		"X-Ratelimit-Reset-Requests":     {"6ms"},
+		"X-Ratelimit-Limit-Tokens":       {"30000"},
+	}, reservation)
+
+	assert.Equal(t, "100", header.Get("X-Ratelimit-Limit-Requests"))
+	assert.Equal(t, "99", header.Get("X-Ratelimit-Remaining-Requests"))
+	reset, err := time.ParseDuration(header.Get("X-Ratelimit-Reset-Requests"))
+	require.NoError(t, err)
+	assert.InDelta(t, time.Until(time.Now().Truncate(time.Hour).Add(time.Hour)).Seconds(), reset.Seconds(), 60)
+	assert.Equal(t, "30000", header.Get("X-Ratelimit-Limit-Tokens"), "token limits are left as they are")
+}
+
+func TestRewriteRateLimitsAnthropic(t *testing.T) {
+	now := time.Date(2026, 5, 3, 12, 0, 0, 0, time.UTC)
+	status := tracker.QuotaStatus{Limit: 50, Remaining: 7, ResetAt: now.Add(20 * time.Minute)}
+
+	header := http.Header{}
+	rewriteRateLimits(header, models.ProviderAnthropic, status, proxy.NewResponseHeaderPolicy(proxy.DefaultResponseHeaderAllowlist), now)
+	assert.Equal(t, http.Header{
+		"Anthropic-Ratelimit-Requests-Limit":     {"50"},
+		"Anthropic-Ratelimit-Requests-Remaining": {"7"},
+		"Anthropic-Ratelimit-Requests-Reset":     {"2026-05-03T12:20:00Z"},
+	}, header)
+
+	// Headers excluded from the response allowlist are not added back
+	header = http.Header{}
+	rewriteRateLimits(header, models.ProviderAnthropic, status, proxy.NewResponseHeaderPolicy([]string{"Request-Id"}), now)
+	assert.Empty(t, header)
+}
@@ -0,0 +1,136 @@
+package histogram
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// bucketBounds are the inclusive upper bounds (in milliseconds) of the histogram buckets.
+// They grow roughly exponentially so relative error stays bounded from 1ms to 10min.
+var bucketBounds = func() []float64 {
+	var bounds []float64
+	for bound := 1.0; bound < 600000; bound *= 1.25 {
+		// Rounding up to whole milliseconds collapses the smallest steps
+		if rounded := math.Ceil(bound); len(bounds) == 0 || rounded > bounds[len(bounds)-1] {
+			bounds = append(bounds, rounded)
+		}
+	}
+	return append(bounds, 600000)
+}()
+
+// Histogram is a fixed-bucket latency histogram. Histograms can be merged, which makes
+// them suitable for aggregating across time buckets and label sets.
+// The zero value is not usable; create histograms with New.
+type Histogram struct {
+	counts []int64 // One count per bound, plus an overflow bucket
+	count  int64
+	max    float64
+}
+
+// New creates an empty histogram
+func New() *Histogram {
+	return &Histogram{counts: make([]int64, len(bucketBounds)+1)}
+}
+
+// Observe records a single value in milliseconds
+func (h *Histogram) Observe(valueMs float64) {
+	h.counts[bucketIndex(valueMs)]++
+	h.count++
+	if valueMs > h.max {
+		h.max = valueMs
+	}
+}
+
+// Merge adds all observations from other into h
+func (h *Histogram) Merge(other *Histogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+// Quantile returns an estimate of the q-th quantile (0 < q <= 1) in milliseconds.
+// The estimate is the upper bound of the bucket containing the quantile, capped at the
+// largest observed value.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var running int64
+	for i, c := range h.counts {
+		running += c
+		if running >= rank {
+			if i >= len(bucketBounds) || bucketBounds[i] > h.max {
+				return h.max
+			}
+			return bucketBounds[i]
+		}
+	}
+	return h.max
+}
+
+// bucketIndex returns the index of the first bucket whose bound is >= value
+func bucketIndex(valueMs float64) int {
+	lo, hi := 0, len(bucketBounds)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if bucketBounds[mid] >= valueMs {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo
+}
+
+// histogramJSON is the persisted form of a Histogram. Trailing empty buckets are left out.
+type histogramJSON struct {
+	Counts []int64 `json:"counts"`
+	Max    float64 `json:"max"`
+}
+
+// MarshalJSON encodes the histogram so it can be persisted
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	last := len(h.counts)
+	for last > 0 && h.counts[last-1] == 0 {
+		last--
+	}
+	return json.Marshal(histogramJSON{Counts: h.counts[:last], Max: h.max})
+}
+
+// UnmarshalJSON restores a histogram encoded by MarshalJSON
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var encoded histogramJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	if len(encoded.Counts) > len(bucketBounds)+1 {
+		return fmt.Errorf("histogram has %d buckets, expected at most %d", len(encoded.Counts), len(bucketBounds)+1)
+	}
+
+	*h = *New()
+	copy(h.counts, encoded.Counts)
+	for _, c := range encoded.Counts {
+		if c < 0 {
+			return fmt.Errorf("histogram has a negative bucket count")
+		}
+		h.count += c
+	}
+	h.max = encoded.Max
+	return nil
+}
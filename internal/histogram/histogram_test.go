@@ -0,0 +1,77 @@
+package histogram
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuantileEmpty(t *testing.T) {
+	h := New()
+	assert.Equal(t, 0.0, h.Quantile(0.5))
+}
+
+func TestQuantileWithinBucketError(t *testing.T) {
+	h := New()
+	for i := 1; i <= 1000; i++ {
+		h.Observe(float64(i))
+	}
+
+	// Bucket bounds grow by 25%, so estimates are within that of the exact value
+	assert.InEpsilon(t, 500.0, h.Quantile(0.50), 0.25)
+	assert.InEpsilon(t, 900.0, h.Quantile(0.90), 0.25)
+	assert.InEpsilon(t, 990.0, h.Quantile(0.99), 0.25)
+	assert.Equal(t, 1000.0, h.Quantile(1.0))
+}
+
+func TestQuantileCappedAtMax(t *testing.T) {
+	h := New()
+	h.Observe(101)
+
+	assert.Equal(t, 101.0, h.Quantile(0.99))
+}
+
+func TestOverflow(t *testing.T) {
+	h := New()
+	h.Observe(10 * 60 * 1000 * 2)
+
+	assert.Equal(t, int64(1), h.Count())
+	assert.Equal(t, 1200000.0, h.Quantile(0.5))
+	assert.Equal(t, int64(1), h.counts[len(bucketBounds)], "the value lands in the overflow bucket")
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(), New()
+	a.Observe(10)
+	b.Observe(1000)
+	b.Observe(2000)
+
+	a.Merge(b)
+	assert.Equal(t, int64(3), a.Count())
+	assert.Equal(t, 2000.0, a.Quantile(1.0))
+	assert.Equal(t, int64(2), b.Count(), "the merged histogram is unchanged")
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	h := New()
+	for _, v := range []float64{3, 40, 40, 870, 1200000} {
+		h.Observe(v)
+	}
+
+	data, err := json.Marshal(h)
+	require.NoError(t, err)
+	restored := New()
+	require.NoError(t, json.Unmarshal(data, restored))
+	assert.Equal(t, h, restored)
+
+	// Only buckets up to the last non-empty one are written
+	data, err = json.Marshal(New())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"counts":[],"max":0}`, string(data))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"counts":[`+strings.Repeat("0,", len(bucketBounds)+1)+`1]}`), restored))
+	assert.Error(t, json.Unmarshal([]byte(`{"counts":[-1]}`), restored))
+}
@@ -0,0 +1,347 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	_ "crypto/sha256" // Hash implementations for RS/ES 256, 384 and 512
+	_ "crypto/sha512"
+)
+
+// minRefreshInterval limits how often the JWKS is refetched after a stale or unknown key,
+// so that an unreachable endpoint costs at most one fetch per interval
+const minRefreshInterval = 30 * time.Second
+
+// Reasons a token is rejected
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrUnknownKeyID     = errors.New("unknown signing key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("token has expired")
+	ErrNotYetValid      = errors.New("token is not yet valid")
+	ErrWrongIssuer      = errors.New("unexpected issuer")
+	ErrWrongAudience    = errors.New("unexpected audience")
+)
+
+// Options configures a Verifier. Exactly one of JWKSURL and JWKSFile must be set.
+type Options struct {
+	JWKSURL         string
+	JWKSFile        string
+	RefreshInterval time.Duration // How long fetched keys are used before refetching; 0 for 1 hour
+	Issuer          string        // Required "iss" value; empty accepts any
+	Audience        string        // Required "aud" entry; empty accepts any
+	GroupsClaim     string        // Claim holding the caller's groups; empty for "groups"
+	Leeway          time.Duration // Clock skew tolerated on exp and nbf
+	Client          *http.Client  // Used for JWKSURL; nil for a client with a 10s timeout
+}
+
+// Claims are the token claims the gateway acts on
+type Claims struct {
+	Subject   string
+	Groups    []string
+	Issuer    string
+	ExpiresAt time.Time
+}
+
+// Verifier validates JWTs against a cached JSON Web Key Set.
+// Keys are refetched in the background once RefreshInterval has passed, while the cached
+// set stays in service, and early when a token is signed with an unknown key ID so that
+// rotated keys are picked up promptly. Only one fetch runs at a time.
+type Verifier struct {
+	opts Options
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	refreshing  chan struct{} // Closed when the running refresh finishes; nil when idle
+}
+
+// NewVerifier creates a verifier and loads the key set, failing if it cannot be loaded
+func NewVerifier(opts Options) (*Verifier, error) {
+	if (opts.JWKSURL == "") == (opts.JWKSFile == "") {
+		return nil, fmt.Errorf("exactly one of the JWKS URL and JWKS file must be set")
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = time.Hour
+	}
+	if opts.GroupsClaim == "" {
+		opts.GroupsClaim = "groups"
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	v := &Verifier{opts: opts}
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// LooksLikeJWT reports whether a bearer token is a compact JWT rather than a virtual key:
+// three dot-separated parts, the first a base64url-encoded JSON object. Tokens with the
+// virtual key prefix never are, whatever they contain.
+func LooksLikeJWT(token string) bool {
+	if strings.HasPrefix(token, "vk_") || strings.Count(token, ".") != 2 {
+		return false
+	}
+	var header map[string]any
+	return decodeSegment(token[:strings.IndexByte(token, '.')], &header) == nil
+}
+
+// Verify checks a token's signature, lifetime, issuer and audience and returns its claims
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %w", ErrMalformed, err)
+	}
+	hash, ok := algorithmHash(header.Alg)
+	if !ok {
+		return Claims{}, fmt.Errorf("%w %q", ErrUnsupportedAlg, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature: %w", ErrMalformed, err)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(header.Alg, key, h.Sum(nil), hash, signature) {
+		return Claims{}, ErrInvalidSignature
+	}
+
+	var payload map[string]any
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return Claims{}, fmt.Errorf("%w: payload: %w", ErrMalformed, err)
+	}
+	return v.checkClaims(payload, time.Now())
+}
+
+// checkClaims validates the registered claims and extracts the ones the gateway uses
+func (v *Verifier) checkClaims(payload map[string]any, now time.Time) (Claims, error) {
+	claims := Claims{}
+	claims.Subject, _ = payload["sub"].(string)
+	claims.Issuer, _ = payload["iss"].(string)
+
+	exp, ok := payload["exp"].(float64)
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: missing exp claim", ErrMalformed)
+	}
+	claims.ExpiresAt = time.Unix(int64(exp), 0)
+	if !now.Before(claims.ExpiresAt.Add(v.opts.Leeway)) {
+		return Claims{}, ErrExpired
+	}
+	if nbf, ok := payload["nbf"].(float64); ok && now.Add(v.opts.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return Claims{}, ErrNotYetValid
+	}
+
+	if v.opts.Issuer != "" && claims.Issuer != v.opts.Issuer {
+		return Claims{}, fmt.Errorf("%w %q", ErrWrongIssuer, claims.Issuer)
+	}
+	if v.opts.Audience != "" && !slices.Contains(stringList(payload["aud"]), v.opts.Audience) {
+		return Claims{}, ErrWrongAudience
+	}
+
+	claims.Groups = stringList(payload[v.opts.GroupsClaim])
+	return claims, nil
+}
+
+// key returns the public key for a key ID. A stale key set is refreshed in the
+// background; a set that does not contain the ID is refreshed before giving up.
+// Refreshes are attempted at most once per minRefreshInterval.
+func (v *Verifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	key, found := v.lookup(kid)
+	due := time.Since(v.attemptedAt) > minRefreshInterval
+	var done chan struct{}
+	switch {
+	case found:
+		if due && time.Since(v.fetchedAt) > v.opts.RefreshInterval {
+			v.startRefresh()
+		}
+	case v.refreshing != nil:
+		done = v.refreshing
+	case due:
+		done = v.startRefresh()
+	}
+	v.mu.Unlock()
+
+	if done != nil {
+		<-done
+		v.mu.Lock()
+		key, found = v.lookup(kid)
+		v.mu.Unlock()
+	}
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKeyID, kid)
+	}
+	return key, nil
+}
+
+// startRefresh refreshes the key set in the background unless a refresh is already
+// running, and returns a channel closed when it finishes. A failed refresh keeps the
+// previous keys in service. Caller must hold v.mu.
+func (v *Verifier) startRefresh() chan struct{} {
+	if v.refreshing == nil {
+		done := make(chan struct{})
+		v.refreshing = done
+		v.attemptedAt = time.Now()
+		go func() {
+			v.refresh()
+			v.mu.Lock()
+			v.refreshing = nil
+			v.mu.Unlock()
+			close(done)
+		}()
+	}
+	return v.refreshing
+}
+
+// lookup finds a key by ID. A token without a kid is accepted only when the set has
+// a single key. Caller must hold v.mu.
+func (v *Verifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, found := v.keys[kid]
+	return key, found
+}
+
+// refresh loads the key set from its file or URL
+func (v *Verifier) refresh() error {
+	v.mu.Lock()
+	v.attemptedAt = time.Now()
+	v.mu.Unlock()
+
+	data, err := v.fetch()
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+// fetch reads the raw key set
+func (v *Verifier) fetch() ([]byte, error) {
+	if v.opts.JWKSFile != "" {
+		data, err := os.ReadFile(v.opts.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+		}
+		return data, nil
+	}
+
+	resp, err := v.opts.Client.Get(v.opts.JWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	return data, nil
+}
+
+// algorithmHash maps a JWS algorithm to its hash. Only asymmetric algorithms are
+// supported: "none" and the HMAC family are always rejected.
+func algorithmHash(alg string) (crypto.Hash, bool) {
+	switch alg {
+	case "RS256", "ES256":
+		return crypto.SHA256, true
+	case "RS384", "ES384":
+		return crypto.SHA384, true
+	case "RS512", "ES512":
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// verifySignature checks a signature with a key of the type the algorithm requires
+func verifySignature(alg string, key crypto.PublicKey, digest []byte, hash crypto.Hash, signature []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// Each ES algorithm is tied to one curve
+		curves := map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+		bits := k.Curve.Params().BitSize
+		size := (bits + 7) / 8
+		if curves[alg] != bits || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JSON segment
+func decodeSegment(segment string, target any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// stringList reads a claim that may be a single string or an array of strings
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
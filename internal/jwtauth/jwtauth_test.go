@@ -0,0 +1,341 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey is a signing key published in a test JWKS
+type testKey struct {
+	kid string
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newRSAKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return testKey{kid: kid, rsa: key}
+}
+
+func newECKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return testKey{kid: kid, ec: key}
+}
+
+// jwk renders the public half of the key
+func (k testKey) jwk() map[string]string {
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	if k.rsa != nil {
+		return map[string]string{
+			"kty": "RSA", "kid": k.kid, "use": "sig",
+			"n": b64(k.rsa.N.Bytes()),
+			"e": b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		}
+	}
+	return map[string]string{
+		"kty": "EC", "kid": k.kid, "crv": "P-256",
+		"x": b64(k.ec.X.FillBytes(make([]byte, 32))),
+		"y": b64(k.ec.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// sign creates a compact JWT with the given claims
+func (k testKey) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	alg := "RS256"
+	if k.ec != nil {
+		alg = "ES256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": k.kid})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	if k.rsa != nil {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	} else {
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwksServer serves a key set that tests can swap to simulate rotation, or fail to
+// simulate an outage
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []testKey
+	fetches atomic.Int32
+	failing atomic.Bool
+}
+
+func startJWKSServer(t *testing.T, keys ...testKey) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		if s.failing.Load() {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		json.NewEncoder(w).Encode(jwks(s.keys...))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func jwks(keys ...testKey) map[string]any {
+	set := make([]map[string]string, 0, len(keys))
+	for _, key := range keys {
+		set = append(set, key.jwk())
+	}
+	return map[string]any{"keys": set}
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":    "svc-search",
+		"iss":    "https://idp.example.com",
+		"aud":    []string{"llm-gateway"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": []string{"ml-platform", "search"},
+	}
+}
+
+func TestVerifyRSAAndEC(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t, "rsa-1"), newECKey(t, "ec-1")
+	server := startJWKSServer(t, rsaKey, ecKey)
+
+	verifier, err := NewVerifier(Options{JWKSURL: server.URL, Issuer: "https://idp.example.com", Audience: "llm-gateway"})
+	require.NoError(t, err)
+
+	for _, key := range []testKey{rsaKey, ecKey} {
+		claims, err := verifier.Verify(key.sign(t, validClaims()))
+		require.NoError(t, err, key.kid)
+		assert.Equal(t, "svc-search", claims.Subject)
+		assert.Equal(t, []string{"ml-platform", "search"}, claims.Groups)
+	}
+}
+
+func TestVerifyRejections(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	server := startJWKSServer(t, key)
+	verifier, err := NewVerifier(Options{JWKSURL: server.URL, Issuer: "https://idp.example.com", Audience: "llm-gateway"})
+	require.NoError(t, err)
+
+	with := func(name string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tampered := key.sign(t, validClaims())
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"attacker","exp":9999999999}`)) + "."
+
+	for name, tc := range map[string]struct {
+		token string
+		err   error
+	}{
+		"expired":        {key.sign(t, with("exp", time.Now().Add(-time.Hour).Unix())), ErrExpired},
+		"missing exp":    {key.sign(t, with("exp", nil)), ErrMalformed},
+		"not yet valid":  {key.sign(t, with("nbf", time.Now().Add(time.Hour).Unix())), ErrNotYetValid},
+		"wrong issuer":   {key.sign(t, with("iss", "https://evil.example.com")), ErrWrongIssuer},
+		"wrong audience": {key.sign(t, with("aud", "someone-else")), ErrWrongAudience},
+		"bad signature":  {tampered, ErrInvalidSignature},
+		"alg none":       {unsigned, ErrUnsupportedAlg},
+		"foreign key":    {newRSAKey(t, "rsa-1").sign(t, validClaims()), ErrInvalidSignature},
+		"not a jwt":      {"vk_plain", ErrMalformed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := verifier.Verify(tc.token)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestVerifyPicksUpRotatedKeys(t *testing.T) {
+	oldKey, newKey := newRSAKey(t, "2026-01"), newRSAKey(t, "2026-02")
+	server := startJWKSServer(t, oldKey)
+	verifier, err := NewVerifier(Options{JWKSURL: server.URL})
+	require.NoError(t, err)
+
+	server.setKeys(oldKey, newKey)
+	// Pretend the last fetch was long enough ago that an unknown kid may trigger another
+	verifier.attemptedAt = time.Time{}
+
+	_, err = verifier.Verify(newKey.sign(t, validClaims()))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), server.fetches.Load())
+
+	// Unknown key IDs do not refetch more than once per interval
+	_, err = verifier.Verify(newRSAKey(t, "unknown").sign(t, validClaims()))
+	assert.ErrorIs(t, err, ErrUnknownKeyID)
+	assert.Equal(t, int32(2), server.fetches.Load())
+}
+
+func TestVerifierKeepsKeysWhenRefreshFails(t *testing.T) {
+	key := newECKey(t, "ec-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, err := json.Marshal(jwks(key))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	verifier, err := NewVerifier(Options{JWKSFile: path, RefreshInterval: time.Nanosecond})
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+	_, err = verifier.Verify(key.sign(t, validClaims()))
+	assert.NoError(t, err)
+}
+
+// waitForRefresh waits until no background refresh is running
+func waitForRefresh(t *testing.T, verifier *Verifier) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		verifier.mu.Lock()
+		defer verifier.mu.Unlock()
+		return verifier.refreshing == nil
+	}, time.Second, time.Millisecond)
+}
+
+// verifyConcurrently verifies token from several goroutines and returns their errors
+func verifyConcurrently(verifier *Verifier, token string) []error {
+	errs := make([]error, 20)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = verifier.Verify(token)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestVerifierServesStaleKeysWhileJWKSDown(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	server := startJWKSServer(t, key)
+	verifier, err := NewVerifier(Options{JWKSURL: server.URL, RefreshInterval: time.Nanosecond})
+	require.NoError(t, err)
+	server.failing.Store(true)
+	verifier.attemptedAt = time.Time{}
+
+	// Requests with stale keys are served from the cache without waiting on the endpoint
+	for _, err := range verifyConcurrently(verifier, key.sign(t, validClaims())) {
+		assert.NoError(t, err)
+	}
+	waitForRefresh(t, verifier)
+	assert.Equal(t, int32(2), server.fetches.Load(), "the failing endpoint is fetched only once")
+
+	_, err = verifier.Verify(key.sign(t, validClaims()))
+	assert.NoError(t, err)
+	waitForRefresh(t, verifier)
+	assert.Equal(t, int32(2), server.fetches.Load(), "a failed refresh is not retried within the interval")
+}
+
+func TestVerifierRefetchesOnceForUnknownKeyWhileJWKSDown(t *testing.T) {
+	server := startJWKSServer(t, newRSAKey(t, "rsa-1"))
+	verifier, err := NewVerifier(Options{JWKSURL: server.URL})
+	require.NoError(t, err)
+	server.failing.Store(true)
+	verifier.attemptedAt = time.Time{}
+
+	for _, err := range verifyConcurrently(verifier, newRSAKey(t, "unknown").sign(t, validClaims())) {
+		assert.ErrorIs(t, err, ErrUnknownKeyID)
+	}
+	assert.Equal(t, int32(2), server.fetches.Load())
+}
+
+func TestNewVerifierRequiresOneSource(t *testing.T) {
+	_, err := NewVerifier(Options{})
+	assert.Error(t, err)
+	_, err = NewVerifier(Options{JWKSURL: "http://a", JWKSFile: "b"})
+	assert.Error(t, err)
+}
+
+func TestParseJWKSRejectsWeakRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	data, err := json.Marshal(jwks(testKey{kid: "weak", rsa: key}))
+	require.NoError(t, err)
+
+	_, err = ParseJWKS(data)
+	assert.Error(t, err)
+}
+
+func TestParseJWKSSkipsUnusableKeys(t *testing.T) {
+	good := newECKey(t, "ec-1")
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	ps256 := newRSAKey(t, "rsa-pss").jwk()
+	ps256["alg"] = "PS256"
+	set := jwks(good, testKey{kid: "weak", rsa: weak})
+	set["keys"] = append(set["keys"].([]map[string]string), ps256,
+		map[string]string{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		map[string]string{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": "AAAA"})
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+
+	keys, err := ParseJWKS(data)
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Contains(t, keys, "ec-1")
+}
+
+func TestLooksLikeJWT(t *testing.T) {
+	// {"alg":"RS256"}
+	assert.True(t, LooksLikeJWT("eyJhbGciOiJSUzI1NiJ9.eyJz.c2ln"))
+	assert.True(t, LooksLikeJWT(newRSAKey(t, "k1").sign(t, validClaims())))
+
+	assert.False(t, LooksLikeJWT("vk_1234567890ab_cdef"))
+	assert.False(t, LooksLikeJWT("vk_eyJhbGciOiJSUzI1NiJ9.eyJz.c2ln"), "the virtual key prefix wins")
+	assert.False(t, LooksLikeJWT("key.with.dots"), "the header is not base64url JSON")
+	assert.False(t, LooksLikeJWT("WzFd.eyJz.c2ln"), "the header is not a JSON object")
+	assert.False(t, LooksLikeJWT("eyJhbGciOiJSUzI1NiJ9.eyJz"))
+}
@@ -86,22 +86,32 @@ func (l *Logger) LogError(message string, err error) {
 	}
 }
 
+// LogWarn logs a warning that needs operator attention but is not an error
+func (l *Logger) LogWarn(message string, data map[string]any) {
+	l.logLevel("warn", message, data)
+}
+
 // LogInfo logs an informational message
 func (l *Logger) LogInfo(message string, data map[string]any) {
+	l.logLevel("info", message, data)
+}
+
+// logLevel writes a message with additional data fields at the given level
+func (l *Logger) logLevel(level, message string, data map[string]any) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	infoLog := map[string]any{
-		"level":   "info",
+	entry := map[string]any{
+		"level":   level,
 		"message": message,
 	}
 
 	// Add additional data fields
 	for k, v := range data {
-		infoLog[k] = v
+		entry[k] = v
 	}
 
-	jsonData, _ := json.Marshal(infoLog)
+	jsonData, _ := json.Marshal(entry)
 	jsonData = append(jsonData, '\n')
 
 	if l.toStdout {
@@ -113,12 +123,16 @@ func (l *Logger) LogInfo(message string, data map[string]any) {
 	}
 }
 
-// Close closes the log file if it's open
+// Close flushes the log file to disk and closes it if it's open
 func (l *Logger) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	if l.file != nil {
+		if err := l.file.Sync(); err != nil {
+			l.file.Close()
+			return err
+		}
 		return l.file.Close()
 	}
 	return nil
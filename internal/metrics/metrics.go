@@ -0,0 +1,290 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"llmgateway/internal/models"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DurationBuckets are the upper bounds (in seconds) of the request duration histogram
+var DurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Observation describes one completed request
+type Observation struct {
+	Provider     models.Provider
+	Model        string
+	VirtualKey   string
+	StatusCode   int
+	DurationMs   int64
+	InputTokens  int64
+	OutputTokens int64
+	CachedTokens int64   // Input tokens the provider served from its prompt cache
+	Team         string  // Owning team, if any
+	Organization string  // The team's organization, if any
+	CostUSD      float64 // Estimated cost of the request
+}
+
+// requestLabels identifies a request counter/histogram series
+type requestLabels struct {
+	provider    models.Provider
+	model       string
+	keyHash     string
+	statusClass string
+	cache       string
+}
+
+// tokenLabels identifies a token counter series
+type tokenLabels struct {
+	provider  models.Provider
+	model     string
+	keyHash   string
+	tokenType string
+}
+
+// groupLabels identifies a team rollup series
+type groupLabels struct {
+	organization string
+	team         string
+}
+
+// groupSeries holds the rollup counters for one team
+type groupSeries struct {
+	requests  int64
+	costMicro int64 // Kept in integer micro-dollars to avoid float drift
+}
+
+// durationSeries holds the state of one histogram series
+type durationSeries struct {
+	buckets []int64 // Non-cumulative counts per bound
+	count   int64
+	sumMs   int64 // Kept in integer milliseconds to avoid float drift
+}
+
+// Registry accumulates request metrics and renders them in the Prometheus text format
+type Registry struct {
+	mu        sync.Mutex
+	requests  map[requestLabels]int64
+	tokens    map[tokenLabels]int64
+	durations map[requestLabels]*durationSeries
+	teams     map[groupLabels]*groupSeries
+}
+
+// NewRegistry creates an empty metrics registry
+func NewRegistry() *Registry {
+	return &Registry{
+		requests:  make(map[requestLabels]int64),
+		tokens:    make(map[tokenLabels]int64),
+		durations: make(map[requestLabels]*durationSeries),
+		teams:     make(map[groupLabels]*groupSeries),
+	}
+}
+
+// Observe records a completed request
+func (r *Registry) Observe(obs Observation) {
+	labels := requestLabels{
+		provider:    obs.Provider,
+		model:       obs.Model,
+		keyHash:     HashKey(obs.VirtualKey),
+		statusClass: StatusClass(obs.StatusCode),
+		cache:       CacheOutcome(obs.CachedTokens),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests[labels]++
+
+	series, exists := r.durations[labels]
+	if !exists {
+		series = &durationSeries{buckets: make([]int64, len(DurationBuckets))}
+		r.durations[labels] = series
+	}
+	seconds := float64(obs.DurationMs) / 1000
+	for i, bound := range DurationBuckets {
+		if seconds <= bound {
+			series.buckets[i]++
+			break
+		}
+	}
+	series.count++
+	series.sumMs += obs.DurationMs
+
+	if obs.InputTokens > 0 {
+		r.tokens[tokenLabels{obs.Provider, obs.Model, labels.keyHash, "input"}] += obs.InputTokens
+	}
+	if obs.OutputTokens > 0 {
+		r.tokens[tokenLabels{obs.Provider, obs.Model, labels.keyHash, "output"}] += obs.OutputTokens
+	}
+
+	if obs.Team != "" {
+		team := groupLabels{organization: obs.Organization, team: obs.Team}
+		rollup, exists := r.teams[team]
+		if !exists {
+			rollup = &groupSeries{}
+			r.teams[team] = rollup
+		}
+		rollup.requests++
+		rollup.costMicro += int64(math.Round(obs.CostUSD * 1e6))
+	}
+}
+
+// Write renders all metrics in the Prometheus text exposition format (version 0.0.4).
+// queues, if non-nil, is rendered as scheduler gauges.
+func (r *Registry) Write(w io.Writer, queues map[models.Provider]map[models.Priority]models.QueueStats) error {
+	var b strings.Builder
+
+	r.mu.Lock()
+
+	b.WriteString("# HELP llmgateway_requests_total Total proxied requests.\n")
+	b.WriteString("# TYPE llmgateway_requests_total counter\n")
+	for _, labels := range sortedRequestLabels(r.requests) {
+		fmt.Fprintf(&b, "llmgateway_requests_total{%s} %d\n", labels.format(), r.requests[labels])
+	}
+
+	b.WriteString("# HELP llmgateway_tokens_total Total tokens reported by providers.\n")
+	b.WriteString("# TYPE llmgateway_tokens_total counter\n")
+	for _, labels := range sortedTokenLabels(r.tokens) {
+		fmt.Fprintf(&b, "llmgateway_tokens_total{%s} %d\n", labels.format(), r.tokens[labels])
+	}
+
+	b.WriteString("# HELP llmgateway_request_duration_seconds End-to-end request duration.\n")
+	b.WriteString("# TYPE llmgateway_request_duration_seconds histogram\n")
+	for _, labels := range sortedRequestLabels(r.durations) {
+		series := r.durations[labels]
+		base := labels.format()
+		var cumulative int64
+		for i, bound := range DurationBuckets {
+			cumulative += series.buckets[i]
+			fmt.Fprintf(&b, "llmgateway_request_duration_seconds_bucket{%s,le=%q} %d\n", base, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(&b, "llmgateway_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", base, series.count)
+		fmt.Fprintf(&b, "llmgateway_request_duration_seconds_sum{%s} %s\n", base, formatFloat(float64(series.sumMs)/1000))
+		fmt.Fprintf(&b, "llmgateway_request_duration_seconds_count{%s} %d\n", base, series.count)
+	}
+
+	if len(r.teams) > 0 {
+		teams := make([]groupLabels, 0, len(r.teams))
+		for labels := range r.teams {
+			teams = append(teams, labels)
+		}
+		sort.Slice(teams, func(i, j int) bool { return teams[i].format() < teams[j].format() })
+
+		b.WriteString("# HELP llmgateway_team_requests_total Total proxied requests by team.\n")
+		b.WriteString("# TYPE llmgateway_team_requests_total counter\n")
+		for _, labels := range teams {
+			fmt.Fprintf(&b, "llmgateway_team_requests_total{%s} %d\n", labels.format(), r.teams[labels].requests)
+		}
+		b.WriteString("# HELP llmgateway_team_cost_usd_total Estimated spend by team in US dollars.\n")
+		b.WriteString("# TYPE llmgateway_team_cost_usd_total counter\n")
+		for _, labels := range teams {
+			fmt.Fprintf(&b, "llmgateway_team_cost_usd_total{%s} %s\n", labels.format(), formatFloat(float64(r.teams[labels].costMicro)/1e6))
+		}
+	}
+
+	r.mu.Unlock()
+
+	if queues != nil {
+		writeQueueGauges(&b, queues)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeQueueGauges renders scheduler queue statistics
+func writeQueueGauges(b *strings.Builder, queues map[models.Provider]map[models.Priority]models.QueueStats) {
+	providers := make([]string, 0, len(queues))
+	for provider := range queues {
+		providers = append(providers, string(provider))
+	}
+	sort.Strings(providers)
+
+	b.WriteString("# HELP llmgateway_queue_waiting Requests waiting for an upstream slot.\n")
+	b.WriteString("# TYPE llmgateway_queue_waiting gauge\n")
+	for _, provider := range providers {
+		for _, priority := range models.Priorities {
+			fmt.Fprintf(b, "llmgateway_queue_waiting{provider=%q,priority=%q} %d\n", provider, priority, queues[models.Provider(provider)][priority].Queued)
+		}
+	}
+
+	b.WriteString("# HELP llmgateway_queue_active Requests holding an upstream slot.\n")
+	b.WriteString("# TYPE llmgateway_queue_active gauge\n")
+	for _, provider := range providers {
+		for _, priority := range models.Priorities {
+			fmt.Fprintf(b, "llmgateway_queue_active{provider=%q,priority=%q} %d\n", provider, priority, queues[models.Provider(provider)][priority].Active)
+		}
+	}
+}
+
+// HashKey returns a short, stable, non-reversible label for a virtual key.
+// It matches the key's ID in the admin API.
+func HashKey(virtualKey string) string {
+	return models.KeyID(virtualKey)
+}
+
+// StatusClass buckets an HTTP status code into "2xx", "4xx", etc.
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// CacheOutcome labels whether the provider served part of the prompt from its prompt cache.
+// The gateway does not cache responses itself, so this is the only cache a request can hit.
+func CacheOutcome(cachedTokens int64) string {
+	if cachedTokens > 0 {
+		return "hit"
+	}
+	return "miss"
+}
+
+func (l requestLabels) format() string {
+	return fmt.Sprintf("provider=%s,model=%s,virtual_key=%s,status_class=%s,cache=%s",
+		quote(string(l.provider)), quote(l.model), quote(l.keyHash), quote(l.statusClass), quote(l.cache))
+}
+
+func (l tokenLabels) format() string {
+	return fmt.Sprintf("provider=%s,model=%s,virtual_key=%s,type=%s",
+		quote(string(l.provider)), quote(l.model), quote(l.keyHash), quote(l.tokenType))
+}
+
+func (l groupLabels) format() string {
+	return fmt.Sprintf("organization=%s,team=%s", quote(l.organization), quote(l.team))
+}
+
+// quote escapes a label value as required by the exposition format
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedRequestLabels returns map keys in a stable order so output is deterministic
+func sortedRequestLabels[V any](m map[requestLabels]V) []requestLabels {
+	keys := make([]requestLabels, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].format() < keys[j].format() })
+	return keys
+}
+
+func sortedTokenLabels(m map[tokenLabels]int64) []tokenLabels {
+	keys := make([]tokenLabels, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].format() < keys[j].format() })
+	return keys
+}
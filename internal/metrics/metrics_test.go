@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"llmgateway/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCountersAndHistogram(t *testing.T) {
+	registry := NewRegistry()
+	registry.Observe(Observation{Provider: models.ProviderOpenAI, Model: "gpt-4o", VirtualKey: "vk_a", StatusCode: 200, DurationMs: 80, InputTokens: 10, OutputTokens: 20})
+	registry.Observe(Observation{Provider: models.ProviderOpenAI, Model: "gpt-4o", VirtualKey: "vk_a", StatusCode: 200, DurationMs: 700})
+	registry.Observe(Observation{Provider: models.ProviderOpenAI, Model: "gpt-4o", VirtualKey: "vk_a", StatusCode: 502, DurationMs: 90000})
+	registry.Observe(Observation{Provider: models.ProviderOpenAI, Model: "gpt-4o", VirtualKey: "vk_a", StatusCode: 200, DurationMs: 50, InputTokens: 2048, CachedTokens: 1024})
+
+	var out strings.Builder
+	require.NoError(t, registry.Write(&out, nil))
+	text := out.String()
+
+	base := `provider="openai",model="gpt-4o",virtual_key="` + HashKey("vk_a") + `"`
+	assert.Contains(t, text, "# TYPE llmgateway_requests_total counter\n")
+	assert.Contains(t, text, "llmgateway_requests_total{"+base+`,status_class="2xx",cache="miss"} 2`)
+	assert.Contains(t, text, "llmgateway_requests_total{"+base+`,status_class="5xx",cache="miss"} 1`)
+	assert.Contains(t, text, "llmgateway_requests_total{"+base+`,status_class="2xx",cache="hit"} 1`)
+	assert.Contains(t, text, "llmgateway_tokens_total{"+base+`,type="input"} 2058`)
+	assert.Contains(t, text, "llmgateway_tokens_total{"+base+`,type="output"} 20`)
+
+	// Histogram buckets are cumulative and +Inf equals count
+	assert.Contains(t, text, "llmgateway_request_duration_seconds_bucket{"+base+`,status_class="2xx",cache="miss",le="0.1"} 1`)
+	assert.Contains(t, text, "llmgateway_request_duration_seconds_bucket{"+base+`,status_class="2xx",cache="miss",le="1"} 2`)
+	assert.Contains(t, text, "llmgateway_request_duration_seconds_bucket{"+base+`,status_class="5xx",cache="miss",le="60"} 0`)
+	assert.Contains(t, text, "llmgateway_request_duration_seconds_bucket{"+base+`,status_class="5xx",cache="miss",le="+Inf"} 1`)
+	assert.Contains(t, text, "llmgateway_request_duration_seconds_sum{"+base+`,status_class="2xx",cache="miss"} 0.78`)
+	assert.Contains(t, text, "llmgateway_request_duration_seconds_count{"+base+`,status_class="2xx",cache="miss"} 2`)
+
+	assert.NotContains(t, text, "vk_a", "virtual keys must not appear in labels")
+}
+
+func TestWriteQueueGauges(t *testing.T) {
+	registry := NewRegistry()
+	queues := map[models.Provider]map[models.Priority]models.QueueStats{
+		models.ProviderAnthropic: {
+			models.PriorityBatch: {Queued: 3, Active: 1},
+		},
+	}
+
+	var out strings.Builder
+	require.NoError(t, registry.Write(&out, queues))
+
+	assert.Contains(t, out.String(), `llmgateway_queue_waiting{provider="anthropic",priority="batch"} 3`)
+	assert.Contains(t, out.String(), `llmgateway_queue_active{provider="anthropic",priority="batch"} 1`)
+	assert.Contains(t, out.String(), `llmgateway_queue_waiting{provider="anthropic",priority="interactive"} 0`)
+}
+
+func TestLabelEscaping(t *testing.T) {
+	assert.Equal(t, `"a\"b\\c\nd"`, quote("a\"b\\c\nd"))
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", StatusClass(200))
+	assert.Equal(t, "4xx", StatusClass(429))
+	assert.Equal(t, "5xx", StatusClass(503))
+	assert.Equal(t, "unknown", StatusClass(0))
+}
+
+func TestCacheOutcome(t *testing.T) {
+	assert.Equal(t, "hit", CacheOutcome(1))
+	assert.Equal(t, "miss", CacheOutcome(0))
+}
+
+func TestWriteTeamRollups(t *testing.T) {
+	registry := NewRegistry()
+	registry.Observe(Observation{Provider: models.ProviderOpenAI, Model: "gpt-4o", VirtualKey: "vk_a", StatusCode: 200, Team: "search", Organization: "acme", CostUSD: 0.1})
+	registry.Observe(Observation{Provider: models.ProviderOpenAI, Model: "gpt-4o", VirtualKey: "vk_b", StatusCode: 200, Team: "search", Organization: "acme", CostUSD: 0.2})
+	registry.Observe(Observation{Provider: models.ProviderOpenAI, Model: "gpt-4o", VirtualKey: "vk_c", StatusCode: 200})
+
+	var out strings.Builder
+	require.NoError(t, registry.Write(&out, nil))
+	text := out.String()
+
+	assert.Contains(t, text, `llmgateway_team_requests_total{organization="acme",team="search"} 2`)
+	assert.Contains(t, text, `llmgateway_team_cost_usd_total{organization="acme",team="search"} 0.3`)
+	assert.Equal(t, 1, strings.Count(text, "llmgateway_team_requests_total{"), "keys without a team are not rolled up")
+}
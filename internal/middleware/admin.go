@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"llmgateway/config"
+	"llmgateway/internal/apierror"
+	"net/http"
+)
+
+// AdminAuthMiddleware requires the admin API key, sent the same ways a virtual key can be
+// (see credential). Every request is rejected when no admin key is configured.
+func AdminAuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := credential(r)
+			switch {
+			case err != nil:
+				writeJSONError(w, r, http.StatusUnauthorized, apierror.CodeInvalidKey, err.Error())
+				return
+			case token == "":
+				writeJSONError(w, r, http.StatusUnauthorized, apierror.CodeMissingCredentials, "missing credentials: send the admin key as a Bearer token")
+				return
+			case cfg.AdminAPIKey == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminAPIKey)) != 1:
+				writeJSONError(w, r, http.StatusUnauthorized, apierror.CodeInvalidKey, "invalid admin credentials")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
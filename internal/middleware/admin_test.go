@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"encoding/json"
+	"llmgateway/config"
+	"llmgateway/internal/apierror"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name       string
+		adminKey   string
+		headers    map[string]string
+		wantStatus int
+		wantCode   apierror.Code
+	}{
+		{"valid token", "admin-secret", map[string]string{"Authorization": "Bearer admin-secret"}, http.StatusNoContent, ""},
+		{"lowercase scheme", "admin-secret", map[string]string{"Authorization": "bearer admin-secret"}, http.StatusNoContent, ""},
+		{"extra whitespace", "admin-secret", map[string]string{"Authorization": "Bearer   admin-secret "}, http.StatusNoContent, ""},
+		{"x-api-key header", "admin-secret", map[string]string{"X-Api-Key": "admin-secret"}, http.StatusNoContent, ""},
+		{"wrong token", "admin-secret", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized, apierror.CodeInvalidKey},
+		{"missing header", "admin-secret", nil, http.StatusUnauthorized, apierror.CodeMissingCredentials},
+		{"not bearer", "admin-secret", map[string]string{"Authorization": "admin-secret"}, http.StatusUnauthorized, apierror.CodeInvalidKey},
+		{"no admin key configured", "", map[string]string{"Authorization": "Bearer x"}, http.StatusUnauthorized, apierror.CodeInvalidKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := AdminAuthMiddleware(&config.Config{AdminAPIKey: tt.adminKey})(next)
+			req := httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantCode != "" {
+				var body struct {
+					Error apierror.Error `json:"error"`
+				}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, tt.wantCode, body.Error.Code)
+			}
+		})
+	}
+}
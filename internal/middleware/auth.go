@@ -2,11 +2,19 @@ package middleware
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"llmgateway/config"
+	"llmgateway/internal/apierror"
+	"llmgateway/internal/clientip"
+	"llmgateway/internal/jwtauth"
+	"llmgateway/internal/logger"
 	"llmgateway/internal/models"
+	"llmgateway/internal/tracing"
+	"llmgateway/internal/tracker"
 	"net/http"
 	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // ContextKey is a custom type for context keys to avoid collisions
@@ -17,45 +25,141 @@ const (
 	VirtualKeyContextKey ContextKey = "virtualKey"
 	// KeyConfigContextKey is the key for storing the key config in context
 	KeyConfigContextKey ContextKey = "keyConfig"
+	// SubjectContextKey is the key for storing the subject of an authenticating JWT or client certificate in context
+	SubjectContextKey ContextKey = "subject"
 )
 
-// AuthMiddleware validates the virtual API key from the Authorization header
-func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+// AuthMiddleware validates the virtual API key from the Authorization header, or from
+// the header or query parameter a provider's SDK puts its API key in (see credential).
+// If verifier is non-nil, JWTs are also accepted: they are verified against
+// its key set and mapped to a virtual key through jwt_mappings. Requests without any
+// token may instead present a verified client certificate, mapped to a
+// virtual key through cert_mappings.
+// Keys with allowed_cidrs are refused with 403 when the client IP, resolved through
+// cfg.TrustedProxies, is outside every range; each refusal is logged as an audit entry.
+// Rejected requests are recorded as auth failures in the tracker.
+func AuthMiddleware(cfg *config.Config, track *tracker.Tracker, verifier *jwtauth.Verifier, log *logger.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Extract the Authorization header
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				writeJSONError(w, http.StatusUnauthorized, "missing Authorization header")
-				return
+			_, span := tracing.Start(r.Context(), "gateway.auth")
+
+			reject := func(code apierror.Code, message string) {
+				span.SetAttributes(attribute.String("gateway.auth.error", message))
+				span.End()
+				// The presented key is not recorded: invalid keys must not create new label values
+				track.RecordOutcome("", "", models.OutcomeAuthFailure, 0)
+				writeJSONError(w, r, http.StatusUnauthorized, code, message)
 			}
 
-			// Parse the Bearer token
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				writeJSONError(w, http.StatusUnauthorized, "invalid Authorization header format")
+			var (
+				virtualKey string
+				keyConfig  models.VirtualKeyConfig
+				subject    string // Set for JWT and client certificate authentication
+				err        error
+			)
+
+			token, credErr := credential(r)
+			switch {
+			case credErr != nil:
+				reject(apierror.CodeInvalidKey, credErr.Error())
 				return
-			}
 
-			virtualKey := parts[1]
+			case token == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0:
+				// Without a token a verified client certificate can identify the caller
+				leaf := r.TLS.VerifiedChains[0][0]
+				subject = leaf.Subject.String()
+				virtualKey, keyConfig, err = cfg.AuthenticateClientCert(leaf)
 
-			// Validate the virtual key
-			keyConfig, valid := cfg.ValidateVirtualKey(virtualKey)
-			if !valid {
-				writeJSONError(w, http.StatusUnauthorized, "invalid virtual key")
+			case token == "":
+				reject(apierror.CodeMissingCredentials, "missing credentials: send the virtual key as a Bearer token")
 				return
+
+			case verifier != nil && jwtauth.LooksLikeJWT(token):
+				// A JWT stands in for the virtual key it is mapped to
+				claims, verifyErr := verifier.Verify(token)
+				if verifyErr != nil {
+					reject(apierror.CodeInvalidKey, "invalid token: "+verifyErr.Error())
+					return
+				}
+				subject = claims.Subject
+				virtualKey, keyConfig, err = cfg.AuthenticateJWT(claims.Subject, claims.Groups)
+
+			default:
+				// Validate the virtual key; hashed keys are identified by their lookup name from here on.
+				// Disabled, expired and not-yet-valid keys get their own messages.
+				virtualKey, keyConfig, err = cfg.AuthenticateVirtualKey(token)
+			}
+			if err != nil {
+				reject(apierror.CodeInvalidKey, err.Error())
+				return
+			}
+
+			// A leaked key is only usable from the networks it was issued for
+			if len(keyConfig.AllowedCIDRs) > 0 {
+				clientIP := clientip.Resolve(r, cfg.TrustedProxies)
+				allowed, _ := clientip.ParsePrefixes(keyConfig.AllowedCIDRs) // Validated when the keys were loaded
+				if !clientIP.IsValid() || !clientip.Contains(allowed, clientIP) {
+					audit := map[string]any{
+						"audit":       true,
+						"virtual_key": models.MaskKey(virtualKey),
+						"client_ip":   clientIP.String(),
+						"remote_addr": r.RemoteAddr,
+					}
+					if requestID, ok := GetRequestID(r.Context()); ok {
+						audit["request_id"] = requestID
+					}
+					if subject != "" {
+						audit["subject"] = subject
+					}
+					log.LogWarn("Rejected virtual key used from a disallowed client IP", audit)
+					span.SetAttributes(attribute.String("gateway.auth.error", "client IP not allowed"))
+					span.End()
+					track.RecordOutcome(keyConfig.Provider, virtualKey, models.OutcomeAuthFailure, 0)
+					writeJSONError(w, r, http.StatusForbidden, apierror.CodeIPNotAllowed, "client IP is not allowed to use this virtual key")
+					return
+				}
+			}
+
+			ctx := r.Context()
+			if subject != "" {
+				span.SetAttributes(attribute.String("gateway.auth.subject", subject))
+				ctx = context.WithValue(ctx, SubjectContextKey, subject)
 			}
 
 			// Store the virtual key and config in the request context
-			ctx := context.WithValue(r.Context(), VirtualKeyContextKey, virtualKey)
+			ctx = context.WithValue(ctx, VirtualKeyContextKey, virtualKey)
 			ctx = context.WithValue(ctx, KeyConfigContextKey, keyConfig)
 
+			// End the auth span before handing off so it covers only authentication
+			span.End()
+
 			// Call the next handler with the updated context
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// credential returns the token a request authenticates with, or "" if it carries none.
+// Besides "Authorization: Bearer", the places provider SDKs put their API key are accepted
+// so that they work unchanged against the gateway: the x-api-key header (Anthropic), the
+// api-key header (Azure OpenAI) and the key query parameter (Gemini), tried in that order.
+func credential(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); strings.TrimSpace(header) != "" {
+		// The scheme is case-insensitive and may be separated from the token by any whitespace
+		fields := strings.Fields(header)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "bearer") {
+			return "", errors.New("invalid Authorization header format")
+		}
+		return fields[1], nil
+	}
+	for _, name := range []string{"x-api-key", "api-key"} {
+		if token := strings.TrimSpace(r.Header.Get(name)); token != "" {
+			return token, nil
+		}
+	}
+	return r.URL.Query().Get("key"), nil
+}
+
 // GetVirtualKey retrieves the virtual key from the request context
 func GetVirtualKey(ctx context.Context) (string, bool) {
 	virtualKey, ok := ctx.Value(VirtualKeyContextKey).(string)
@@ -68,16 +172,15 @@ func GetKeyConfig(ctx context.Context) (models.VirtualKeyConfig, bool) {
 	return keyConfig, ok
 }
 
-// writeJSONError writes a JSON error response
-func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	response := map[string]any{
-		"error": map[string]any{
-			"message": message,
-			"type":    "invalid_request_error",
-			"code":    statusCode,
-		},
-	}
-	json.NewEncoder(w).Encode(response)
+// GetSubject retrieves the subject of the JWT or client certificate that authenticated the request, if any
+func GetSubject(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(SubjectContextKey).(string)
+	return subject, ok
+}
+
+// writeJSONError writes an error response in the gateway's error envelope
+func writeJSONError(w http.ResponseWriter, r *http.Request, statusCode int, code apierror.Code, message string) {
+	e := apierror.New(code, message)
+	e.RequestID, _ = GetRequestID(r.Context())
+	apierror.Write(w, statusCode, e)
 }
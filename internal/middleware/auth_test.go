@@ -0,0 +1,328 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"llmgateway/config"
+	"llmgateway/internal/apierror"
+	"llmgateway/internal/clientip"
+	"llmgateway/internal/jwtauth"
+	"llmgateway/internal/logger"
+	"llmgateway/internal/models"
+	"llmgateway/internal/tracker"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthMiddlewareRejectionMessages(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {
+		"vk_active": {"provider": "openai", "api_key": "sk"},
+		"vk_disabled": {"provider": "openai", "api_key": "sk", "disabled": true},
+		"vk_expired": {"provider": "openai", "api_key": "sk", "expires_at": "`+past+`"},
+		"vk_pending": {"provider": "openai", "api_key": "sk", "not_before": "`+future+`"}
+	}}`), 0600))
+	t.Setenv("KEYS_FILE_PATH", path)
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	var gotKey string
+	handler := AuthMiddleware(cfg, tracker.NewTracker(true, 10), nil, newTestLogger(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, _ = GetVirtualKey(r.Context())
+	}))
+
+	tests := []struct {
+		header      string
+		wantCode    apierror.Code
+		wantMessage string
+	}{
+		{"", apierror.CodeMissingCredentials, "missing credentials: send the virtual key as a Bearer token"},
+		{"vk_active", apierror.CodeInvalidKey, "invalid Authorization header format"},
+		{"Basic dXNlcjpwYXNz", apierror.CodeInvalidKey, "invalid Authorization header format"},
+		{"Bearer vk_active extra", apierror.CodeInvalidKey, "invalid Authorization header format"},
+		{"Bearer vk_unknown", apierror.CodeInvalidKey, "invalid virtual key"},
+		{"Bearer vk_disabled", apierror.CodeInvalidKey, "virtual key is disabled"},
+		{"Bearer vk_expired", apierror.CodeInvalidKey, "virtual key has expired (expired at " + past + ")"},
+		{"Bearer vk_pending", apierror.CodeInvalidKey, "virtual key is not yet valid (valid from " + future + ")"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusUnauthorized, rec.Code, tt.header)
+		var body struct {
+			Error apierror.Error `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, tt.wantMessage, body.Error.Message)
+		assert.Equal(t, tt.wantCode, body.Error.Code)
+		assert.Equal(t, "authentication_error", body.Error.Type)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer vk_active")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "vk_active", gotKey)
+}
+
+// signES256 creates a JWT signed with key and a JWKS publishing it under kid "test"
+func signES256(t *testing.T, key *ecdsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"test"}`))
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthMiddlewareAcceptsMappedJWTs(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "EC", "kid": "test", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	defer jwksServer.Close()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"virtual_keys": {
+			"vk_static": {"provider": "openai", "api_key": "sk"},
+			"vk_search_profile": {"provider": "anthropic", "api_key": "sk-ant"},
+			"vk_ml_profile": {"provider": "openai", "api_key": "sk-ml"}
+		},
+		"jwt_mappings": [
+			{"subject": "svc-search", "virtual_key": "vk_search_profile"},
+			{"group": "ml-platform", "virtual_key": "vk_ml_profile"}
+		]
+	}`), 0600))
+	t.Setenv("KEYS_FILE_PATH", path)
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	verifier, err := jwtauth.NewVerifier(jwtauth.Options{JWKSURL: jwksServer.URL, Audience: "llm-gateway"})
+	require.NoError(t, err)
+
+	var gotKey, gotSubject string
+	handler := AuthMiddleware(cfg, tracker.NewTracker(true, 10), verifier, newTestLogger(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, _ = GetVirtualKey(r.Context())
+		gotSubject, _ = GetSubject(r.Context())
+	}))
+	serve := func(token string) *httptest.ResponseRecorder {
+		gotKey, gotSubject = "", ""
+		req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	claims := func(subject string, groups ...string) map[string]any {
+		return map[string]any{"sub": subject, "aud": "llm-gateway", "groups": groups, "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	rec := serve(signES256(t, key, claims("svc-search")))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "vk_search_profile", gotKey)
+	assert.Equal(t, "svc-search", gotSubject)
+
+	rec = serve(signES256(t, key, claims("notebook-42", "ml-platform")))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "vk_ml_profile", gotKey)
+
+	rec = serve(signES256(t, key, claims("stranger", "marketing")))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "token is not mapped to a virtual key")
+
+	expired := claims("svc-search")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	rec = serve(signES256(t, key, expired))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid token: token has expired")
+
+	// Static virtual keys keep working alongside JWTs
+	rec = serve("vk_static")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "vk_static", gotKey)
+	assert.Empty(t, gotSubject)
+}
+
+func TestAuthMiddlewareAcceptsMappedClientCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"virtual_keys": {
+			"vk_static": {"provider": "openai", "api_key": "sk"},
+			"vk_search_profile": {"provider": "anthropic", "api_key": "sk-ant"}
+		},
+		"cert_mappings": [{"common_name": "svc-search", "virtual_key": "vk_search_profile"}]
+	}`), 0600))
+	t.Setenv("KEYS_FILE_PATH", path)
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	var gotKey, gotSubject string
+	handler := AuthMiddleware(cfg, tracker.NewTracker(true, 10), nil, newTestLogger(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, _ = GetVirtualKey(r.Context())
+		gotSubject, _ = GetSubject(r.Context())
+	}))
+	serve := func(commonName, authorization string) *httptest.ResponseRecorder {
+		gotKey, gotSubject = "", ""
+		req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+		if commonName != "" {
+			leaf := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("svc-search", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "vk_search_profile", gotKey)
+	assert.Equal(t, "CN=svc-search", gotSubject)
+
+	rec = serve("stranger", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "client certificate is not mapped to a virtual key")
+
+	// An explicit bearer token takes precedence over the certificate
+	rec = serve("svc-search", "Bearer vk_static")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "vk_static", gotKey)
+	assert.Empty(t, gotSubject)
+}
+
+func newTestLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	log, err := logger.NewLogger(false, "")
+	require.NoError(t, err)
+	return log
+}
+
+func TestAuthMiddlewareEnforcesAllowedCIDRs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"virtual_keys": {
+			"vk_office_only": {"provider": "openai", "api_key": "sk", "allowed_cidrs": ["198.51.100.0/24", "2001:db8::/32"]},
+			"vk_anywhere": {"provider": "openai", "api_key": "sk"}
+		}
+	}`), 0600))
+	t.Setenv("KEYS_FILE_PATH", path)
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.TrustedProxies, err = clientip.ParsePrefixes([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	track := tracker.NewTracker(true, 10)
+	handler := AuthMiddleware(cfg, track, nil, newTestLogger(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(key, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+key)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serve("vk_office_only", "198.51.100.20:40000", "").Code)
+	assert.Equal(t, http.StatusOK, serve("vk_office_only", "[2001:db8::7]:40000", "").Code)
+	assert.Equal(t, http.StatusOK, serve("vk_office_only", "10.0.0.2:40000", "198.51.100.20").Code, "client IP reported by a trusted proxy")
+	assert.Equal(t, http.StatusOK, serve("vk_anywhere", "203.0.113.5:40000", "").Code)
+
+	rec := serve("vk_office_only", "203.0.113.5:40000", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "client IP is not allowed to use this virtual key")
+	assert.Contains(t, rec.Body.String(), `"code":"ip_not_allowed"`)
+
+	// Forwarding headers from an untrusted peer are ignored
+	rec = serve("vk_office_only", "203.0.113.5:40000", "198.51.100.20")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serve("vk_office_only", "10.0.0.2:40000", "203.0.113.5")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	assert.Equal(t, int64(3), track.GetStats().OutcomesByKey[models.KeyID("vk_office_only")].Outcomes[models.OutcomeAuthFailure])
+}
+
+func TestAuthMiddlewareAcceptsProviderNativeCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {"vk_active": {"provider": "openai", "api_key": "sk"}}}`), 0600))
+	t.Setenv("KEYS_FILE_PATH", path)
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	var gotKey string
+	handler := AuthMiddleware(cfg, tracker.NewTracker(true, 10), nil, newTestLogger(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, _ = GetVirtualKey(r.Context())
+	}))
+
+	tests := map[string]struct {
+		target string
+		header http.Header
+	}{
+		"bearer":              {"/chat/completions", http.Header{"Authorization": {"Bearer vk_active"}}},
+		"lowercase scheme":    {"/chat/completions", http.Header{"Authorization": {"bearer vk_active"}}},
+		"extra whitespace":    {"/chat/completions", http.Header{"Authorization": {"  Bearer \t  vk_active  "}}},
+		"anthropic x-api-key": {"/chat/completions", http.Header{"X-Api-Key": {"vk_active"}}},
+		"azure api-key":       {"/chat/completions", http.Header{"Api-Key": {"vk_active"}}},
+		"gemini query":        {"/chat/completions?key=vk_active", nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			gotKey = ""
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, "vk_active", gotKey)
+		})
+	}
+
+	// The Authorization header wins when several carriers are present
+	req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+	req.Header.Set("Authorization", "Bearer vk_unknown")
+	req.Header.Set("X-Api-Key", "vk_active")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"llmgateway/internal/apierror"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// CleanPaths answers requests for paths that are not in canonical form, such as
+// "//chat/completions" or "/a/../usage", with a not_found error. http.ServeMux would
+// redirect them instead, with an HTML body, and clients following a 301 turn a POST
+// into a GET.
+func CleanPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect && cleanPath(r.URL.Path) != r.URL.Path {
+			writeJSONError(w, r, http.StatusNotFound, apierror.CodeNotFound, "unknown endpoint "+r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cleanPath returns the canonical form of a path as http.ServeMux computes it:
+// rooted, without dot segments or repeated slashes, keeping a trailing slash
+func cleanPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
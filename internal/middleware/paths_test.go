@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"encoding/json"
+	"llmgateway/internal/apierror"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanPaths(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/admin/keys/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := CleanPaths(mux)
+
+	for _, p := range []string{"/chat/completions", "/admin/keys/", "/admin/keys/abc"} {
+		req := httptest.NewRequest(http.MethodPost, p, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code, p)
+	}
+
+	// The mux would answer these with an HTML redirect
+	for _, p := range []string{"//chat/completions", "/v1/../chat/completions", "/admin/keys//abc"} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.URL.Path = p
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusNotFound, rec.Code, p)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.Empty(t, rec.Header().Get("Location"))
+		var body struct {
+			Error apierror.Error `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, apierror.CodeNotFound, body.Error.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveWithRequestID(incoming string) (contextID string, rec *httptest.ResponseRecorder) {
+	contextID, _, rec = serveWithRequestIDHeader(incoming)
+	return contextID, rec
+}
+
+// serveWithRequestIDHeader also returns the X-Request-ID header seen by the next handler
+func serveWithRequestIDHeader(incoming string) (contextID, forwarded string, rec *httptest.ResponseRecorder) {
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextID, _ = GetRequestID(r.Context())
+		forwarded = r.Header.Get(RequestIDHeader)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+	if incoming != "" {
+		req.Header.Set(RequestIDHeader, incoming)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return contextID, forwarded, rec
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	id, rec := serveWithRequestID("")
+
+	assert.Len(t, id, 32)
+	assert.Equal(t, id, rec.Header().Get(RequestIDHeader))
+
+	other, _ := serveWithRequestID("")
+	assert.NotEqual(t, id, other)
+}
+
+func TestRequestIDAcceptsIncoming(t *testing.T) {
+	id, rec := serveWithRequestID("client-trace_42.a:b")
+
+	assert.Equal(t, "client-trace_42.a:b", id)
+	assert.Equal(t, id, rec.Header().Get(RequestIDHeader))
+}
+
+func TestRequestIDRejectsMalformedIncoming(t *testing.T) {
+	for _, incoming := range []string{"has space", `quote"d`, strings.Repeat("a", maxRequestIDLength+1)} {
+		id, rec := serveWithRequestID(incoming)
+
+		assert.NotEqual(t, incoming, id)
+		assert.Len(t, id, 32)
+		assert.Equal(t, id, rec.Header().Get(RequestIDHeader))
+	}
+}
+
+func TestRequestIDReplacesMalformedIncomingHeader(t *testing.T) {
+	id, forwarded, _ := serveWithRequestIDHeader("has space")
+
+	// The generated ID, not the rejected one, is what the proxy forwards upstream
+	assert.Equal(t, id, forwarded)
+}
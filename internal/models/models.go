@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"time"
+)
 
 // Provider represents an LLM provider type
 type Provider string
@@ -10,28 +15,163 @@ const (
 	ProviderAnthropic Provider = "anthropic"
 )
 
+// Priority represents the scheduling class of a virtual key
+type Priority string
+
+const (
+	PriorityInteractive Priority = "interactive"
+	PriorityDefault     Priority = "default"
+	PriorityBatch       Priority = "batch"
+)
+
+// Priorities lists all priority classes from highest to lowest
+var Priorities = []Priority{PriorityInteractive, PriorityDefault, PriorityBatch}
+
 // VirtualKeyConfig represents the configuration for a single virtual key
 type VirtualKeyConfig struct {
 	Provider Provider `json:"provider"`
 	APIKey   string   `json:"api_key"`
+	Priority Priority `json:"priority,omitempty"`
+	Name     string   `json:"name,omitempty"`     // Human-readable owner or purpose
+	Disabled bool     `json:"disabled,omitempty"` // Rejected by authentication but kept on file
+
+	NotBefore *time.Time `json:"not_before,omitempty"` // Rejected before this time
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Rejected from this time on
+
+	Policy *RequestPolicy `json:"policy,omitempty"` // Restrictions on what requests may ask for
+
+	Team     string            `json:"team,omitempty"`     // Owning team, whose quota, budget and policy also apply
+	Metadata map[string]string `json:"metadata,omitempty"` // Free-form labels, merged over the team's and organization's
+
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"` // Client IP ranges the key may be used from; empty allows any
+
+	// KeyHash, when set, stores the virtual key as a salted hash. The entry is then
+	// named by the key's lookup prefix instead of the plaintext key.
+	KeyHash string `json:"key_hash,omitempty"`
+}
+
+// RequestPolicy restricts the requests a virtual key may send. Unset fields impose no restriction.
+type RequestPolicy struct {
+	AllowedModels  []string `json:"allowed_models,omitempty"` // Glob patterns, e.g. "gpt-4o-mini*"; empty allows all
+	DeniedModels   []string `json:"denied_models,omitempty"`  // Glob patterns; take precedence over allowed_models
+	MaxTokens      int      `json:"max_tokens,omitempty"`     // Upper bound on max_tokens, which becomes required
+	MinTemperature *float64 `json:"min_temperature,omitempty"`
+	MaxTemperature *float64 `json:"max_temperature,omitempty"`
+	AllowTools     *bool    `json:"allow_tools,omitempty"`
+	AllowVision    *bool    `json:"allow_vision,omitempty"`     // Image inputs in messages
+	AllowMultipleN *bool    `json:"allow_multiple_n,omitempty"` // n > 1 choices per request
+}
+
+// CertMapping routes callers authenticated by a verified client certificate to a
+// virtual key, as an alternative to a bearer token. Set fields must all match.
+type CertMapping struct {
+	CommonName string `json:"common_name,omitempty"` // Exact subject CN
+	URI        string `json:"uri,omitempty"`         // URI SAN, e.g. a SPIFFE ID
+	VirtualKey string `json:"virtual_key"`           // keys.json entry name (the lookup name for hashed keys)
+}
+
+// Matches reports whether a certificate's common name and URI SANs satisfy the mapping
+func (m CertMapping) Matches(commonName string, uris []string) bool {
+	if m.CommonName != "" && m.CommonName != commonName {
+		return false
+	}
+	return m.URI == "" || slices.Contains(uris, m.URI)
+}
+
+// GroupConfig holds the limits an organization or team places on the keys it owns.
+// Quota and budget apply to the group's keys combined; zero means no limit.
+type GroupConfig struct {
+	Name       string            `json:"name,omitempty"`
+	QuotaLimit int64             `json:"quota_limit,omitempty"` // Requests per hour
+	BudgetUSD  float64           `json:"budget_usd,omitempty"`  // Estimated spend per calendar month (UTC)
+	Policy     *RequestPolicy    `json:"policy,omitempty"`      // Checked in addition to each key's own policy
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// TeamConfig is a group of keys, optionally belonging to an organization
+type TeamConfig struct {
+	Organization string `json:"organization,omitempty"`
+	GroupConfig
 }
 
-// KeysConfig represents the structure of keys.json file
+// KeysConfig represents the structure of keys.json file.
+// VirtualKeys is keyed by the plaintext virtual key, or by its lookup prefix for hashed keys.
 type KeysConfig struct {
-	VirtualKeys map[string]VirtualKeyConfig `json:"virtual_keys"`
+	VirtualKeys   map[string]VirtualKeyConfig `json:"virtual_keys"`
+	Organizations map[string]GroupConfig      `json:"organizations,omitempty"`
+	Teams         map[string]TeamConfig       `json:"teams,omitempty"`
+	JWTMappings   []JWTMapping                `json:"jwt_mappings,omitempty"`  // Checked in order; the first match wins
+	CertMappings  []CertMapping               `json:"cert_mappings,omitempty"` // Checked in order; the first match wins
+}
+
+// JWTMapping routes callers authenticated by JWT to a virtual key, whose provider,
+// quota, team and policy they then use. Set fields must all match.
+type JWTMapping struct {
+	Subject    string `json:"subject,omitempty"` // Exact "sub" claim
+	Group      string `json:"group,omitempty"`   // Entry in the groups claim
+	VirtualKey string `json:"virtual_key"`       // keys.json entry name (the lookup name for hashed keys)
+}
+
+// Matches reports whether a token's subject and groups satisfy the mapping
+func (m JWTMapping) Matches(subject string, groups []string) bool {
+	if m.Subject != "" && m.Subject != subject {
+		return false
+	}
+	return m.Group == "" || slices.Contains(groups, m.Group)
+}
+
+// Lineage returns the configuration of a team and of the organization it belongs to.
+// Either is nil if it is not configured.
+func (k KeysConfig) Lineage(team string) (*TeamConfig, *GroupConfig) {
+	teamConfig, exists := k.Teams[team]
+	if team == "" || !exists {
+		return nil, nil
+	}
+	orgConfig, exists := k.Organizations[teamConfig.Organization]
+	if teamConfig.Organization == "" || !exists {
+		return &teamConfig, nil
+	}
+	return &teamConfig, &orgConfig
+}
+
+// VirtualKeyInfo describes a virtual key in the admin API without revealing its secrets
+type VirtualKeyInfo struct {
+	ID       string   `json:"id"`
+	Key      string   `json:"key"` // Masked, except in the response that creates the key
+	Name     string   `json:"name,omitempty"`
+	Provider Provider `json:"provider"`
+	APIKey   string   `json:"api_key"` // Masked, or the env:/file: reference it is read from
+	Priority Priority `json:"priority"`
+	Disabled bool     `json:"disabled"`
+	Hashed   bool     `json:"hashed"` // Only a hash of the key is stored
+
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	Policy *RequestPolicy `json:"policy,omitempty"`
+
+	Team     string            `json:"team,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
 }
 
 // LogEntry represents a single LLM interaction log entry
 type LogEntry struct {
-	Timestamp  string         `json:"timestamp"`
-	VirtualKey string         `json:"virtual_key"`
-	Provider   Provider       `json:"provider"`
-	Method     string         `json:"method"`
-	Status     int            `json:"status"`
-	DurationMs int64          `json:"duration_ms"`
-	Request    map[string]any `json:"request,omitempty"`
-	Response   map[string]any `json:"response,omitempty"`
-	Error      string         `json:"error,omitempty"`
+	Timestamp         string         `json:"timestamp"`
+	RequestID         string         `json:"request_id,omitempty"`
+	UpstreamRequestID string         `json:"upstream_request_id,omitempty"` // The provider's own request ID
+	VirtualKey        string         `json:"virtual_key"`
+	Subject           string         `json:"subject,omitempty"` // JWT subject, when authenticated by token
+	Team              string         `json:"team,omitempty"`
+	Organization      string         `json:"organization,omitempty"`
+	Provider          Provider       `json:"provider"`
+	Method            string         `json:"method"`
+	Status            int            `json:"status"`
+	DurationMs        int64          `json:"duration_ms"`
+	Request           map[string]any `json:"request,omitempty"`
+	Response          map[string]any `json:"response,omitempty"`
+	Error             string         `json:"error,omitempty"`
 }
 
 // ProviderEndpoint returns the API endpoint URL for a given provider
@@ -46,17 +186,151 @@ func (p Provider) Endpoint() string {
 	}
 }
 
+// IsValid reports whether the priority is a known class (empty means default)
+func (p Priority) IsValid() bool {
+	switch p {
+	case "", PriorityInteractive, PriorityDefault, PriorityBatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// OrDefault returns the priority, falling back to PriorityDefault when unset
+func (p Priority) OrDefault() Priority {
+	if p == "" {
+		return PriorityDefault
+	}
+	return p
+}
+
 // UsageStats tracks usage statistics for metrics
 type UsageStats struct {
-	TotalRequests      int64              `json:"total_requests"`
-	RequestsByProvider map[Provider]int64 `json:"requests_by_provider"`
-	AverageResponseMs  float64            `json:"average_response_ms"`
-	LastUpdated        time.Time          `json:"last_updated"`
+	TotalRequests      int64                                `json:"total_requests"`
+	RequestsByProvider map[Provider]int64                   `json:"requests_by_provider"`
+	AverageResponseMs  float64                              `json:"average_response_ms"`
+	Latency            map[Provider]map[string]LatencyStats `json:"latency,omitempty"` // Provider -> model -> latency
+	Queues             map[Provider]map[Priority]QueueStats `json:"queues,omitempty"`
+	ErrorRate          float64                              `json:"error_rate"` // Share of outcomes other than success
+	OutcomesByProvider map[Provider]OutcomeCounts           `json:"outcomes_by_provider,omitempty"`
+	OutcomesByKey      map[string]OutcomeCounts             `json:"outcomes_by_key,omitempty"` // Keyed by KeyID
+	Teams              map[string]GroupUsage                `json:"teams,omitempty"`
+	Organizations      map[string]GroupUsage                `json:"organizations,omitempty"`
+	LastUpdated        time.Time                            `json:"last_updated"`
+}
+
+// GroupUsage rolls up the usage of all keys in an organization or team
+type GroupUsage struct {
+	Requests      int64   `json:"requests"` // Requests that reached a provider
+	CostUSD       float64 `json:"cost_usd"` // Estimated spend since stats began
+	MonthSpendUSD float64 `json:"month_spend_usd"`
+	BudgetUSD     float64 `json:"budget_usd,omitempty"`
+	QuotaLimit    int64   `json:"quota_limit,omitempty"`
+}
+
+// LatencyStats summarizes latency distributions for one provider and model
+type LatencyStats struct {
+	Count    int64              `json:"count"`
+	Total    LatencyPercentiles `json:"total_ms"`    // Client-observed duration
+	Upstream LatencyPercentiles `json:"upstream_ms"` // Time spent waiting on the provider
+	Overhead LatencyPercentiles `json:"overhead_ms"` // Total minus upstream
+	TTFT     LatencyPercentiles `json:"ttft_ms"`     // Time until the provider's first response byte
+}
+
+// OutcomeCounts counts request outcomes and the upstream status codes behind them
+type OutcomeCounts struct {
+	Outcomes    map[Outcome]int64 `json:"outcomes"`
+	StatusCodes map[int]int64     `json:"status_codes,omitempty"` // Upstream status code -> count
+}
+
+// QueueStats tracks scheduler statistics for a single priority class
+type QueueStats struct {
+	Queued        int     `json:"queued"`
+	Active        int     `json:"active"`
+	Dispatched    int64   `json:"dispatched"`
+	Cancelled     int64   `json:"cancelled"`
+	AverageWaitMs float64 `json:"average_wait_ms"`
 }
 
 // QuotaInfo tracks rate limiting information per virtual key
 type QuotaInfo struct {
 	RequestCount int64
+	Reserved     int64 // Requests admitted but not yet committed or released
 	WindowStart  time.Time
 	MaxRequests  int64
 }
+
+// Outcome classifies how a proxied request ended
+type Outcome string
+
+const (
+	OutcomeSuccess         Outcome = "success"          // Provider returned a non-error status
+	OutcomeUpstreamError   Outcome = "upstream_error"   // Provider returned a 4xx/5xx status
+	OutcomeValidationError Outcome = "validation_error" // Rejected by the gateway before proxying
+	OutcomeTransportError  Outcome = "transport_error"  // Provider could not be reached
+	OutcomeTimeout         Outcome = "timeout"          // Gateway timeout before the provider answered
+	OutcomeAuthFailure     Outcome = "auth_failure"     // Missing or invalid virtual key
+	OutcomeQuotaExceeded   Outcome = "quota_exceeded"   // Rejected by the rate limiter
+)
+
+// IsValid reports whether the outcome is a known value
+func (o Outcome) IsValid() bool {
+	switch o {
+	case OutcomeSuccess, OutcomeUpstreamError, OutcomeValidationError, OutcomeTransportError, OutcomeTimeout,
+		OutcomeAuthFailure, OutcomeQuotaExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// LatencyPercentiles summarizes a latency distribution in milliseconds
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// UsageReport is the response of the /usage endpoint
+type UsageReport struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Bucket  string        `json:"bucket"`
+	Buckets []UsageBucket `json:"buckets"`
+}
+
+// UsageBucket holds usage for one hour or day
+type UsageBucket struct {
+	Start  time.Time    `json:"start"`
+	Groups []UsageGroup `json:"groups"`
+}
+
+// UsageGroup holds aggregated usage for one virtual key, provider and model
+type UsageGroup struct {
+	KeyID        string             `json:"key_id"`      // Identifies the key, see KeyID
+	VirtualKey   string             `json:"virtual_key"` // Masked, for display only
+	Provider     Provider           `json:"provider"`
+	Model        string             `json:"model"`
+	Requests     int64              `json:"requests"`
+	Errors       int64              `json:"errors"`
+	InputTokens  int64              `json:"input_tokens"`
+	OutputTokens int64              `json:"output_tokens"`
+	TotalTokens  int64              `json:"total_tokens"`
+	CostUSD      float64            `json:"cost_usd"`
+	Latency      LatencyPercentiles `json:"latency_ms"`
+}
+
+// MaskKey shortens a virtual key so reports identify it without revealing it
+func MaskKey(virtualKey string) string {
+	if len(virtualKey) <= 12 {
+		return virtualKey[:min(4, len(virtualKey))] + "..."
+	}
+	return virtualKey[:8] + "..." + virtualKey[len(virtualKey)-4:]
+}
+
+// KeyID returns a short, stable, non-reversible identifier for a virtual key.
+// It is used to address keys in the admin API and as the metrics label.
+func KeyID(virtualKey string) string {
+	sum := sha256.Sum256([]byte(virtualKey))
+	return hex.EncodeToString(sum[:6])
+}
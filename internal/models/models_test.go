@@ -28,3 +28,9 @@ func TestProviderConstants(t *testing.T) {
 	assert.Equal(t, "openai", string(ProviderOpenAI))
 	assert.Equal(t, "anthropic", string(ProviderAnthropic))
 }
+
+func TestMaskKey(t *testing.T) {
+	assert.Equal(t, "vk_user1...enai", MaskKey("vk_user1_openai"))
+	assert.Equal(t, "vk_s...", MaskKey("vk_short"))
+	assert.Equal(t, "...", MaskKey(""))
+}
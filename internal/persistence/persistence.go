@@ -0,0 +1,141 @@
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"llmgateway/internal/fileutil"
+	"llmgateway/internal/logger"
+	"llmgateway/internal/tracker"
+	"llmgateway/internal/usage"
+	"os"
+	"sync"
+	"time"
+)
+
+// Persister periodically snapshots tracker state and usage history to a local file
+// and reloads them on startup
+type Persister struct {
+	path     string
+	interval time.Duration
+	tracker  *tracker.Tracker
+	usage    *usage.Store
+	logger   *logger.Logger
+
+	started  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// state is the layout of the state file. The tracker snapshot is embedded so that files
+// written before usage history was persisted still load.
+type state struct {
+	tracker.Snapshot
+	Usage *usage.Snapshot `json:"usage,omitempty"`
+}
+
+// NewPersister creates a new persister writing snapshots to path every interval
+func NewPersister(path string, interval time.Duration, track *tracker.Tracker, usageStore *usage.Store, log *logger.Logger) *Persister {
+	return &Persister{
+		path:     path,
+		interval: interval,
+		tracker:  track,
+		usage:    usageStore,
+		logger:   log,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Load restores tracker state and usage history from the snapshot file if one exists.
+// A missing file is not an error; a corrupted or version-mismatched snapshot is
+// moved aside and the tracker starts fresh.
+func (p *Persister) Load() error {
+	data, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		p.discard(fmt.Errorf("failed to parse state file: %w", err))
+		return nil
+	}
+
+	if err := p.tracker.Restore(saved.Snapshot); err != nil {
+		p.discard(err)
+		return nil
+	}
+	usageHours := 0
+	if saved.Usage != nil {
+		p.usage.Restore(*saved.Usage)
+		usageHours = len(saved.Usage.Hours)
+	}
+
+	p.logger.LogInfo("Restored tracker state", map[string]any{
+		"path":        p.path,
+		"saved_at":    saved.SavedAt.Format(time.RFC3339),
+		"keys":        len(saved.Quotas),
+		"usage_hours": usageHours,
+	})
+	return nil
+}
+
+// Save writes the current tracker state and usage history to disk atomically
+func (p *Persister) Save() error {
+	usageSnapshot := p.usage.Snapshot()
+	data, err := json.Marshal(state{Snapshot: p.tracker.Snapshot(), Usage: &usageSnapshot})
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := fileutil.WriteFileAtomic(p.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// Start begins saving snapshots in the background every interval
+func (p *Persister) Start() {
+	p.started = true
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.Save(); err != nil {
+					p.logger.LogError("Failed to persist tracker state", err)
+				}
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts periodic saving and writes a final snapshot
+func (p *Persister) Stop() error {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		if p.started {
+			<-p.done
+		}
+	})
+	return p.Save()
+}
+
+// discard moves an unusable snapshot aside so it can be inspected later
+func (p *Persister) discard(reason error) {
+	corruptPath := p.path + ".corrupt"
+	if err := os.Rename(p.path, corruptPath); err != nil {
+		p.logger.LogError("Failed to move aside unusable state file", err)
+	}
+	p.logger.LogError("Ignoring unusable state file, starting with empty tracker state", fmt.Errorf("%w (moved to %s)", reason, corruptPath))
+}
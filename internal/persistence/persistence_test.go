@@ -0,0 +1,127 @@
+package persistence
+
+import (
+	"encoding/json"
+	"llmgateway/internal/logger"
+	"llmgateway/internal/models"
+	"llmgateway/internal/tracker"
+	"llmgateway/internal/usage"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPersister(t *testing.T, path string) (*Persister, *tracker.Tracker) {
+	t.Helper()
+	log, err := logger.NewLogger(false, "")
+	require.NoError(t, err)
+	track := tracker.NewTracker(true, 10)
+	return NewPersister(path, time.Hour, track, usage.NewStore(24*time.Hour, usage.DefaultPricing), log), track
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	p, track := newTestPersister(t, path)
+	res, err := track.Reserve("key1")
+	require.NoError(t, err)
+	track.Settle(res, models.OutcomeSuccess)
+	track.RecordRequest(models.ProviderOpenAI, 120)
+	require.NoError(t, p.Save())
+
+	p2, track2 := newTestPersister(t, path)
+	require.NoError(t, p2.Load())
+
+	stats := track2.GetStats()
+	assert.Equal(t, int64(1), stats.TotalRequests)
+	assert.Equal(t, int64(1), stats.RequestsByProvider[models.ProviderOpenAI])
+	assert.Equal(t, 1, len(track2.Snapshot().Quotas))
+}
+
+func TestSaveAndLoadUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Now().UTC()
+
+	p, _ := newTestPersister(t, path)
+	p.usage.Record(usage.Record{Timestamp: now, VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o", DurationMs: 150, InputTokens: 1000, OutputTokens: 500})
+	require.NoError(t, p.Save())
+
+	p2, _ := newTestPersister(t, path)
+	require.NoError(t, p2.Load())
+
+	report, err := p2.usage.Query(now.Add(-time.Hour), now.Add(time.Hour), "hour", usage.Filter{})
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 1)
+	require.Len(t, report.Buckets[0].Groups, 1)
+	group := report.Buckets[0].Groups[0]
+	assert.Equal(t, models.KeyID("vk_user1_openai"), group.KeyID)
+	assert.Equal(t, int64(1), group.Requests)
+	assert.Equal(t, int64(1000), group.InputTokens)
+}
+
+func TestLoadWithoutUsage(t *testing.T) {
+	// State files written before usage history was persisted still restore the tracker
+	path := filepath.Join(t.TempDir(), "state.json")
+	_, track := newTestPersister(t, path)
+	res, err := track.Reserve("key1")
+	require.NoError(t, err)
+	track.Settle(res, models.OutcomeSuccess)
+	data, err := json.Marshal(track.Snapshot())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	p2, track2 := newTestPersister(t, path)
+	require.NoError(t, p2.Load())
+	assert.Equal(t, 1, len(track2.Snapshot().Quotas))
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	p, track := newTestPersister(t, filepath.Join(t.TempDir(), "missing.json"))
+
+	require.NoError(t, p.Load())
+	assert.Equal(t, int64(0), track.GetStats().TotalRequests)
+}
+
+func TestLoadCorruptedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":1,"quotas":`), 0644))
+
+	p, track := newTestPersister(t, path)
+	require.NoError(t, p.Load())
+	assert.Equal(t, int64(0), track.GetStats().TotalRequests)
+
+	// The unusable file is moved aside
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path + ".corrupt")
+	assert.NoError(t, err)
+}
+
+func TestLoadVersionMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":999,"stats":{"total_requests":42}}`), 0644))
+
+	p, track := newTestPersister(t, path)
+	require.NoError(t, p.Load())
+	assert.Equal(t, int64(0), track.GetStats().TotalRequests)
+
+	_, err := os.Stat(path + ".corrupt")
+	assert.NoError(t, err)
+}
+
+func TestStopWritesFinalSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	p, track := newTestPersister(t, path)
+	p.Start()
+	track.RecordRequest(models.ProviderAnthropic, 50)
+	require.NoError(t, p.Stop())
+
+	p2, track2 := newTestPersister(t, path)
+	require.NoError(t, p2.Load())
+	assert.Equal(t, int64(1), track2.GetStats().TotalRequests)
+}
@@ -0,0 +1,144 @@
+package policy
+
+import (
+	"fmt"
+	"llmgateway/internal/models"
+	"path"
+	"strings"
+)
+
+// Validate checks that a policy is well-formed before it is put into service
+func Validate(p *models.RequestPolicy) error {
+	if p == nil {
+		return nil
+	}
+	for _, pattern := range append(append([]string{}, p.AllowedModels...), p.DeniedModels...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid model pattern %q", pattern)
+		}
+	}
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	if p.MinTemperature != nil && p.MaxTemperature != nil && *p.MinTemperature > *p.MaxTemperature {
+		return fmt.Errorf("min_temperature must not exceed max_temperature")
+	}
+	return nil
+}
+
+// Check returns a descriptive error if a chat completion request violates the policy.
+// The request must already have passed proxy.ValidateRequestFormat.
+func Check(p *models.RequestPolicy, request map[string]any) error {
+	if p == nil {
+		return nil
+	}
+
+	model, _ := request["model"].(string)
+	if err := checkModel(p, model); err != nil {
+		return err
+	}
+
+	if p.MaxTokens > 0 {
+		// Every limit that is sent is checked, since providers may honour either one
+		found := false
+		for _, field := range []string{"max_tokens", "max_completion_tokens"} {
+			maxTokens, ok := firstNumber(request, field)
+			if !ok {
+				continue
+			}
+			found = true
+			if maxTokens > float64(p.MaxTokens) {
+				return fmt.Errorf("%s %v exceeds the limit of %d for this key", field, maxTokens, p.MaxTokens)
+			}
+		}
+		if !found {
+			return fmt.Errorf("max_tokens is required for this key (at most %d)", p.MaxTokens)
+		}
+	}
+
+	if temperature, ok := firstNumber(request, "temperature"); ok {
+		if p.MinTemperature != nil && temperature < *p.MinTemperature {
+			return fmt.Errorf("temperature %v is below the minimum of %v for this key", temperature, *p.MinTemperature)
+		}
+		if p.MaxTemperature != nil && temperature > *p.MaxTemperature {
+			return fmt.Errorf("temperature %v exceeds the maximum of %v for this key", temperature, *p.MaxTemperature)
+		}
+	}
+
+	if denied(p.AllowTools) && usesTools(request) {
+		return fmt.Errorf("tools are not allowed for this key")
+	}
+	if denied(p.AllowVision) && hasImageInput(request) {
+		return fmt.Errorf("image inputs are not allowed for this key")
+	}
+	if denied(p.AllowMultipleN) {
+		if n, ok := firstNumber(request, "n"); ok && n > 1 {
+			return fmt.Errorf("n > 1 is not allowed for this key")
+		}
+	}
+
+	return nil
+}
+
+// checkModel applies the denied and allowed model patterns
+func checkModel(p *models.RequestPolicy, model string) error {
+	if matchesAny(p.DeniedModels, model) {
+		return fmt.Errorf("model %q is not allowed for this key", model)
+	}
+	if len(p.AllowedModels) > 0 && !matchesAny(p.AllowedModels, model) {
+		return fmt.Errorf("model %q is not allowed for this key (allowed: %s)", model, strings.Join(p.AllowedModels, ", "))
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, model string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, model); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// denied reports whether an optional permission has been explicitly withheld
+func denied(allow *bool) bool {
+	return allow != nil && !*allow
+}
+
+// firstNumber returns the first of the given fields that holds a JSON number
+func firstNumber(request map[string]any, fields ...string) (float64, bool) {
+	for _, field := range fields {
+		if value, ok := request[field].(float64); ok {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// usesTools reports whether a request offers tools (or legacy OpenAI functions) to the model
+func usesTools(request map[string]any) bool {
+	for _, field := range []string{"tools", "functions"} {
+		if list, ok := request[field].([]any); ok && len(list) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasImageInput reports whether any message carries an image content part.
+// OpenAI uses "image_url" parts; Anthropic uses "image".
+func hasImageInput(request map[string]any) bool {
+	messages, _ := request["messages"].([]any)
+	for _, message := range messages {
+		m, _ := message.(map[string]any)
+		parts, _ := m["content"].([]any)
+		for _, part := range parts {
+			if p, ok := part.(map[string]any); ok {
+				if partType, _ := p["type"].(string); partType == "image_url" || partType == "image" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"encoding/json"
+	"llmgateway/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func float(v float64) *float64 { return &v }
+func boolean(v bool) *bool     { return &v }
+
+func parseRequest(t *testing.T, body string) map[string]any {
+	t.Helper()
+	var request map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &request))
+	return request
+}
+
+func TestCheck(t *testing.T) {
+	p := &models.RequestPolicy{
+		AllowedModels:  []string{"gpt-4o-mini*", "claude-3-haiku-*"},
+		DeniedModels:   []string{"gpt-4o-mini-realtime*"},
+		MaxTokens:      1000,
+		MinTemperature: float(0),
+		MaxTemperature: float(1),
+		AllowTools:     boolean(false),
+		AllowVision:    boolean(false),
+		AllowMultipleN: boolean(false),
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		wantError string
+	}{
+		{"allowed", `{"model": "gpt-4o-mini", "max_tokens": 500, "temperature": 0.7, "messages": []}`, ""},
+		{"allowed anthropic", `{"model": "claude-3-haiku-20240307", "max_tokens": 1000, "messages": []}`, ""},
+		{"max_completion_tokens", `{"model": "gpt-4o-mini", "max_completion_tokens": 10, "messages": []}`, ""},
+		{"model not allowed", `{"model": "gpt-4o", "max_tokens": 10, "messages": []}`, `model "gpt-4o" is not allowed for this key (allowed: gpt-4o-mini*, claude-3-haiku-*)`},
+		{"model denied", `{"model": "gpt-4o-mini-realtime-preview", "max_tokens": 10, "messages": []}`, `model "gpt-4o-mini-realtime-preview" is not allowed for this key`},
+		{"max_tokens missing", `{"model": "gpt-4o-mini", "messages": []}`, "max_tokens is required for this key (at most 1000)"},
+		{"max_tokens too high", `{"model": "gpt-4o-mini", "max_tokens": 4096, "messages": []}`, "max_tokens 4096 exceeds the limit of 1000 for this key"},
+		{"max_completion_tokens too high", `{"model": "gpt-4o-mini", "max_completion_tokens": 4096, "messages": []}`, "max_completion_tokens 4096 exceeds the limit of 1000 for this key"},
+		{"both limits within", `{"model": "gpt-4o-mini", "max_tokens": 10, "max_completion_tokens": 1000, "messages": []}`, ""},
+		{"both limits, second too high", `{"model": "gpt-4o-mini", "max_tokens": 10, "max_completion_tokens": 4096, "messages": []}`, "max_completion_tokens 4096 exceeds the limit of 1000 for this key"},
+		{"temperature too high", `{"model": "gpt-4o-mini", "max_tokens": 10, "temperature": 1.5, "messages": []}`, "temperature 1.5 exceeds the maximum of 1 for this key"},
+		{"tools", `{"model": "gpt-4o-mini", "max_tokens": 10, "tools": [{"type": "function"}], "messages": []}`, "tools are not allowed for this key"},
+		{"empty tools", `{"model": "gpt-4o-mini", "max_tokens": 10, "tools": [], "messages": []}`, ""},
+		{"openai image", `{"model": "gpt-4o-mini", "max_tokens": 10, "messages": [{"role": "user", "content": [{"type": "text", "text": "hi"}, {"type": "image_url", "image_url": {"url": "https://x"}}]}]}`, "image inputs are not allowed for this key"},
+		{"anthropic image", `{"model": "claude-3-haiku-20240307", "max_tokens": 10, "messages": [{"role": "user", "content": [{"type": "image", "source": {}}]}]}`, "image inputs are not allowed for this key"},
+		{"text parts", `{"model": "gpt-4o-mini", "max_tokens": 10, "messages": [{"role": "user", "content": [{"type": "text", "text": "hi"}]}]}`, ""},
+		{"n > 1", `{"model": "gpt-4o-mini", "max_tokens": 10, "n": 3, "messages": []}`, "n > 1 is not allowed for this key"},
+		{"n = 1", `{"model": "gpt-4o-mini", "max_tokens": 10, "n": 1, "messages": []}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(p, parseRequest(t, tt.body))
+			if tt.wantError == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantError, err.Error())
+			}
+		})
+	}
+}
+
+func TestCheckUnrestricted(t *testing.T) {
+	request := parseRequest(t, `{"model": "gpt-4o", "n": 5, "tools": [{}], "temperature": 2, "messages": []}`)
+
+	assert.NoError(t, Check(nil, request))
+	assert.NoError(t, Check(&models.RequestPolicy{}, request))
+	assert.NoError(t, Check(&models.RequestPolicy{AllowTools: boolean(true), AllowMultipleN: boolean(true)}, request))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(nil))
+	assert.NoError(t, Validate(&models.RequestPolicy{AllowedModels: []string{"gpt-*"}, MinTemperature: float(0.2), MaxTemperature: float(0.2)}))
+
+	assert.Error(t, Validate(&models.RequestPolicy{AllowedModels: []string{"gpt-["}}))
+	assert.Error(t, Validate(&models.RequestPolicy{DeniedModels: []string{"["}}))
+	assert.Error(t, Validate(&models.RequestPolicy{MaxTokens: -1}))
+	assert.Error(t, Validate(&models.RequestPolicy{MinTemperature: float(1), MaxTemperature: float(0.5)}))
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"llmgateway/internal/models"
+	"net/http"
+	"strings"
+)
+
+// DefaultHeaderAllowlist is forwarded when no allowlist is configured: content negotiation
+// and the provider headers that select API versions, betas and billing accounts
+var DefaultHeaderAllowlist = []string{
+	"Accept",
+	"Content-Type",
+	"User-Agent",
+	"X-Request-Id",
+	"OpenAI-Organization",
+	"OpenAI-Project",
+	"OpenAI-Beta",
+	"Anthropic-Version",
+	"Anthropic-Beta",
+}
+
+// DefaultHeaderDenylist is never forwarded unless a denylist is configured, even when allowed
+var DefaultHeaderDenylist = []string{"Cookie", "Forwarded", "X-Forwarded-*", "X-Real-Ip"}
+
+// credentialHeaders can carry the caller's virtual key (see middleware.AuthMiddleware)
+// and are never forwarded to a provider
+var credentialHeaders = []string{"Authorization", "X-Api-Key", "Api-Key"}
+
+// hopByHopHeaders (RFC 9110 section 7.6.1) describe a single connection and are never
+// passed on in either direction
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// strippedRequestHeaders are never forwarded, whatever the policy says. They are set by the
+// HTTP client for the upstream request; a forwarded Accept-Encoding would also turn off
+// transparent decompression and hand compressed bodies to the gateway.
+var strippedRequestHeaders = []string{"Host", "Content-Length", "Accept-Encoding"}
+
+// HeaderPolicy decides which client headers are forwarded to a provider and which
+// headers are added for it. Credential and hop-by-hop headers are always stripped.
+type HeaderPolicy struct {
+	allow []string // Lowercase names or "prefix*" patterns; nil allows every header
+	deny  []string // Same form; checked before allow
+	extra map[models.Provider]http.Header
+}
+
+// NewHeaderPolicy creates a policy. Names are case-insensitive and a trailing "*" matches
+// any suffix. An allowlist containing "*" forwards every header that is not denied.
+// extra headers are set on every request to their provider, replacing any client value.
+func NewHeaderPolicy(allow, deny []string, extra map[models.Provider]map[string]string) *HeaderPolicy {
+	p := &HeaderPolicy{
+		allow: lowerAll(allow),
+		deny:  lowerAll(deny),
+		extra: make(map[models.Provider]http.Header, len(extra)),
+	}
+	for _, pattern := range p.allow {
+		if pattern == "*" {
+			p.allow = nil
+			break
+		}
+	}
+	for provider, headers := range extra {
+		header := make(http.Header, len(headers))
+		for name, value := range headers {
+			header.Set(name, value)
+		}
+		p.extra[provider] = header
+	}
+	return p
+}
+
+// Apply copies the client headers the policy forwards into an upstream request's
+// headers and adds the provider's extra headers
+func (p *HeaderPolicy) Apply(provider models.Provider, dst, src http.Header) {
+	// Headers named in Connection are hop-by-hop too
+	var connectionTokens []string
+	for _, value := range src.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			connectionTokens = append(connectionTokens, strings.ToLower(strings.TrimSpace(token)))
+		}
+	}
+
+	for key, values := range src {
+		name := strings.ToLower(key)
+		if containsFold(credentialHeaders, name) || containsFold(hopByHopHeaders, name) || containsFold(strippedRequestHeaders, name) ||
+			matchesAny(connectionTokens, name) || matchesAny(p.deny, name) {
+			continue
+		}
+		if p.allow != nil && !matchesAny(p.allow, name) {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+
+	for key, values := range p.extra[provider] {
+		dst[key] = append([]string(nil), values...)
+	}
+}
+
+// containsFold reports whether a header name is in a list, ignoring case
+func containsFold(headers []string, name string) bool {
+	for _, header := range headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether a lowercase header name matches one of the patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerAll(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	lower := make([]string, 0, len(values))
+	for _, value := range values {
+		lower = append(lower, strings.ToLower(strings.TrimSpace(value)))
+	}
+	return lower
+}
+
+// DefaultResponseHeaderAllowlist is passed back to clients when no allowlist is configured:
+// provider request IDs, processing times and the rate-limit headers SDKs back off on
+var DefaultResponseHeaderAllowlist = []string{
+	"Request-Id",
+	"OpenAI-Processing-Ms",
+	"OpenAI-Version",
+	"X-Ratelimit-*",
+	"Anthropic-Ratelimit-*",
+	"Retry-After",
+	"Retry-After-Ms",
+}
+
+// strippedResponseHeaders are never passed back, whatever the allowlist says
+var strippedResponseHeaders = []string{
+	// The body is re-sent by the gateway, already decompressed by its HTTP client
+	"Content-Length", "Content-Encoding",
+	// Provider cookies are scoped to the provider, and X-Request-ID carries the gateway's own ID
+	"Set-Cookie", "X-Request-Id",
+}
+
+// ResponseHeaderPolicy decides which provider response headers are passed back to the client
+type ResponseHeaderPolicy struct {
+	allow []string // Lowercase names or "prefix*" patterns; nil allows every header
+}
+
+// NewResponseHeaderPolicy creates a policy with the same name patterns as NewHeaderPolicy.
+// Hop-by-hop headers, Content-Length, Content-Encoding, Set-Cookie and X-Request-ID are
+// always stripped.
+func NewResponseHeaderPolicy(allow []string) *ResponseHeaderPolicy {
+	p := &ResponseHeaderPolicy{allow: lowerAll(allow)}
+	for _, pattern := range p.allow {
+		if pattern == "*" {
+			p.allow = nil
+			break
+		}
+	}
+	return p
+}
+
+// Allows reports whether a header would be passed back
+func (p *ResponseHeaderPolicy) Allows(name string) bool {
+	name = strings.ToLower(name)
+	if containsFold(hopByHopHeaders, name) || containsFold(strippedResponseHeaders, name) {
+		return false
+	}
+	return p.allow == nil || matchesAny(p.allow, name)
+}
+
+// Filter returns the provider response headers that are passed back
+func (p *ResponseHeaderPolicy) Filter(src http.Header) http.Header {
+	filtered := make(http.Header)
+	for key, values := range src {
+		if p.Allows(key) {
+			filtered[key] = append([]string(nil), values...)
+		}
+	}
+	return filtered
+}
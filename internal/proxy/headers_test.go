@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"llmgateway/internal/models"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func forwarded(policy *HeaderPolicy, provider models.Provider, src http.Header) http.Header {
+	dst := http.Header{}
+	policy.Apply(provider, dst, src)
+	return dst
+}
+
+func TestHeaderPolicyStripsCredentials(t *testing.T) {
+	// Even a policy that forwards everything never passes on the caller's virtual key
+	policy := NewHeaderPolicy([]string{"*"}, nil, nil)
+	src := http.Header{
+		"Authorization":       {"Bearer vk_secret"},
+		"X-Api-Key":           {"vk_secret"},
+		"Api-Key":             {"vk_secret"},
+		"Proxy-Authorization": {"Basic c2VjcmV0"},
+		"x-api-key":           {"vk_noncanonical"}, // Set directly, bypassing canonicalization
+		"Content-Type":        {"application/json"},
+	}
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}}, forwarded(policy, models.ProviderOpenAI, src))
+}
+
+func TestHeaderPolicyStripsHopByHopHeaders(t *testing.T) {
+	policy := NewHeaderPolicy([]string{"*"}, nil, nil)
+	src := http.Header{
+		"Connection":        {"keep-alive, X-Client-Hop"},
+		"Keep-Alive":        {"timeout=5"},
+		"Te":                {"trailers"},
+		"Transfer-Encoding": {"chunked"},
+		"Upgrade":           {"websocket"},
+		"X-Client-Hop":      {"named in Connection"},
+		"Host":              {"gateway.internal"},
+		"Content-Length":    {"42"},
+		"Accept-Encoding":   {"br"},
+		"Accept":            {"application/json"},
+	}
+	assert.Equal(t, http.Header{"Accept": {"application/json"}}, forwarded(policy, models.ProviderOpenAI, src))
+}
+
+func TestHeaderPolicyDenylist(t *testing.T) {
+	policy := NewHeaderPolicy([]string{"*"}, DefaultHeaderDenylist, nil)
+	src := http.Header{
+		"Cookie":            {"session=abc"},
+		"X-Forwarded-For":   {"198.51.100.4"},
+		"X-Forwarded-Proto": {"https"},
+		"Forwarded":         {"for=198.51.100.4"},
+		"X-Custom":          {"kept"},
+	}
+	assert.Equal(t, http.Header{"X-Custom": {"kept"}}, forwarded(policy, models.ProviderOpenAI, src))
+
+	// Denials win over allows
+	policy = NewHeaderPolicy([]string{"Cookie", "X-Custom"}, []string{"cookie"}, nil)
+	assert.Equal(t, http.Header{"X-Custom": {"kept"}}, forwarded(policy, models.ProviderOpenAI, src))
+}
+
+func TestHeaderPolicyAllowlist(t *testing.T) {
+	policy := NewHeaderPolicy(DefaultHeaderAllowlist, DefaultHeaderDenylist, nil)
+	src := http.Header{
+		"Content-Type":        {"application/json"},
+		"Openai-Organization": {"org-123"},
+		"Anthropic-Beta":      {"tools-2024-04-04"},
+		"X-Internal-Token":    {"do-not-leak"},
+		"X-Stainless-Os":      {"Linux"},
+	}
+	assert.Equal(t, http.Header{
+		"Content-Type":        {"application/json"},
+		"Openai-Organization": {"org-123"},
+		"Anthropic-Beta":      {"tools-2024-04-04"},
+	}, forwarded(policy, models.ProviderAnthropic, src))
+
+	// A trailing wildcard matches a family of headers
+	policy = NewHeaderPolicy([]string{"X-Stainless-*"}, nil, nil)
+	assert.Equal(t, http.Header{"X-Stainless-Os": {"Linux"}}, forwarded(policy, models.ProviderOpenAI, src))
+}
+
+func TestHeaderPolicyExtraHeaders(t *testing.T) {
+	policy := NewHeaderPolicy(DefaultHeaderAllowlist, nil, map[models.Provider]map[string]string{
+		models.ProviderOpenAI:    {"OpenAI-Organization": "org-gateway"},
+		models.ProviderAnthropic: {"anthropic-beta": "prompt-caching-2024-07-31"},
+	})
+	src := http.Header{"Openai-Organization": {"org-client"}, "Accept": {"application/json"}}
+
+	// Extra headers replace the client's value and only go to their own provider
+	assert.Equal(t, http.Header{
+		"Openai-Organization": {"org-gateway"},
+		"Accept":              {"application/json"},
+	}, forwarded(policy, models.ProviderOpenAI, src))
+	assert.Equal(t, http.Header{
+		"Openai-Organization": {"org-client"},
+		"Accept":              {"application/json"},
+		"Anthropic-Beta":      {"prompt-caching-2024-07-31"},
+	}, forwarded(policy, models.ProviderAnthropic, src))
+}
+
+func TestResponseHeaderPolicy(t *testing.T) {
+	upstream := http.Header{
+		"Content-Length":                   {"512"},
+		"Content-Encoding":                 {"gzip"},
+		"Transfer-Encoding":                {"chunked"},
+		"Set-Cookie":                       {"__cf_bm=abc"},
+		"X-Request-Id":                     {"req_abc123"},
+		"Request-Id":                       {"req_011CKx"},
+		"Anthropic-Ratelimit-Tokens-Limit": {"80000"},
+		"Cf-Ray":                           {"8a1b2c3d"},
+	}
+
+	policy := NewResponseHeaderPolicy(DefaultResponseHeaderAllowlist)
+	assert.Equal(t, http.Header{
+		"Request-Id":                       {"req_011CKx"},
+		"Anthropic-Ratelimit-Tokens-Limit": {"80000"},
+	}, policy.Filter(upstream))
+
+	// "*" passes everything through except headers that are always stripped
+	policy = NewResponseHeaderPolicy([]string{"*"})
+	assert.Equal(t, http.Header{
+		"Request-Id":                       {"req_011CKx"},
+		"Anthropic-Ratelimit-Tokens-Limit": {"80000"},
+		"Cf-Ray":                           {"8a1b2c3d"},
+	}, policy.Filter(upstream))
+	assert.False(t, policy.Allows("set-cookie"))
+}
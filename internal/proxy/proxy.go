@@ -7,25 +7,42 @@ import (
 	"fmt"
 	"io"
 	"llmgateway/internal/models"
+	"llmgateway/internal/tracing"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// ProxyRequest forwards a request to the appropriate LLM provider
+// upstreamRequestIDHeaders are the headers providers use for their own request IDs
+var upstreamRequestIDHeaders = []string{"x-request-id", "request-id"}
+
+// ProxyRequest forwards a request to the appropriate LLM provider.
+// The client's headers are forwarded as headerPolicy allows; with a nil policy none are.
+// The provider's response headers are returned alongside the body.
 func ProxyRequest(
 	ctx context.Context,
 	provider models.Provider,
 	apiKey string,
 	requestBody []byte,
 	originalHeaders http.Header,
+	headerPolicy *HeaderPolicy,
 	timeout time.Duration,
-) (responseBody []byte, statusCode int, err error) {
+) (responseBody []byte, statusCode int, responseHeader http.Header, err error) {
 	// Get the provider endpoint
 	endpoint := provider.Endpoint()
 	if endpoint == "" {
-		return nil, 0, fmt.Errorf("unsupported provider: %s", provider)
+		return nil, 0, nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
 
+	// Each upstream attempt gets its own client span
+	ctx, span := tracing.Tracer().Start(ctx, "upstream "+string(provider),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.AttrGenAISystem.String(string(provider))),
+	)
+	defer span.End()
+
 	// Create HTTP client with timeout
 	client := &http.Client{
 		Timeout: timeout,
@@ -34,18 +51,12 @@ func ProxyRequest(
 	// Create the request
 	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(requestBody))
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, 0, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Copy headers from original request (except Authorization)
-	for key, values := range originalHeaders {
-		// Skip Authorization header as we'll set it with the real API key
-		if key == "Authorization" {
-			continue
-		}
-		for _, value := range values {
-			req.Header.Add(key, value)
-		}
+	// Copy the headers the policy forwards from the original request
+	if headerPolicy != nil {
+		headerPolicy.Apply(provider, req.Header, originalHeaders)
 	}
 
 	// Set the appropriate authorization header based on provider
@@ -56,25 +67,45 @@ func ProxyRequest(
 	case models.ProviderAnthropic:
 		req.Header.Set("x-api-key", apiKey)
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("anthropic-version", "2023-06-01")
+		if req.Header.Get("anthropic-version") == "" {
+			req.Header.Set("anthropic-version", "2023-06-01")
+		}
 	default:
-		return nil, 0, fmt.Errorf("unsupported provider: %s", provider)
+		return nil, 0, nil, fmt.Errorf("unsupported provider: %s", provider)
 	}
 
+	// Propagate the trace context (and the client's traceparent) to the provider
+	tracing.InjectHeaders(ctx, req.Header)
+
 	// Send the request
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to send request to %s: %w", provider, err)
+		err = fmt.Errorf("failed to send request to %s: %w", provider, err)
+		tracing.RecordError(span, err)
+		return nil, 0, nil, err
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	// Read the response body
 	responseBody, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, resp.StatusCode, fmt.Errorf("failed to read response body: %w", err)
+		err = fmt.Errorf("failed to read response body: %w", err)
+		tracing.RecordError(span, err)
+		return nil, resp.StatusCode, resp.Header, err
 	}
 
-	return responseBody, resp.StatusCode, nil
+	return responseBody, resp.StatusCode, resp.Header, nil
+}
+
+// UpstreamRequestID returns the provider's request ID from its response headers, if any
+func UpstreamRequestID(header http.Header) string {
+	for _, name := range upstreamRequestIDHeaders {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
 }
 
 // CheckProviderHealth checks if a provider's API is reachable
@@ -115,7 +146,7 @@ func CheckProviderHealth(provider models.Provider, apiKey string) (bool, error)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, statusCode, err := ProxyRequest(ctx, provider, apiKey, testBody, http.Header{}, 5*time.Second)
+	_, statusCode, _, err := ProxyRequest(ctx, provider, apiKey, testBody, http.Header{}, nil, 5*time.Second)
 	if err != nil {
 		return false, err
 	}
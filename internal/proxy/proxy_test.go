@@ -64,7 +64,7 @@ func TestProxyRequestUnsupportedProvider(t *testing.T) {
 	unsupportedProvider := models.Provider("unsupported")
 	requestBody := []byte(`{"model":"test","messages":[{"role":"user","content":"test"}]}`)
 
-	_, statusCode, err := ProxyRequest(ctx, unsupportedProvider, "test-key", requestBody, http.Header{}, 5*time.Second)
+	_, statusCode, _, err := ProxyRequest(ctx, unsupportedProvider, "test-key", requestBody, http.Header{}, nil, 5*time.Second)
 
 	require.Error(t, err)
 	assert.Equal(t, 0, statusCode)
@@ -80,3 +80,16 @@ func TestCheckProviderHealthUnsupportedProvider(t *testing.T) {
 	assert.False(t, healthy)
 	assert.Contains(t, err.Error(), "unsupported provider")
 }
+
+func TestUpstreamRequestID(t *testing.T) {
+	openai := http.Header{}
+	openai.Set("X-Request-Id", "req_abc123")
+	assert.Equal(t, "req_abc123", UpstreamRequestID(openai))
+
+	anthropic := http.Header{}
+	anthropic.Set("Request-Id", "req_011CKx")
+	assert.Equal(t, "req_011CKx", UpstreamRequestID(anthropic))
+
+	assert.Empty(t, UpstreamRequestID(http.Header{}))
+	assert.Empty(t, UpstreamRequestID(nil))
+}
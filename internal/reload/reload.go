@@ -0,0 +1,155 @@
+package reload
+
+import (
+	"llmgateway/config"
+	"llmgateway/internal/logger"
+	"os"
+	"sync"
+	"time"
+)
+
+// expiryCheckInterval is how often keys close to expiry are logged
+const expiryCheckInterval = time.Hour
+
+// Reloader swaps in a new key set whenever the keys file changes, and warns
+// about keys that are about to expire
+type Reloader struct {
+	config   *config.Config
+	interval time.Duration
+	logger   *logger.Logger
+
+	mu       sync.Mutex // Serializes reloads from polling and SIGHUP
+	lastMod  time.Time
+	lastSize int64
+
+	started  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewReloader creates a reloader checking the keys file for changes every interval.
+// An interval of zero disables polling; Reload can still be called on SIGHUP.
+func NewReloader(cfg *config.Config, interval time.Duration, log *logger.Logger) *Reloader {
+	r := &Reloader{
+		config:   cfg,
+		interval: interval,
+		logger:   log,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	// The file as loaded at startup is the baseline
+	r.lastMod, r.lastSize = r.stat()
+	return r
+}
+
+// Reload re-reads the keys file and logs what changed.
+// An invalid file is logged and the current key set stays in service.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastMod, r.lastSize = r.stat()
+	return r.reload()
+}
+
+// Start logs keys close to expiry, then keeps checking for expiring keys hourly
+// and for keys file changes every interval in the background
+func (r *Reloader) Start() {
+	r.started = true
+	r.WarnExpiring()
+
+	go func() {
+		defer close(r.done)
+
+		// A nil channel never fires, leaving only the expiry checks when polling is disabled
+		var poll <-chan time.Time
+		if r.interval > 0 {
+			pollTicker := time.NewTicker(r.interval)
+			defer pollTicker.Stop()
+			poll = pollTicker.C
+		}
+
+		expiryTicker := time.NewTicker(expiryCheckInterval)
+		defer expiryTicker.Stop()
+
+		for {
+			select {
+			case <-poll:
+				r.checkForChanges()
+			case <-expiryTicker.C:
+				r.WarnExpiring()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// WarnExpiring logs a warning for each enabled key expiring within the configured window
+func (r *Reloader) WarnExpiring() {
+	window := time.Duration(r.config.KeyExpiryWarning) * time.Hour
+	for _, key := range r.config.ExpiringKeys(window) {
+		r.logger.LogWarn("Virtual key expires soon", map[string]any{
+			"virtual_key": key.Key,
+			"name":        key.Name,
+			"expires_at":  key.ExpiresAt.Format(time.RFC3339),
+			"expires_in":  time.Until(key.ExpiresAt).Round(time.Minute).String(),
+		})
+	}
+}
+
+// Stop halts polling
+func (r *Reloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		if r.started {
+			<-r.done
+		}
+	})
+}
+
+// checkForChanges reloads the keys file if its size or modification time changed
+func (r *Reloader) checkForChanges() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, size := r.stat()
+	if modTime.Equal(r.lastMod) && size == r.lastSize {
+		return
+	}
+	r.lastMod, r.lastSize = modTime, size
+	r.reload()
+}
+
+// reload swaps in the keys file contents. Caller must hold r.mu.
+func (r *Reloader) reload() error {
+	diff, err := r.config.ReloadKeys()
+	if err != nil {
+		r.logger.LogError("Rejected keys file reload, keeping current keys", err)
+		return err
+	}
+	if diff.Empty() {
+		// Typically our own write from the admin API
+		return nil
+	}
+
+	r.logger.LogInfo("Reloaded keys file", map[string]any{
+		"path":    r.config.KeysFilePath,
+		"keys":    len(r.config.KeysConfig().VirtualKeys),
+		"added":   diff.Added,
+		"removed": diff.Removed,
+		"changed": diff.Changed,
+	})
+	r.WarnExpiring()
+	return nil
+}
+
+// stat returns the keys file's modification time and size, or zero values if it is missing
+func (r *Reloader) stat() (time.Time, int64) {
+	info, err := os.Stat(r.config.KeysFilePath)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return info.ModTime(), info.Size()
+}
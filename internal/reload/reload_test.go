@@ -0,0 +1,94 @@
+package reload
+
+import (
+	"llmgateway/config"
+	"llmgateway/internal/logger"
+	"llmgateway/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReloader(t *testing.T) (*Reloader, *config.Config) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"virtual_keys": {"vk_original": {"provider": "openai", "api_key": "sk-1"}}}`), 0644))
+
+	os.Setenv("KEYS_FILE_PATH", path)
+	t.Cleanup(func() { os.Unsetenv("KEYS_FILE_PATH") })
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	log, err := logger.NewLogger(false, "")
+	require.NoError(t, err)
+
+	return NewReloader(cfg, 10*time.Millisecond, log), cfg
+}
+
+// writeKeys replaces the keys file, bumping its mtime so the change is seen even on coarse clocks
+func writeKeys(t *testing.T, path, contents string, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestReloaderPicksUpFileChanges(t *testing.T) {
+	r, cfg := newTestReloader(t)
+	r.Start()
+	defer r.Stop()
+
+	writeKeys(t, cfg.KeysFilePath, `{"virtual_keys": {"vk_rotated": {"provider": "openai", "api_key": "sk-2"}}}`, time.Now().Add(time.Minute))
+
+	assert.Eventually(t, func() bool {
+		_, valid := cfg.ValidateVirtualKey("vk_rotated")
+		return valid
+	}, time.Second, 10*time.Millisecond)
+
+	_, valid := cfg.ValidateVirtualKey("vk_original")
+	assert.False(t, valid)
+}
+
+func TestReloaderKeepsKeysOnInvalidFile(t *testing.T) {
+	r, cfg := newTestReloader(t)
+
+	writeKeys(t, cfg.KeysFilePath, `{"virtual_keys": `, time.Now().Add(time.Minute))
+	r.checkForChanges()
+
+	_, valid := cfg.ValidateVirtualKey("vk_original")
+	assert.True(t, valid)
+
+	// An explicit reload (SIGHUP) reports the error
+	require.Error(t, r.Reload())
+
+	// Fixing the file is picked up on the next check
+	writeKeys(t, cfg.KeysFilePath, `{"virtual_keys": {"vk_fixed": {"provider": "anthropic", "api_key": "sk-3"}}}`, time.Now().Add(2*time.Minute))
+	r.checkForChanges()
+
+	_, valid = cfg.ValidateVirtualKey("vk_fixed")
+	assert.True(t, valid)
+}
+
+func TestReloaderIgnoresUnchangedFile(t *testing.T) {
+	r, cfg := newTestReloader(t)
+
+	// Swap the keys in memory; an unchanged file must not overwrite them
+	_, err := cfg.SetKeysConfig(models.KeysConfig{VirtualKeys: map[string]models.VirtualKeyConfig{
+		"vk_in_memory": {Provider: models.ProviderOpenAI, APIKey: "sk-4"},
+	}})
+	require.NoError(t, err)
+	r.checkForChanges()
+
+	_, valid := cfg.ValidateVirtualKey("vk_in_memory")
+	assert.True(t, valid)
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	r, _ := newTestReloader(t)
+	r.Stop()
+	r.Stop()
+}
@@ -0,0 +1,233 @@
+package scheduler
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"llmgateway/internal/models"
+	"sync"
+	"time"
+)
+
+// Policy selects how waiting requests are dispatched when capacity frees up
+type Policy string
+
+const (
+	// PolicyWeighted dispatches classes in proportion to their weights
+	PolicyWeighted Policy = "weighted"
+	// PolicyStrict always dispatches the highest non-empty class first
+	PolicyStrict Policy = "strict"
+)
+
+// weights controls the share of dispatches each class gets under PolicyWeighted
+var weights = map[models.Priority]int{
+	models.PriorityInteractive: 8,
+	models.PriorityDefault:     4,
+	models.PriorityBatch:       1,
+}
+
+// Scheduler enforces a global concurrency cap per provider and orders
+// waiting requests by priority class
+type Scheduler struct {
+	mu            sync.Mutex
+	maxConcurrent int // 0 means unlimited
+	policy        Policy
+	providers     map[models.Provider]*providerQueue
+}
+
+// providerQueue holds the waiting requests and counters for one provider
+type providerQueue struct {
+	active  int
+	waiting map[models.Priority]*list.List // Priority -> queue of *waiter
+	current map[models.Priority]int        // Smooth weighted round-robin state
+	stats   map[models.Priority]*classStats
+}
+
+// classStats holds the raw counters behind models.QueueStats
+type classStats struct {
+	active      int
+	dispatched  int64
+	cancelled   int64
+	totalWaitMs int64
+}
+
+// waiter is a request blocked until a slot is handed to it
+type waiter struct {
+	priority models.Priority
+	enqueued time.Time
+	ready    chan struct{}
+	granted  bool
+	elem     *list.Element
+}
+
+// NewScheduler creates a new scheduler. A maxConcurrent of 0 disables the cap.
+func NewScheduler(maxConcurrent int, policy Policy) *Scheduler {
+	if policy != PolicyStrict {
+		policy = PolicyWeighted
+	}
+	return &Scheduler{
+		maxConcurrent: maxConcurrent,
+		policy:        policy,
+		providers:     make(map[models.Provider]*providerQueue),
+	}
+}
+
+// Acquire blocks until the request may be sent to the provider, or until ctx is done.
+// The returned release function must be called once the upstream call has finished.
+func (s *Scheduler) Acquire(ctx context.Context, provider models.Provider, priority models.Priority) (func(), error) {
+	if !priority.IsValid() {
+		priority = models.PriorityDefault
+	}
+	priority = priority.OrDefault()
+
+	s.mu.Lock()
+	pq := s.queueFor(provider)
+
+	// Fast path: capacity available and nobody is waiting ahead of us
+	if s.maxConcurrent <= 0 || (pq.active < s.maxConcurrent && pq.waitingCount() == 0) {
+		pq.grant(priority, 0)
+		s.mu.Unlock()
+		return s.releaseFunc(provider, priority), nil
+	}
+
+	w := &waiter{
+		priority: priority,
+		enqueued: time.Now(),
+		ready:    make(chan struct{}),
+	}
+	w.elem = pq.waiting[priority].PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.releaseFunc(provider, priority), nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.granted {
+			// A slot was handed to us concurrently; pass it on
+			s.mu.Unlock()
+			s.releaseFunc(provider, priority)()
+		} else {
+			pq.waiting[priority].Remove(w.elem)
+			pq.stats[priority].cancelled++
+			s.mu.Unlock()
+		}
+		return nil, fmt.Errorf("request cancelled while queued for %s: %w", provider, ctx.Err())
+	}
+}
+
+// Stats returns a snapshot of per-class queue statistics for every provider
+func (s *Scheduler) Stats() map[models.Provider]map[models.Priority]models.QueueStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[models.Provider]map[models.Priority]models.QueueStats, len(s.providers))
+	for provider, pq := range s.providers {
+		classes := make(map[models.Priority]models.QueueStats, len(models.Priorities))
+		for _, priority := range models.Priorities {
+			cs := pq.stats[priority]
+			qs := models.QueueStats{
+				Queued:     pq.waiting[priority].Len(),
+				Active:     cs.active,
+				Dispatched: cs.dispatched,
+				Cancelled:  cs.cancelled,
+			}
+			if cs.dispatched > 0 {
+				qs.AverageWaitMs = float64(cs.totalWaitMs) / float64(cs.dispatched)
+			}
+			classes[priority] = qs
+		}
+		result[provider] = classes
+	}
+	return result
+}
+
+// releaseFunc returns an idempotent function that frees a slot and dispatches the next waiter
+func (s *Scheduler) releaseFunc(provider models.Provider, priority models.Priority) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			pq := s.providers[provider]
+			pq.active--
+			pq.stats[priority].active--
+			s.dispatch(pq)
+		})
+	}
+}
+
+// dispatch hands free slots to waiting requests. Caller must hold s.mu.
+func (s *Scheduler) dispatch(pq *providerQueue) {
+	for (s.maxConcurrent <= 0 || pq.active < s.maxConcurrent) && pq.waitingCount() > 0 {
+		priority := s.next(pq)
+		w := pq.waiting[priority].Remove(pq.waiting[priority].Front()).(*waiter)
+		w.granted = true
+		pq.grant(priority, time.Since(w.enqueued).Milliseconds())
+		close(w.ready)
+	}
+}
+
+// next picks the class to dispatch from. Caller must hold s.mu and ensure a waiter exists.
+func (s *Scheduler) next(pq *providerQueue) models.Priority {
+	if s.policy == PolicyStrict {
+		for _, priority := range models.Priorities {
+			if pq.waiting[priority].Len() > 0 {
+				return priority
+			}
+		}
+	}
+
+	// Smooth weighted round-robin across non-empty classes
+	var best models.Priority
+	total := 0
+	for _, priority := range models.Priorities {
+		if pq.waiting[priority].Len() == 0 {
+			continue
+		}
+		pq.current[priority] += weights[priority]
+		total += weights[priority]
+		if best == "" || pq.current[priority] > pq.current[best] {
+			best = priority
+		}
+	}
+	pq.current[best] -= total
+	return best
+}
+
+// queueFor returns the queue for a provider, creating it on first use. Caller must hold s.mu.
+func (s *Scheduler) queueFor(provider models.Provider) *providerQueue {
+	pq, exists := s.providers[provider]
+	if !exists {
+		pq = &providerQueue{
+			waiting: make(map[models.Priority]*list.List, len(models.Priorities)),
+			current: make(map[models.Priority]int, len(models.Priorities)),
+			stats:   make(map[models.Priority]*classStats, len(models.Priorities)),
+		}
+		for _, priority := range models.Priorities {
+			pq.waiting[priority] = list.New()
+			pq.stats[priority] = &classStats{}
+		}
+		s.providers[provider] = pq
+	}
+	return pq
+}
+
+// grant marks a slot as taken by the given class
+func (pq *providerQueue) grant(priority models.Priority, waitMs int64) {
+	pq.active++
+	cs := pq.stats[priority]
+	cs.active++
+	cs.dispatched++
+	cs.totalWaitMs += waitMs
+}
+
+// waitingCount returns the number of requests waiting across all classes
+func (pq *providerQueue) waitingCount() int {
+	count := 0
+	for _, queue := range pq.waiting {
+		count += queue.Len()
+	}
+	return count
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"context"
+	"llmgateway/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enqueue starts a goroutine that acquires a slot and reports its priority once granted
+func enqueue(t *testing.T, s *Scheduler, priority models.Priority, order chan<- models.Priority) {
+	t.Helper()
+	go func() {
+		release, err := s.Acquire(context.Background(), models.ProviderOpenAI, priority)
+		if err != nil {
+			return
+		}
+		order <- priority
+		release()
+	}()
+}
+
+// waitQueued blocks until the given number of requests are queued for the provider
+func waitQueued(t *testing.T, s *Scheduler, count int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		queued := 0
+		for _, qs := range s.Stats()[models.ProviderOpenAI] {
+			queued += qs.Queued
+		}
+		return queued == count
+	}, time.Second, time.Millisecond)
+}
+
+func TestAcquireUnlimited(t *testing.T) {
+	s := NewScheduler(0, PolicyWeighted)
+
+	for i := 0; i < 100; i++ {
+		_, err := s.Acquire(context.Background(), models.ProviderOpenAI, models.PriorityBatch)
+		require.NoError(t, err)
+	}
+
+	stats := s.Stats()[models.ProviderOpenAI][models.PriorityBatch]
+	assert.Equal(t, 100, stats.Active)
+	assert.Equal(t, int64(100), stats.Dispatched)
+}
+
+func TestAcquireEnforcesCapPerProvider(t *testing.T) {
+	s := NewScheduler(1, PolicyStrict)
+
+	release, err := s.Acquire(context.Background(), models.ProviderOpenAI, "")
+	require.NoError(t, err)
+
+	// A different provider has its own cap
+	_, err = s.Acquire(context.Background(), models.ProviderAnthropic, "")
+	require.NoError(t, err)
+
+	// The same provider is saturated
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = s.Acquire(ctx, models.ProviderOpenAI, "")
+	require.Error(t, err)
+
+	stats := s.Stats()[models.ProviderOpenAI][models.PriorityDefault]
+	assert.Equal(t, int64(1), stats.Cancelled)
+	assert.Equal(t, 0, stats.Queued)
+
+	release()
+	_, err = s.Acquire(context.Background(), models.ProviderOpenAI, "")
+	require.NoError(t, err)
+}
+
+func TestStrictPolicyOrder(t *testing.T) {
+	s := NewScheduler(1, PolicyStrict)
+	order := make(chan models.Priority, 3)
+
+	release, err := s.Acquire(context.Background(), models.ProviderOpenAI, models.PriorityDefault)
+	require.NoError(t, err)
+
+	enqueue(t, s, models.PriorityBatch, order)
+	waitQueued(t, s, 1)
+	enqueue(t, s, models.PriorityDefault, order)
+	waitQueued(t, s, 2)
+	enqueue(t, s, models.PriorityInteractive, order)
+	waitQueued(t, s, 3)
+
+	release()
+
+	assert.Equal(t, models.PriorityInteractive, <-order)
+	assert.Equal(t, models.PriorityDefault, <-order)
+	assert.Equal(t, models.PriorityBatch, <-order)
+}
+
+func TestWeightedPolicyDoesNotStarveBatch(t *testing.T) {
+	s := NewScheduler(1, PolicyWeighted)
+	order := make(chan models.Priority, 20)
+
+	release, err := s.Acquire(context.Background(), models.ProviderOpenAI, models.PriorityDefault)
+	require.NoError(t, err)
+
+	queued := 0
+	for i := 0; i < 10; i++ {
+		enqueue(t, s, models.PriorityInteractive, order)
+		queued++
+		waitQueued(t, s, queued)
+	}
+	enqueue(t, s, models.PriorityBatch, order)
+	queued++
+	waitQueued(t, s, queued)
+
+	release()
+
+	// With weights 8:1 the batch request is served within the first nine dispatches
+	var served []models.Priority
+	for i := 0; i < 9; i++ {
+		served = append(served, <-order)
+	}
+	assert.Contains(t, served, models.PriorityBatch)
+	assert.Equal(t, models.PriorityInteractive, served[0])
+}
+
+func TestReleaseIsIdempotent(t *testing.T) {
+	s := NewScheduler(1, PolicyStrict)
+
+	release, err := s.Acquire(context.Background(), models.ProviderOpenAI, "")
+	require.NoError(t, err)
+	release()
+	release()
+
+	stats := s.Stats()[models.ProviderOpenAI][models.PriorityDefault]
+	assert.Equal(t, 0, stats.Active)
+}
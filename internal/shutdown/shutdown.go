@@ -0,0 +1,62 @@
+package shutdown
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Readiness reports whether the gateway should be sent new traffic. It serves /ready:
+// 200 while serving and 503 once draining has begun.
+type Readiness struct {
+	draining atomic.Bool
+}
+
+// SetDraining marks the gateway as shutting down
+func (r *Readiness) SetDraining() {
+	r.draining.Store(true)
+}
+
+// Draining reports whether shutdown has begun
+func (r *Readiness) Draining() bool {
+	return r.draining.Load()
+}
+
+// ServeHTTP handles the /ready endpoint
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	status, body := http.StatusOK, "ready"
+	if r.Draining() {
+		status, body = http.StatusServiceUnavailable, "draining"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"status": body})
+}
+
+// Options configures Drain
+type Options struct {
+	Delay   time.Duration // How long readiness fails before the listeners close
+	Timeout time.Duration // How long in-flight requests get to finish after that
+}
+
+// Drain shuts a server down without dropping in-flight requests, which may already have
+// cost money upstream. It fails readiness and turns off keep-alives, keeps serving for
+// Delay while load balancers notice, then closes the listeners and waits up to Timeout
+// for active requests, streamed responses included, to finish. Connections still active
+// after Timeout are closed and an error is returned.
+func Drain(server *http.Server, ready *Readiness, opts Options) error {
+	ready.SetDraining()
+	server.SetKeepAlivesEnabled(false)
+	time.Sleep(opts.Delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("requests still in flight after %s were dropped: %w", opts.Timeout, err)
+	}
+	return nil
+}
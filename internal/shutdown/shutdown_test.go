@@ -0,0 +1,171 @@
+package shutdown
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves handler on a loopback port and returns the server and its base URL
+func startServer(t *testing.T, handler http.Handler) (*http.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return server, "http://" + listener.Addr().String()
+}
+
+// waitUntilRefused waits until the server has closed its listener
+func waitUntilRefused(t *testing.T, url string) {
+	require.Eventually(t, func() bool {
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(url, "http://"), 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+// drainAsync runs Drain in the background and returns its result channel
+func drainAsync(server *http.Server, ready *Readiness, opts Options) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- Drain(server, ready, opts) }()
+	return result
+}
+
+func TestReadiness(t *testing.T) {
+	ready := &Readiness{}
+
+	rec := httptest.NewRecorder()
+	ready.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ready"}`, rec.Body.String())
+
+	ready.SetDraining()
+	rec = httptest.NewRecorder()
+	ready.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"status":"draining"}`, rec.Body.String())
+}
+
+func TestDrainCompletesInFlightRequest(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server, url := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release // Stands in for a slow completion
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	response := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		response <- result{status: resp.StatusCode, body: string(body), err: err}
+	}()
+	<-started
+
+	ready := &Readiness{}
+	drained := drainAsync(server, ready, Options{Timeout: 5 * time.Second})
+
+	// New connections are refused while the request is still running
+	waitUntilRefused(t, url)
+	assert.True(t, ready.Draining())
+	select {
+	case err := <-drained:
+		t.Fatalf("drain returned before the in-flight request finished: %v", err)
+	default:
+	}
+
+	close(release)
+	got := <-response
+	require.NoError(t, got.err)
+	assert.Equal(t, http.StatusOK, got.status)
+	assert.Equal(t, `{"choices":[]}`, got.body)
+	assert.NoError(t, <-drained)
+}
+
+func TestDrainCompletesStreamingResponse(t *testing.T) {
+	firstChunk, release := make(chan struct{}), make(chan struct{})
+	server, url := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		close(firstChunk)
+		<-release
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	<-firstChunk
+
+	drained := drainAsync(server, &Readiness{}, Options{Timeout: 5 * time.Second})
+	waitUntilRefused(t, url)
+	close(release)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "data: first\n\ndata: [DONE]\n\n", string(body))
+	assert.NoError(t, <-drained)
+}
+
+func TestDrainKeepsServingDuringDelay(t *testing.T) {
+	server, url := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	ready := &Readiness{}
+	drained := drainAsync(server, ready, Options{Delay: 300 * time.Millisecond, Timeout: time.Second})
+	require.Eventually(t, ready.Draining, time.Second, time.Millisecond)
+
+	// Load balancers that have not yet noticed the failed readiness are still served
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, resp.Close, "keep-alives are off while draining")
+
+	assert.NoError(t, <-drained)
+}
+
+func TestDrainClosesRequestsPastTimeout(t *testing.T) {
+	started := make(chan struct{})
+	server, url := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+
+	requestErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		requestErr <- err
+	}()
+	<-started
+
+	err := Drain(server, &Readiness{}, Options{Timeout: 100 * time.Millisecond})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Error(t, <-requestErr, "the stuck request's connection is closed")
+}
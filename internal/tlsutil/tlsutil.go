@@ -0,0 +1,200 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"llmgateway/internal/logger"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client certificate modes
+const (
+	ClientAuthNone     = "none"     // Client certificates are not requested
+	ClientAuthOptional = "optional" // Verified against the CA bundle if presented
+	ClientAuthRequire  = "require"  // Handshakes without a valid client certificate fail
+)
+
+// Options configures a CertReloader
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // PEM bundle verifying client certificates ("" disables client auth)
+	ClientAuth   string // ClientAuthNone, ClientAuthOptional or ClientAuthRequire
+}
+
+// credentials is a loaded certificate and client CA pool, swapped as a whole
+type credentials struct {
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+}
+
+// CertReloader serves the certificate and client CA bundle currently on disk,
+// reloading them when the files change. A failed reload keeps the previous files in service.
+type CertReloader struct {
+	opts   Options
+	logger *logger.Logger
+	creds  atomic.Pointer[credentials]
+
+	mu       sync.Mutex // Serializes reloads
+	lastMods map[string]time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+	started  bool
+}
+
+// NewCertReloader loads the certificate, key and client CA bundle, failing if any is invalid
+func NewCertReloader(opts Options, log *logger.Logger) (*CertReloader, error) {
+	switch opts.ClientAuth {
+	case ClientAuthNone, ClientAuthOptional, ClientAuthRequire:
+	default:
+		return nil, fmt.Errorf("invalid client auth mode %q", opts.ClientAuth)
+	}
+	if opts.ClientAuth == ClientAuthRequire && opts.ClientCAFile == "" {
+		return nil, fmt.Errorf("client auth %q needs a client CA bundle", ClientAuthRequire)
+	}
+
+	r := &CertReloader{
+		opts:   opts,
+		logger: log,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	r.lastMods = r.stat()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a server configuration that picks up the latest certificate and
+// client CA bundle on every handshake
+func (r *CertReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.creds.Load().cert, nil
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		creds := r.creds.Load()
+		config := &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*creds.cert},
+			// The per-handshake config replaces the one net/http sets up, so offer HTTP/2 here too
+			NextProtos: []string{"h2", "http/1.1"},
+		}
+		if creds.clientCA != nil && r.opts.ClientAuth != ClientAuthNone {
+			config.ClientCAs = creds.clientCA
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+			if r.opts.ClientAuth == ClientAuthRequire {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+		return config, nil
+	}
+	return base
+}
+
+// Start checks the files for changes every interval in the background
+func (r *CertReloader) Start(interval time.Duration) {
+	r.started = true
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.checkForChanges()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts polling
+func (r *CertReloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		if r.started {
+			<-r.done
+		}
+	})
+}
+
+// Reload re-reads the certificate files unconditionally
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastMods = r.stat()
+	return r.load()
+}
+
+// checkForChanges reloads the files if any modification time changed
+func (r *CertReloader) checkForChanges() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mods := r.stat()
+	changed := false
+	for path, modTime := range mods {
+		if !modTime.Equal(r.lastMods[path]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	r.lastMods = mods
+
+	if err := r.load(); err != nil {
+		r.logger.LogError("Rejected TLS certificate reload, keeping current certificate", err)
+		return
+	}
+	r.logger.LogInfo("Reloaded TLS certificate", map[string]any{"cert_file": r.opts.CertFile})
+}
+
+// load reads the files and puts them into service
+func (r *CertReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	creds := &credentials{cert: &cert}
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		creds.clientCA = x509.NewCertPool()
+		if !creds.clientCA.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("client CA bundle %s contains no certificates", r.opts.ClientCAFile)
+		}
+	}
+
+	r.creds.Store(creds)
+	return nil
+}
+
+// stat returns the modification time of each file, zero if it is missing
+func (r *CertReloader) stat() map[string]time.Time {
+	mods := make(map[string]time.Time, 3)
+	for _, path := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			mods[path] = info.ModTime()
+		} else {
+			mods[path] = time.Time{}
+		}
+	}
+	return mods
+}
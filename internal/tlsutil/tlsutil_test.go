@@ -0,0 +1,203 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"llmgateway/internal/logger"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM encoded certificate and key for a leaf certificate
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeServerFiles writes a server certificate, key and client CA bundle
+func writeServerFiles(t *testing.T, dir string, ca *testCA, commonName string) Options {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, commonName, x509.ExtKeyUsageServerAuth)
+	opts := Options{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+		ClientAuth:   ClientAuthRequire,
+	}
+	require.NoError(t, os.WriteFile(opts.CertFile, certPEM, 0600))
+	require.NoError(t, os.WriteFile(opts.KeyFile, keyPEM, 0600))
+	require.NoError(t, os.WriteFile(opts.ClientCAFile, ca.pem, 0600))
+	return opts
+}
+
+// serve runs an HTTPS server echoing the client certificate's common name
+func serve(t *testing.T, reloader *CertReloader) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig())
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) > 0 {
+			io.WriteString(w, r.TLS.VerifiedChains[0][0].Subject.CommonName)
+		}
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "https://" + listener.Addr().String()
+}
+
+func client(ca *testCA, cert *tls.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: pool}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}, Timeout: 5 * time.Second}
+}
+
+func newTestLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	log, err := logger.NewLogger(false, "")
+	require.NoError(t, err)
+	return log
+}
+
+func TestRequireClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	reloader, err := NewCertReloader(writeServerFiles(t, t.TempDir(), ca, "gateway"), newTestLogger(t))
+	require.NoError(t, err)
+	url := serve(t, reloader)
+
+	_, err = client(ca, nil).Get(url)
+	assert.Error(t, err, "handshakes without a client certificate must fail")
+
+	certPEM, keyPEM := ca.issue(t, "svc-search", x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	resp, err := client(ca, &clientCert).Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "svc-search", string(body))
+
+	// Certificates from another CA are refused
+	otherPEM, otherKey := newTestCA(t).issue(t, "intruder", x509.ExtKeyUsageClientAuth)
+	otherCert, err := tls.X509KeyPair(otherPEM, otherKey)
+	require.NoError(t, err)
+	_, err = client(ca, &otherCert).Get(url)
+	assert.Error(t, err)
+}
+
+func TestCertificateReloadOnChange(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	opts := writeServerFiles(t, dir, ca, "gateway-old")
+	opts.ClientAuth = ClientAuthOptional
+	reloader, err := NewCertReloader(opts, newTestLogger(t))
+	require.NoError(t, err)
+	url := serve(t, reloader)
+
+	servedName := func() string {
+		resp, err := client(ca, nil).Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "gateway-old", servedName())
+
+	// A broken certificate file is rejected and the current certificate stays in service
+	require.NoError(t, os.WriteFile(opts.CertFile, []byte("garbage"), 0600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(opts.CertFile, future, future))
+	reloader.checkForChanges()
+	assert.Equal(t, "gateway-old", servedName())
+
+	writeServerFiles(t, dir, ca, "gateway-new")
+	later := future.Add(time.Minute)
+	for _, path := range []string{opts.CertFile, opts.KeyFile} {
+		require.NoError(t, os.Chtimes(path, later, later))
+	}
+	reloader.checkForChanges()
+	assert.Equal(t, "gateway-new", servedName())
+}
+
+func TestNewCertReloaderValidation(t *testing.T) {
+	ca := newTestCA(t)
+	opts := writeServerFiles(t, t.TempDir(), ca, "gateway")
+
+	noCA := opts
+	noCA.ClientCAFile = ""
+	_, err := NewCertReloader(noCA, newTestLogger(t))
+	assert.Error(t, err, "require needs a CA bundle")
+
+	badMode := opts
+	badMode.ClientAuth = "sometimes"
+	_, err = NewCertReloader(badMode, newTestLogger(t))
+	assert.Error(t, err)
+
+	missing := opts
+	missing.CertFile = filepath.Join(t.TempDir(), "missing.crt")
+	_, err = NewCertReloader(missing, newTestLogger(t))
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"llmgateway/internal/models"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the gateway's tracer
+const instrumentationName = "llmgateway"
+
+// Gen-AI semantic convention attribute keys
+const (
+	AttrGenAISystem        = attribute.Key("gen_ai.system")
+	AttrGenAIRequestModel  = attribute.Key("gen_ai.request.model")
+	AttrGenAIInputTokens   = attribute.Key("gen_ai.usage.input_tokens")
+	AttrGenAIOutputTokens  = attribute.Key("gen_ai.usage.output_tokens")
+	AttrGenAIFinishReasons = attribute.Key("gen_ai.response.finish_reasons")
+)
+
+func init() {
+	// W3C trace context is propagated even when no exporter is configured,
+	// so a client's traceparent still reaches the provider. Baggage is deliberately
+	// not propagated: injecting it would forward the client's baggage header
+	// upstream regardless of the header deny list.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// Setup installs a global tracer provider exporting spans over OTLP/HTTP.
+// The exporter is configured through the standard OTEL_EXPORTER_OTLP_* environment variables.
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	return Install(sdktrace.WithBatcher(exporter), serviceName), nil
+}
+
+// Install sets a global tracer provider with the given span processor option.
+// Tests use it with an in-memory exporter.
+func Install(processor sdktrace.TracerProviderOption, serviceName string) func(context.Context) error {
+	provider := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// Tracer returns the gateway's tracer from the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts an internal span as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Middleware starts a server span for each request, continuing the trace from the
+// client's traceparent header if present
+func Middleware(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := Tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// InjectHeaders writes the trace context from ctx into outgoing request headers
+func InjectHeaders(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// RecordError marks a span as failed
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// FinishReasons extracts the finish reasons from a provider response body
+func FinishReasons(provider models.Provider, response map[string]any) []string {
+	var reasons []string
+	switch provider {
+	case models.ProviderOpenAI:
+		choices, _ := response["choices"].([]any)
+		for _, choice := range choices {
+			if c, ok := choice.(map[string]any); ok {
+				if reason, ok := c["finish_reason"].(string); ok {
+					reasons = append(reasons, reason)
+				}
+			}
+		}
+	case models.ProviderAnthropic:
+		if reason, ok := response["stop_reason"].(string); ok {
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"llmgateway/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// installInMemory routes spans to an in-memory exporter for the duration of the test
+func installInMemory(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := Install(sdktrace.WithSyncer(exporter), "test")
+	t.Cleanup(func() { shutdown(context.Background()) })
+	return exporter
+}
+
+func TestMiddlewareContinuesClientTrace(t *testing.T) {
+	exporter := installInMemory(t)
+
+	var outgoing http.Header
+	handler := Middleware("POST /chat/completions", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, child := Start(r.Context(), "gateway.validate")
+		child.End()
+
+		outgoing = http.Header{}
+		InjectHeaders(r.Context(), outgoing)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("baggage", "user.id=alice")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "gateway.validate", child.Name)
+	assert.Equal(t, "POST /chat/completions", server.Name)
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Equal(t, server.SpanContext.SpanID(), child.Parent.SpanID())
+	assert.Equal(t, "Error", server.Status.Code.String())
+
+	// The provider call continues the same trace
+	assert.Contains(t, outgoing.Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Empty(t, outgoing.Get("baggage"), "client baggage is left to the header policy")
+}
+
+func TestMiddlewareStartsNewTrace(t *testing.T) {
+	exporter := installInMemory(t)
+
+	handler := Middleware("POST /chat/completions", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/chat/completions", nil))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.False(t, spans[0].Parent.IsValid())
+	assert.Equal(t, "Unset", spans[0].Status.Code.String())
+}
+
+func TestFinishReasons(t *testing.T) {
+	openai := map[string]any{"choices": []any{
+		map[string]any{"finish_reason": "stop"},
+		map[string]any{"finish_reason": "length"},
+	}}
+	assert.Equal(t, []string{"stop", "length"}, FinishReasons(models.ProviderOpenAI, openai))
+
+	anthropic := map[string]any{"stop_reason": "end_turn"}
+	assert.Equal(t, []string{"end_turn"}, FinishReasons(models.ProviderAnthropic, anthropic))
+
+	assert.Empty(t, FinishReasons(models.ProviderOpenAI, nil))
+}
@@ -0,0 +1,158 @@
+package tracker
+
+import (
+	"fmt"
+	"llmgateway/internal/models"
+	"time"
+)
+
+// Group kinds
+const (
+	GroupOrganization = "org"
+	GroupTeam         = "team"
+)
+
+// budgetMonthFormat names the calendar month (UTC) a budget's spend belongs to
+const budgetMonthFormat = "2006-01"
+
+// Group is an organization or team whose keys share a quota and a budget
+type Group struct {
+	Kind       string // GroupOrganization or GroupTeam
+	ID         string
+	QuotaLimit int64   // Requests per hour across the group's keys; 0 for no limit
+	BudgetUSD  float64 // Estimated spend per calendar month (UTC); 0 for no limit
+}
+
+// GroupsFor returns the team a key belongs to and that team's organization,
+// with the limits configured for each
+func GroupsFor(keysConfig models.KeysConfig, keyConfig models.VirtualKeyConfig) []Group {
+	teamConfig, orgConfig := keysConfig.Lineage(keyConfig.Team)
+	if teamConfig == nil {
+		return nil
+	}
+
+	groups := []Group{{
+		Kind:       GroupTeam,
+		ID:         keyConfig.Team,
+		QuotaLimit: teamConfig.QuotaLimit,
+		BudgetUSD:  teamConfig.BudgetUSD,
+	}}
+	if orgConfig != nil {
+		groups = append(groups, Group{
+			Kind:       GroupOrganization,
+			ID:         teamConfig.Organization,
+			QuotaLimit: orgConfig.QuotaLimit,
+			BudgetUSD:  orgConfig.BudgetUSD,
+		})
+	}
+	return groups
+}
+
+// key identifies the group in the tracker state and the shared quota store
+func (g Group) key() string {
+	return g.Kind + ":" + g.ID
+}
+
+// GroupState is the quota, spend and usage tracked for one group
+type GroupState struct {
+	Kind          string           `json:"kind"`
+	ID            string           `json:"id"`
+	Quota         models.QuotaInfo `json:"quota"`
+	Month         string           `json:"month"` // Month MonthSpendUSD belongs to, as YYYY-MM
+	MonthSpendUSD float64          `json:"month_spend_usd"`
+	BudgetUSD     float64          `json:"budget_usd"` // Last configured budget, for reporting
+	Requests      int64            `json:"requests"`
+	CostUSD       float64          `json:"cost_usd"`
+}
+
+// RecordGroupUsage charges a request that reached the provider to the usage and
+// monthly spend of each group
+func (t *Tracker) RecordGroupUsage(groups []Group, costUSD float64) {
+	if len(groups) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, g := range groups {
+		state := t.currentGroup(g)
+		state.Requests++
+		state.CostUSD += costUSD
+		state.MonthSpendUSD += costUSD
+	}
+}
+
+// checkBudgets returns an error if any group has spent its monthly budget. Caller must hold t.mu.
+func (t *Tracker) checkBudgets(groups []Group) error {
+	for _, g := range groups {
+		if g.BudgetUSD <= 0 {
+			continue
+		}
+		state := t.currentGroup(g)
+		if state.MonthSpendUSD >= g.BudgetUSD {
+			return fmt.Errorf("%w for %s %s: $%.2f of $%.2f monthly budget spent", ErrBudgetExceeded, g.Kind, g.ID, state.MonthSpendUSD, g.BudgetUSD)
+		}
+	}
+	return nil
+}
+
+// currentGroup returns the state of a group, rolling its quota window and budget month
+// over as needed and recording its configured limits. Caller must hold t.mu.
+func (t *Tracker) currentGroup(g Group) *GroupState {
+	now := time.Now()
+	month := now.UTC().Format(budgetMonthFormat)
+
+	state, exists := t.groups[g.key()]
+	if !exists {
+		state = &GroupState{
+			Kind:  g.Kind,
+			ID:    g.ID,
+			Quota: models.QuotaInfo{WindowStart: now.Truncate(QuotaWindow)},
+			Month: month,
+		}
+		t.groups[g.key()] = state
+	}
+
+	if !now.Before(state.Quota.WindowStart.Add(QuotaWindow)) {
+		state.Quota.RequestCount = 0
+		state.Quota.WindowStart = now.Truncate(QuotaWindow)
+	}
+	if state.Month != month {
+		state.Month = month
+		state.MonthSpendUSD = 0
+	}
+	state.Quota.MaxRequests = g.QuotaLimit
+	state.BudgetUSD = g.BudgetUSD
+	return state
+}
+
+// groupStats rolls group states up into per-team and per-organization usage. Caller must hold t.mu.
+func (t *Tracker) groupStats() (teams, orgs map[string]models.GroupUsage) {
+	month := time.Now().UTC().Format(budgetMonthFormat)
+	for _, state := range t.groups {
+		usage := models.GroupUsage{
+			Requests:   state.Requests,
+			CostUSD:    state.CostUSD,
+			BudgetUSD:  state.BudgetUSD,
+			QuotaLimit: state.Quota.MaxRequests,
+		}
+		if state.Month == month {
+			usage.MonthSpendUSD = state.MonthSpendUSD
+		}
+
+		switch state.Kind {
+		case GroupTeam:
+			if teams == nil {
+				teams = make(map[string]models.GroupUsage)
+			}
+			teams[state.ID] = usage
+		case GroupOrganization:
+			if orgs == nil {
+				orgs = make(map[string]models.GroupUsage)
+			}
+			orgs[state.ID] = usage
+		}
+	}
+	return teams, orgs
+}
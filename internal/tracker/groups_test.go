@@ -0,0 +1,112 @@
+package tracker
+
+import (
+	"llmgateway/internal/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupsFor(t *testing.T) {
+	keysConfig := models.KeysConfig{
+		Organizations: map[string]models.GroupConfig{"acme": {QuotaLimit: 100, BudgetUSD: 500}},
+		Teams: map[string]models.TeamConfig{
+			"search":   {Organization: "acme", GroupConfig: models.GroupConfig{QuotaLimit: 10}},
+			"orphaned": {},
+		},
+	}
+
+	assert.Equal(t, []Group{
+		{Kind: GroupTeam, ID: "search", QuotaLimit: 10},
+		{Kind: GroupOrganization, ID: "acme", QuotaLimit: 100, BudgetUSD: 500},
+	}, GroupsFor(keysConfig, models.VirtualKeyConfig{Team: "search"}))
+	assert.Equal(t, []Group{{Kind: GroupTeam, ID: "orphaned"}}, GroupsFor(keysConfig, models.VirtualKeyConfig{Team: "orphaned"}))
+	assert.Nil(t, GroupsFor(keysConfig, models.VirtualKeyConfig{}))
+}
+
+func TestReserveEnforcesGroupQuota(t *testing.T) {
+	tracker := NewTracker(true, 10)
+	team := Group{Kind: GroupTeam, ID: "search", QuotaLimit: 2}
+
+	// Two keys share the team's limit
+	res1, err := tracker.Reserve("vk_a", team)
+	require.NoError(t, err)
+	res2, err := tracker.Reserve("vk_b", team)
+	require.NoError(t, err)
+
+	_, err = tracker.Reserve("vk_c", team)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Contains(t, err.Error(), "team search")
+
+	// A refused group reservation must not hold a slot on the key
+	_, err = tracker.Reserve("vk_c")
+	require.NoError(t, err)
+
+	tracker.Settle(res1, models.OutcomeValidationError)
+	tracker.Settle(res2, models.OutcomeSuccess)
+	_, err = tracker.Reserve("vk_c", team)
+	require.NoError(t, err, "uncharged outcomes should give the team's slot back")
+}
+
+func TestReservationStatusReportsTightestQuota(t *testing.T) {
+	tracker := NewTracker(true, 10)
+	team := Group{Kind: GroupTeam, ID: "search", QuotaLimit: 5}
+	org := Group{Kind: GroupOrganization, ID: "acme", QuotaLimit: 3}
+
+	require.NoError(t, charge(tracker, "vk_other", org))
+	res, err := tracker.Reserve("vk_a", team, org)
+	require.NoError(t, err)
+	status, _ := res.Status()
+	assert.Equal(t, int64(3), status.Limit, "the organization has the fewest requests left")
+	assert.Equal(t, int64(1), status.Remaining)
+
+	// Groups without a quota or with more room leave the key's own quota in place
+	res, err = tracker.Reserve("vk_b", Group{Kind: GroupTeam, ID: "open"}, Group{Kind: GroupOrganization, ID: "big", QuotaLimit: 100})
+	require.NoError(t, err)
+	status, _ = res.Status()
+	assert.Equal(t, int64(10), status.Limit)
+	assert.Equal(t, int64(9), status.Remaining)
+}
+
+func TestReserveEnforcesBudgetAtEveryLevel(t *testing.T) {
+	tracker := NewTracker(true, 100)
+	team := Group{Kind: GroupTeam, ID: "search", BudgetUSD: 10}
+	org := Group{Kind: GroupOrganization, ID: "acme", BudgetUSD: 5}
+	groups := []Group{team, org}
+
+	tracker.RecordGroupUsage(groups, 4)
+	_, err := tracker.Reserve("vk_a", groups...)
+	require.NoError(t, err)
+
+	// The organization's budget runs out before the team's
+	tracker.RecordGroupUsage(groups, 1.5)
+	_, err = tracker.Reserve("vk_a", groups...)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.Contains(t, err.Error(), "budget exceeded for org acme")
+
+	// Other teams in the organization are refused too
+	_, err = tracker.Reserve("vk_b", Group{Kind: GroupTeam, ID: "ads"}, org)
+	require.Error(t, err)
+}
+
+func TestGroupStatsAndSnapshot(t *testing.T) {
+	tracker := NewTracker(true, 100)
+	groups := []Group{
+		{Kind: GroupTeam, ID: "search", QuotaLimit: 50},
+		{Kind: GroupOrganization, ID: "acme", BudgetUSD: 100},
+	}
+	tracker.RecordGroupUsage(groups, 0.25)
+	tracker.RecordGroupUsage(groups, 0.5)
+
+	stats := tracker.GetStats()
+	assert.Equal(t, models.GroupUsage{Requests: 2, CostUSD: 0.75, MonthSpendUSD: 0.75, QuotaLimit: 50}, stats.Teams["search"])
+	assert.Equal(t, models.GroupUsage{Requests: 2, CostUSD: 0.75, MonthSpendUSD: 0.75, BudgetUSD: 100}, stats.Organizations["acme"])
+
+	restored := NewTracker(true, 100)
+	require.NoError(t, restored.Restore(tracker.Snapshot()))
+	assert.Equal(t, stats.Teams, restored.GetStats().Teams)
+	assert.Equal(t, stats.Organizations, restored.GetStats().Organizations)
+}
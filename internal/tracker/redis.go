@@ -0,0 +1,343 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QuotaStore is a shared quota backend used instead of the in-memory counters,
+// so that several gateway replicas enforce a single limit per virtual key
+type QuotaStore interface {
+	// Reserve counts a request against the key's current window and returns the slot
+	// it took, or allowed=false if the limit is reached
+	Reserve(ctx context.Context, virtualKey string, limit int64) (slot Slot, allowed bool, err error)
+	// Release gives back a slot previously taken by Reserve
+	Release(ctx context.Context, token string) error
+}
+
+// Slot is a request counted by a QuotaStore
+type Slot struct {
+	Token   string    // Identifies the counter for Release
+	Used    int64     // Requests counted in the window, including this one
+	ResetAt time.Time // When the window ends
+}
+
+// ErrStoreUnavailable is returned while the store is backing off after a connection failure
+var ErrStoreUnavailable = errors.New("quota store unavailable")
+
+// redisRetryInterval is how long the store stays in fallback after a connection failure
+const redisRetryInterval = time.Second
+
+// RedisOptions configures a RedisStore
+type RedisOptions struct {
+	Addr      string
+	Password  string
+	DB        int
+	KeyPrefix string
+	Timeout   time.Duration
+	Window    time.Duration
+	PoolSize  int
+}
+
+// RedisStore implements QuotaStore over the Redis protocol using fixed windows:
+// each key/window pair is a counter taken by reserveScript and given back by releaseScript
+type RedisStore struct {
+	opts RedisOptions
+	pool chan *redisConn
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// redisConn is a single connection with a buffered reader for replies
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply sent by the server (as opposed to a connection failure)
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// NewRedisStore creates a new Redis-backed quota store. Connections are opened lazily.
+func NewRedisStore(opts RedisOptions) *RedisStore {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 200 * time.Millisecond
+	}
+	if opts.Window <= 0 {
+		opts.Window = QuotaWindow
+	}
+	return &RedisStore{
+		opts: opts,
+		pool: make(chan *redisConn, opts.PoolSize),
+	}
+}
+
+// reserveScript increments a counter and refreshes its TTL only while it is below the limit,
+// so a refused request never touches the counter. It returns {allowed, count}.
+const reserveScript = `local count = tonumber(redis.call("GET", KEYS[1])) or 0
+if count >= tonumber(ARGV[1]) then
+	return {0, count}
+end
+count = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return {1, count}`
+
+// Reserve takes a slot in the counter for the current window in a single round trip
+func (s *RedisStore) Reserve(ctx context.Context, virtualKey string, limit int64) (Slot, bool, error) {
+	now := time.Now()
+	windowStart := now.Truncate(s.opts.Window)
+	token := s.counterKey(virtualKey, windowStart)
+
+	// Keep the counter a little longer than the window so late releases still find it
+	ttl := windowStart.Add(s.opts.Window).Sub(now) + time.Minute
+
+	replies, err := s.do(ctx, []string{"EVAL", reserveScript, "1", token,
+		strconv.FormatInt(limit, 10), strconv.FormatInt(ttl.Milliseconds(), 10)})
+	if err != nil {
+		return Slot{}, false, err
+	}
+
+	results, ok := replies[0].([]any)
+	if !ok || len(results) != 2 {
+		return Slot{}, false, fmt.Errorf("unexpected reserve reply: %v", replies[0])
+	}
+	allowed, okAllowed := results[0].(int64)
+	count, okCount := results[1].(int64)
+	if !okAllowed || !okCount {
+		return Slot{}, false, fmt.Errorf("unexpected reserve reply: %v", results)
+	}
+
+	if allowed == 0 {
+		return Slot{}, false, nil
+	}
+	return Slot{Token: token, Used: count, ResetAt: windowStart.Add(s.opts.Window)}, true, nil
+}
+
+// releaseScript decrements a counter only while it exists and is positive. A bare DECR on
+// an expired window's key would create a -1 counter with no TTL that never goes away.
+const releaseScript = `local count = tonumber(redis.call("GET", KEYS[1]))
+if count and count > 0 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0`
+
+// Release decrements the counter identified by token
+func (s *RedisStore) Release(ctx context.Context, token string) error {
+	_, err := s.do(ctx, []string{"EVAL", releaseScript, "1", token})
+	return err
+}
+
+// counterKey builds the Redis key for a virtual key's window. The virtual key is hashed
+// so plaintext keys never reach the store.
+func (s *RedisStore) counterKey(virtualKey string, windowStart time.Time) string {
+	sum := sha256.Sum256([]byte(virtualKey))
+	return fmt.Sprintf("%squota:%s:%d", s.opts.KeyPrefix, hex.EncodeToString(sum[:16]), windowStart.Unix())
+}
+
+// do sends a pipeline of commands and returns one reply per command
+func (s *RedisStore) do(ctx context.Context, commands ...[]string) ([]any, error) {
+	s.mu.Lock()
+	if time.Now().Before(s.downUntil) {
+		s.mu.Unlock()
+		return nil, ErrStoreUnavailable
+	}
+	s.mu.Unlock()
+
+	rc, err := s.getConn(ctx)
+	if err != nil {
+		s.markDown()
+		return nil, err
+	}
+
+	deadline := time.Now().Add(s.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	rc.conn.SetDeadline(deadline)
+
+	replies, err := rc.pipeline(commands...)
+	if err != nil {
+		var replyErr redisError
+		if errors.As(err, &replyErr) {
+			// The connection is still in a consistent state after an error reply
+			s.putConn(rc)
+			return nil, err
+		}
+		rc.conn.Close()
+		s.markDown()
+		return nil, err
+	}
+
+	s.putConn(rc)
+	return replies, nil
+}
+
+// getConn takes a pooled connection or dials a new one
+func (s *RedisStore) getConn(ctx context.Context) (*redisConn, error) {
+	select {
+	case rc := <-s.pool:
+		return rc, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: s.opts.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	// Authenticate and select the database on every new connection
+	var setup [][]string
+	if s.opts.Password != "" {
+		setup = append(setup, []string{"AUTH", s.opts.Password})
+	}
+	if s.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.opts.DB)})
+	}
+	if len(setup) > 0 {
+		conn.SetDeadline(time.Now().Add(s.opts.Timeout))
+		if _, err := rc.pipeline(setup...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to initialize redis connection: %w", err)
+		}
+	}
+	return rc, nil
+}
+
+// putConn returns a connection to the pool, closing it if the pool is full
+func (s *RedisStore) putConn(rc *redisConn) {
+	select {
+	case s.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+}
+
+// markDown makes the store report unavailable until the retry interval has passed
+func (s *RedisStore) markDown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.downUntil = time.Now().Add(redisRetryInterval)
+}
+
+// pipeline writes all commands then reads one reply per command
+func (rc *redisConn) pipeline(commands ...[]string) ([]any, error) {
+	var buf []byte
+	for _, args := range commands {
+		buf = appendCommand(buf, args)
+	}
+	if _, err := rc.conn.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to write redis command: %w", err)
+	}
+
+	replies := make([]any, len(commands))
+	var replyErr error
+	for i := range commands {
+		reply, err := readReply(rc.reader)
+		if err != nil {
+			var re redisError
+			if !errors.As(err, &re) {
+				return nil, err
+			}
+			// Keep reading so the connection stays in sync, but report the first error reply
+			if replyErr == nil {
+				replyErr = err
+			}
+		}
+		replies[i] = reply
+	}
+	if replyErr != nil {
+		return nil, replyErr
+	}
+	return replies, nil
+}
+
+// appendCommand encodes a command as a RESP array of bulk strings
+func appendCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readReply parses a single RESP reply. Integers are returned as int64, bulk and
+// simple strings as string, arrays as []any and nil replies as nil.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redis reply: %w", err)
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed redis reply: %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed bulk length: %q", payload)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read redis bulk string: %w", err)
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("malformed array length: %q", payload)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, count)
+		for i := range items {
+			item, err := readReply(r)
+			var re redisError
+			if errors.As(err, &re) {
+				// Error replies nested in arrays (e.g. EXEC results) are returned as values
+				items[i] = re
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type: %q", line[0])
+	}
+}
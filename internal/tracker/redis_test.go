@@ -0,0 +1,262 @@
+package tracker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"llmgateway/internal/models"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a minimal in-process stand-in speaking the subset of RESP used by RedisStore.
+// It runs the store's scripts natively rather than interpreting Lua.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	counters map[string]int64
+	ttls     map[string]int64 // Milliseconds, as last set by reserveScript
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	f := &fakeRedis{listener: listener, counters: make(map[string]int64), ttls: make(map[string]int64)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.listener.Addr().String() }
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, f.execute(args))
+	}
+}
+
+func (f *fakeRedis) execute(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "EVAL":
+		key := args[3]
+		switch args[1] {
+		case reserveScript:
+			limit, _ := strconv.ParseInt(args[4], 10, 64)
+			if f.counters[key] >= limit {
+				return fmt.Sprintf("*2\r\n:0\r\n:%d\r\n", f.counters[key])
+			}
+			f.counters[key]++
+			f.ttls[key], _ = strconv.ParseInt(args[5], 10, 64)
+			return fmt.Sprintf("*2\r\n:1\r\n:%d\r\n", f.counters[key])
+		case releaseScript:
+			count, exists := f.counters[key]
+			if !exists || count <= 0 {
+				return ":0\r\n"
+			}
+			f.counters[key]--
+			return ":" + strconv.FormatInt(f.counters[key], 10) + "\r\n"
+		}
+		return "-ERR unknown script\r\n"
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+// expireAll drops every counter, as if their windows' TTLs had passed
+func (f *fakeRedis) expireAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clear(f.counters)
+	clear(f.ttls)
+}
+
+func (f *fakeRedis) total() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var total int64
+	for _, count := range f.counters {
+		total += count
+	}
+	return total
+}
+
+// readCommand parses a RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	reply, err := readReply(r)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) == 0 {
+		return nil, fmt.Errorf("expected command array")
+	}
+	args := make([]string, len(items))
+	for i, item := range items {
+		args[i], _ = item.(string)
+	}
+	return args, nil
+}
+
+func TestRedisStoreSharedAcrossReplicas(t *testing.T) {
+	server := startFakeRedis(t)
+
+	// Two trackers simulate two gateway replicas sharing one store
+	replica1 := NewTracker(true, 3)
+	replica1.SetStore(NewRedisStore(RedisOptions{Addr: server.addr()}), nil)
+	replica2 := NewTracker(true, 3)
+	replica2.SetStore(NewRedisStore(RedisOptions{Addr: server.addr()}), nil)
+
+	for i, replica := range []*Tracker{replica1, replica2, replica1} {
+		res, err := replica.Reserve("test_key")
+		require.NoError(t, err, "request %d should be allowed", i)
+		status, _ := res.Status()
+		assert.Equal(t, int64(2-i), status.Remaining, "remaining counts requests from every replica")
+		replica.Settle(res, models.OutcomeSuccess)
+	}
+
+	_, err := replica2.Reserve("test_key")
+	require.Error(t, err, "the limit applies across replicas")
+	assert.Equal(t, int64(3), server.total(), "a rejected reservation must not leave a count behind")
+}
+
+func TestRedisStoreRefusalLeavesCounterUntouched(t *testing.T) {
+	server := startFakeRedis(t)
+	store := NewRedisStore(RedisOptions{Addr: server.addr()})
+	ctx := context.Background()
+
+	slot, allowed, err := store.Reserve(ctx, "test_key", 1)
+	require.NoError(t, err)
+	require.True(t, allowed)
+	assert.Equal(t, int64(1), slot.Used)
+	server.mu.Lock()
+	ttl := server.ttls[slot.Token]
+	server.mu.Unlock()
+	assert.Greater(t, ttl, int64(0), "the script sets the TTL along with the increment")
+	assert.LessOrEqual(t, ttl, (QuotaWindow + time.Minute).Milliseconds())
+
+	for i := 0; i < 3; i++ {
+		_, allowed, err = store.Reserve(ctx, "test_key", 1)
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	}
+	assert.Equal(t, int64(1), server.total(), "refusals are never counted, even transiently")
+}
+
+func TestRedisStoreReleasesUnchargedOutcomes(t *testing.T) {
+	server := startFakeRedis(t)
+
+	tracker := NewTracker(true, 1)
+	tracker.SetStore(NewRedisStore(RedisOptions{Addr: server.addr()}), nil)
+
+	res, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+	tracker.Settle(res, models.OutcomeTransportError)
+	assert.Equal(t, int64(0), server.total())
+
+	res, err = tracker.Reserve("test_key")
+	require.NoError(t, err)
+	tracker.Settle(res, models.OutcomeSuccess)
+	assert.Equal(t, int64(1), server.total())
+}
+
+func TestRedisStoreReleaseAfterExpiry(t *testing.T) {
+	server := startFakeRedis(t)
+
+	tracker := NewTracker(true, 1)
+	tracker.SetStore(NewRedisStore(RedisOptions{Addr: server.addr()}), nil)
+
+	res, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+	server.expireAll()
+
+	// Releasing into an expired window must not leave a negative counter behind
+	tracker.Settle(res, models.OutcomeTransportError)
+	server.mu.Lock()
+	assert.Empty(t, server.counters)
+	server.mu.Unlock()
+}
+
+func TestRedisStoreFallbackWhenUnreachable(t *testing.T) {
+	// Reserve a port and close it so connections are refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	var changes []error
+	tracker := NewTracker(true, 2)
+	tracker.SetStore(NewRedisStore(RedisOptions{Addr: addr, Timeout: 50 * time.Millisecond}), func(err error) {
+		changes = append(changes, err)
+	})
+
+	// In-memory counters enforce the limit while the store is down
+	for i := 0; i < 2; i++ {
+		res, err := tracker.Reserve("test_key")
+		require.NoError(t, err)
+		tracker.Settle(res, models.OutcomeSuccess)
+	}
+	_, err = tracker.Reserve("test_key")
+	require.Error(t, err)
+
+	require.Len(t, changes, 1, "only the transition to unreachable is reported")
+	assert.Error(t, changes[0])
+}
+
+func TestRedisStoreKeyHidesVirtualKey(t *testing.T) {
+	store := NewRedisStore(RedisOptions{KeyPrefix: "gw:"})
+	key := store.counterKey("vk_secret", time.Unix(3600, 0))
+
+	assert.True(t, strings.HasPrefix(key, "gw:quota:"))
+	assert.True(t, strings.HasSuffix(key, ":3600"))
+	assert.NotContains(t, key, "vk_secret")
+}
+
+func TestRedisStoreEnforcesGroupQuota(t *testing.T) {
+	server := startFakeRedis(t)
+
+	tracker := NewTracker(true, 10)
+	tracker.SetStore(NewRedisStore(RedisOptions{Addr: server.addr()}), nil)
+	team := Group{Kind: GroupTeam, ID: "search", QuotaLimit: 1}
+
+	res, err := tracker.Reserve("vk_a", team)
+	require.NoError(t, err)
+	status, _ := res.Status()
+	assert.Equal(t, int64(1), status.Limit, "the team's quota is the tighter one")
+	assert.Equal(t, int64(0), status.Remaining)
+	tracker.Settle(res, models.OutcomeSuccess)
+	assert.Equal(t, int64(2), server.total(), "the key and the team are both counted")
+
+	_, err = tracker.Reserve("vk_b", team)
+	require.Error(t, err)
+	assert.Equal(t, int64(2), server.total(), "the key's slot is given back when the team is over its limit")
+}
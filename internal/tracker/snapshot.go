@@ -0,0 +1,183 @@
+package tracker
+
+import (
+	"encoding/hex"
+	"fmt"
+	"llmgateway/internal/histogram"
+	"llmgateway/internal/models"
+	"maps"
+	"time"
+)
+
+// SnapshotVersion is bumped whenever the Snapshot layout changes incompatibly
+const SnapshotVersion = 1
+
+// Snapshot is a point-in-time copy of the tracker state that can be persisted,
+// latency histograms included so that percentiles survive a restart
+type Snapshot struct {
+	Version         int                         `json:"version"`
+	SavedAt         time.Time                   `json:"saved_at"`
+	Quotas          map[string]models.QuotaInfo `json:"quotas"` // models.KeyID -> quota
+	Stats           models.UsageStats           `json:"stats"`
+	TotalDurationMs int64                       `json:"total_duration_ms"`
+
+	OutcomesByProvider map[models.Provider]models.OutcomeCounts `json:"outcomes_by_provider,omitempty"`
+	OutcomesByKey      map[string]models.OutcomeCounts          `json:"outcomes_by_key,omitempty"`
+
+	Groups map[string]GroupState `json:"groups,omitempty"` // "team:ID" or "org:ID" -> state
+
+	Latencies []LatencySnapshot `json:"latencies,omitempty"`
+}
+
+// LatencySnapshot holds the latency histograms of one provider and model
+type LatencySnapshot struct {
+	Provider models.Provider      `json:"provider"`
+	Model    string               `json:"model"`
+	Total    *histogram.Histogram `json:"total"`
+	Upstream *histogram.Histogram `json:"upstream"`
+	Overhead *histogram.Histogram `json:"overhead"`
+	TTFT     *histogram.Histogram `json:"ttft,omitempty"`
+}
+
+// Snapshot returns a copy of the current quotas and usage counters
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	snapshot := Snapshot{
+		Version:         SnapshotVersion,
+		SavedAt:         time.Now(),
+		Quotas:          make(map[string]models.QuotaInfo, len(t.quotas)),
+		Stats:           t.stats,
+		TotalDurationMs: t.totalDurationMs,
+
+		OutcomesByProvider: copyOutcomes(t.outcomesByProv),
+		OutcomesByKey:      copyOutcomes(t.outcomesByKey),
+	}
+
+	for keyID, quota := range t.quotas {
+		q := *quota
+		// In-flight reservations do not outlive the process
+		q.Reserved = 0
+		snapshot.Quotas[keyID] = q
+	}
+	if len(t.groups) > 0 {
+		snapshot.Groups = make(map[string]GroupState, len(t.groups))
+		for key, state := range t.groups {
+			s := *state
+			s.Quota.Reserved = 0
+			snapshot.Groups[key] = s
+		}
+	}
+
+	for key, series := range t.latencies {
+		snapshot.Latencies = append(snapshot.Latencies, LatencySnapshot{
+			Provider: key.provider,
+			Model:    key.model,
+			Total:    copyHistogram(series.total),
+			Upstream: copyHistogram(series.upstream),
+			Overhead: copyHistogram(series.overhead),
+			TTFT:     copyHistogram(series.ttft),
+		})
+	}
+
+	snapshot.Stats.RequestsByProvider = make(map[models.Provider]int64, len(t.stats.RequestsByProvider))
+	maps.Copy(snapshot.Stats.RequestsByProvider, t.stats.RequestsByProvider)
+
+	return snapshot
+}
+
+// Restore replaces the tracker state with a previously taken snapshot
+func (t *Tracker) Restore(snapshot Snapshot) error {
+	if snapshot.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d (expected %d)", snapshot.Version, SnapshotVersion)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.quotas = make(map[string]*models.QuotaInfo, len(snapshot.Quotas))
+	for keyID, quota := range snapshot.Quotas {
+		q := quota
+		q.Reserved = 0
+		// The configured limit wins over whatever was in effect when the snapshot was taken
+		q.MaxRequests = t.quotaLimit
+		// Older snapshots were keyed by the virtual key itself
+		if !isKeyID(keyID) {
+			keyID = models.KeyID(keyID)
+		}
+		t.quotas[keyID] = &q
+	}
+
+	// Group limits are refreshed from the key set on the next request
+	t.groups = make(map[string]*GroupState, len(snapshot.Groups))
+	for key, state := range snapshot.Groups {
+		s := state
+		s.Quota.Reserved = 0
+		t.groups[key] = &s
+	}
+
+	t.stats = models.UsageStats{
+		TotalRequests:      snapshot.Stats.TotalRequests,
+		RequestsByProvider: make(map[models.Provider]int64, len(snapshot.Stats.RequestsByProvider)),
+		AverageResponseMs:  snapshot.Stats.AverageResponseMs,
+		LastUpdated:        snapshot.Stats.LastUpdated,
+	}
+	maps.Copy(t.stats.RequestsByProvider, snapshot.Stats.RequestsByProvider)
+	t.totalDurationMs = snapshot.TotalDurationMs
+
+	t.outcomesByProv = make(map[models.Provider]*models.OutcomeCounts, len(snapshot.OutcomesByProvider))
+	t.outcomesByKey = make(map[string]*models.OutcomeCounts, len(snapshot.OutcomesByKey))
+	t.totalOutcomes, t.errorOutcomes = 0, 0
+	for provider, c := range snapshot.OutcomesByProvider {
+		t.outcomesByProv[provider] = restoreOutcomes(c)
+		// Totals are derived from the per-provider view, which sees every outcome exactly once
+		for outcome, count := range c.Outcomes {
+			t.totalOutcomes += count
+			if outcome != models.OutcomeSuccess {
+				t.errorOutcomes += count
+			}
+		}
+	}
+	for keyLabel, c := range snapshot.OutcomesByKey {
+		t.outcomesByKey[keyLabel] = restoreOutcomes(c)
+	}
+
+	t.latencies = make(map[latencyKey]*latencySeries, len(snapshot.Latencies))
+	for _, l := range snapshot.Latencies {
+		t.latencies[latencyKey{provider: l.Provider, model: l.Model}] = &latencySeries{
+			total:    copyHistogram(l.Total),
+			upstream: copyHistogram(l.Upstream),
+			overhead: copyHistogram(l.Overhead),
+			ttft:     copyHistogram(l.TTFT),
+		}
+	}
+
+	return nil
+}
+
+// copyHistogram returns a copy of h, or an empty histogram if h is nil
+// isKeyID reports whether s has the form of a models.KeyID
+func isKeyID(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == len(models.KeyID(""))
+}
+
+func copyHistogram(h *histogram.Histogram) *histogram.Histogram {
+	c := histogram.New()
+	if h != nil {
+		c.Merge(h)
+	}
+	return c
+}
+
+// restoreOutcomes rebuilds mutable outcome counters from a snapshot, tolerating nil maps
+func restoreOutcomes(c models.OutcomeCounts) *models.OutcomeCounts {
+	restored := &models.OutcomeCounts{
+		Outcomes:    make(map[models.Outcome]int64, len(c.Outcomes)),
+		StatusCodes: make(map[int]int64, len(c.StatusCodes)),
+	}
+	maps.Copy(restored.Outcomes, c.Outcomes)
+	maps.Copy(restored.StatusCodes, c.StatusCodes)
+	return restored
+}
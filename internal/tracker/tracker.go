@@ -1,7 +1,10 @@
 package tracker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"llmgateway/internal/histogram"
 	"llmgateway/internal/models"
 	"maps"
 	"sync"
@@ -11,66 +14,316 @@ import (
 // Tracker manages usage tracking and quota enforcement
 type Tracker struct {
 	mu              sync.RWMutex
-	quotas          map[string]*models.QuotaInfo // Virtual key -> quota info
+	quotas          map[string]*models.QuotaInfo // models.KeyID -> quota info, so snapshots never hold plaintext keys
+	groups          map[string]*GroupState       // "team:ID" or "org:ID" -> quota, spend and usage
 	quotaLimit      int64
 	quotaEnabled    bool
+	chargePolicy    map[models.Outcome]bool // Outcomes that consume quota
+	store           QuotaStore              // Shared quota backend, nil for in-memory only
+	storeHealthy    bool
+	onStoreChange   func(err error) // Called when the store becomes unreachable (err) or recovers (nil)
 	stats           models.UsageStats
-	totalDurationMs int64 // For calculating average
+	totalDurationMs int64                         // For calculating average
+	latencies       map[latencyKey]*latencySeries // Per provider/model latency distributions
+	outcomesByProv  map[models.Provider]*models.OutcomeCounts
+	outcomesByKey   map[string]*models.OutcomeCounts // models.KeyID -> counts
+	totalOutcomes   int64
+	errorOutcomes   int64
 }
 
+// ErrQuotaExceeded and ErrBudgetExceeded wrap the errors Reserve refuses requests with
+var (
+	ErrQuotaExceeded  = errors.New("quota exceeded")
+	ErrBudgetExceeded = errors.New("budget exceeded")
+)
+
+// QuotaWindow is the length of a quota window. Windows are aligned to the clock, in memory
+// as in a QuotaStore, so a key's limit is the same whichever one is counting.
+const QuotaWindow = time.Hour
+
+// UnknownProvider labels outcomes recorded before a virtual key could be resolved
+const UnknownProvider models.Provider = "unknown"
+
+// latencyKey identifies a latency series
+type latencyKey struct {
+	provider models.Provider
+	model    string
+}
+
+// latencySeries holds the latency histograms for one provider and model
+type latencySeries struct {
+	total    *histogram.Histogram
+	upstream *histogram.Histogram
+	overhead *histogram.Histogram
+	ttft     *histogram.Histogram
+}
+
+// Reservation is a quota slot held for an in-flight request until it is settled
+type Reservation struct {
+	virtualKey  string
+	groups      []Group  // Groups whose in-memory quota holds a slot
+	storeTokens []string // Set when the slots are held in the shared store
+	status      QuotaStatus
+	settled     bool
+}
+
+// QuotaStatus describes a quota window as of a reservation
+type QuotaStatus struct {
+	Limit     int64     // Requests per window
+	Remaining int64     // Requests left in the window after this one
+	ResetAt   time.Time // When the window ends
+}
+
+// tighten replaces the status with other if other has fewer requests left
+func (s *QuotaStatus) tighten(other QuotaStatus) {
+	if other.Remaining < s.Remaining {
+		*s = other
+	}
+}
+
+// Status returns the most restrictive of the quotas the reservation counts against,
+// the key's own or one of its groups', as it stood when the reservation was made.
+// ok is false for a nil reservation, i.e. when quota is disabled.
+func (r *Reservation) Status() (status QuotaStatus, ok bool) {
+	if r == nil {
+		return QuotaStatus{}, false
+	}
+	return r.status, true
+}
+
+// DefaultChargedOutcomes are the outcomes charged against quota unless configured otherwise:
+// only requests that actually reached the provider
+var DefaultChargedOutcomes = []models.Outcome{models.OutcomeSuccess, models.OutcomeUpstreamError}
+
 // NewTracker creates a new usage tracker
 func NewTracker(quotaEnabled bool, quotaLimit int64) *Tracker {
-	return &Tracker{
-		quotas:       make(map[string]*models.QuotaInfo),
-		quotaLimit:   quotaLimit,
-		quotaEnabled: quotaEnabled,
+	t := &Tracker{
+		quotas:         make(map[string]*models.QuotaInfo),
+		groups:         make(map[string]*GroupState),
+		quotaLimit:     quotaLimit,
+		quotaEnabled:   quotaEnabled,
+		latencies:      make(map[latencyKey]*latencySeries),
+		outcomesByProv: make(map[models.Provider]*models.OutcomeCounts),
+		outcomesByKey:  make(map[string]*models.OutcomeCounts),
 		stats: models.UsageStats{
 			RequestsByProvider: make(map[models.Provider]int64),
 			LastUpdated:        time.Now(),
 		},
 	}
+	t.SetChargePolicy(DefaultChargedOutcomes)
+	return t
+}
+
+// SetChargePolicy sets which request outcomes consume quota when settled
+func (t *Tracker) SetChargePolicy(charged []models.Outcome) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.chargePolicy = make(map[models.Outcome]bool, len(charged))
+	for _, outcome := range charged {
+		t.chargePolicy[outcome] = true
+	}
+}
+
+// SetStore makes the tracker enforce quotas through a shared store, falling back to
+// in-memory counters while the store is unreachable. Both count in the same clock-aligned
+// QuotaWindow, but the in-memory counters only see this replica's requests, so a key may
+// get up to its limit again on each replica while the store is down.
+// onChange, if set, is called with the error when the store becomes unreachable and
+// with nil when it recovers.
+func (t *Tracker) SetStore(store QuotaStore, onChange func(err error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.store = store
+	t.storeHealthy = true
+	t.onStoreChange = onChange
 }
 
-// CheckQuota checks if a virtual key has exceeded its quota
-// Returns true if request is allowed, false if quota exceeded
-func (t *Tracker) CheckQuota(virtualKey string) (bool, error) {
+// Reserve holds a quota slot for a request before it is proxied, against the key's
+// own quota and the quota of each group it belongs to. The request is refused if any
+// group has spent its monthly budget.
+// Returns a nil reservation when quota is disabled; Settle accepts nil.
+func (t *Tracker) Reserve(virtualKey string, groups ...Group) (*Reservation, error) {
 	if !t.quotaEnabled {
-		return true, nil // Quota disabled, allow all requests
+		return nil, nil
+	}
+
+	t.mu.Lock()
+	err := t.checkBudgets(groups)
+	t.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if res, handled, err := t.reserveInStore(virtualKey, groups); handled {
+		return res, err
 	}
 
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	now := time.Now()
-	quota, exists := t.quotas[virtualKey]
+	quota := t.currentQuota(virtualKey)
 
-	if !exists {
-		// First request for this key
-		t.quotas[virtualKey] = &models.QuotaInfo{
-			RequestCount: 1,
-			WindowStart:  now,
-			MaxRequests:  t.quotaLimit,
+	// Pending reservations count against the limit so concurrent requests can't overshoot
+	if quota.RequestCount+quota.Reserved >= quota.MaxRequests {
+		return nil, fmt.Errorf("%w: %d requests per hour limit reached", ErrQuotaExceeded, quota.MaxRequests)
+	}
+	res := &Reservation{virtualKey: virtualKey, status: QuotaStatus{
+		Limit:     quota.MaxRequests,
+		Remaining: quota.MaxRequests - quota.RequestCount - quota.Reserved - 1,
+		ResetAt:   quota.WindowStart.Add(QuotaWindow),
+	}}
+	for _, g := range groups {
+		if g.QuotaLimit <= 0 {
+			continue
+		}
+		groupQuota := &t.currentGroup(g).Quota
+		if groupQuota.RequestCount+groupQuota.Reserved >= g.QuotaLimit {
+			return nil, fmt.Errorf("%w for %s %s: %d requests per hour limit reached", ErrQuotaExceeded, g.Kind, g.ID, g.QuotaLimit)
+		}
+		res.status.tighten(QuotaStatus{
+			Limit:     g.QuotaLimit,
+			Remaining: g.QuotaLimit - groupQuota.RequestCount - groupQuota.Reserved - 1,
+			ResetAt:   groupQuota.WindowStart.Add(QuotaWindow),
+		})
+		res.groups = append(res.groups, g)
+	}
+
+	quota.Reserved++
+	for _, g := range res.groups {
+		t.currentGroup(g).Quota.Reserved++
+	}
+	return res, nil
+}
+
+// Settle commits or releases a reservation depending on whether the outcome is charged.
+// Settling the same reservation twice has no effect.
+func (t *Tracker) Settle(res *Reservation, outcome models.Outcome) {
+	if res == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if res.settled {
+		t.mu.Unlock()
+		return
+	}
+	res.settled = true
+	charged := t.chargePolicy[outcome]
+
+	if len(res.storeTokens) == 0 {
+		settle := func(quota *models.QuotaInfo) {
+			quota.Reserved--
+			if charged {
+				quota.RequestCount++
+			}
+		}
+		settle(t.currentQuota(res.virtualKey))
+		for _, g := range res.groups {
+			settle(&t.currentGroup(g).Quota)
+		}
+		t.mu.Unlock()
+		return
+	}
+
+	store := t.store
+	t.mu.Unlock()
+
+	// The store already counted the request at reservation time; give it back if uncharged
+	if !charged {
+		t.releaseInStore(store, res.storeTokens)
+	}
+}
+
+// reserveInStore tries to reserve slots for the key and its limited groups in the shared store.
+// handled is false when there is no store or it is unreachable, in which case the caller falls
+// back to in-memory counters. Slots already taken are given back if a later one is refused.
+func (t *Tracker) reserveInStore(virtualKey string, groups []Group) (res *Reservation, handled bool, err error) {
+	t.mu.RLock()
+	store := t.store
+	t.mu.RUnlock()
+	if store == nil {
+		return nil, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	slot, allowed, storeErr := store.Reserve(ctx, virtualKey, t.quotaLimit)
+	t.reportStore(storeErr)
+	if storeErr != nil {
+		return nil, false, nil
+	}
+	if !allowed {
+		return nil, true, fmt.Errorf("%w: %d requests per hour limit reached", ErrQuotaExceeded, t.quotaLimit)
+	}
+	tokens := []string{slot.Token}
+	status := QuotaStatus{Limit: t.quotaLimit, Remaining: t.quotaLimit - slot.Used, ResetAt: slot.ResetAt}
+
+	for _, g := range groups {
+		if g.QuotaLimit <= 0 {
+			continue
+		}
+		groupSlot, allowed, storeErr := store.Reserve(ctx, g.key(), g.QuotaLimit)
+		t.reportStore(storeErr)
+		if storeErr != nil {
+			t.releaseInStore(store, tokens)
+			return nil, false, nil
 		}
-		return true, nil
+		if !allowed {
+			t.releaseInStore(store, tokens)
+			return nil, true, fmt.Errorf("%w for %s %s: %d requests per hour limit reached", ErrQuotaExceeded, g.Kind, g.ID, g.QuotaLimit)
+		}
+		tokens = append(tokens, groupSlot.Token)
+		status.tighten(QuotaStatus{Limit: g.QuotaLimit, Remaining: g.QuotaLimit - groupSlot.Used, ResetAt: groupSlot.ResetAt})
 	}
+	return &Reservation{virtualKey: virtualKey, storeTokens: tokens, status: status}, true, nil
+}
 
-	// Check if we're still in the same hour window
-	windowEnd := quota.WindowStart.Add(1 * time.Hour)
-	if now.After(windowEnd) {
-		// New window, reset counter
-		quota.RequestCount = 1
-		quota.WindowStart = now
-		return true, nil
+// releaseInStore gives back slots held in the shared store
+func (t *Tracker) releaseInStore(store QuotaStore, tokens []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, token := range tokens {
+		t.reportStore(store.Release(ctx, token))
 	}
+}
 
-	// Check if quota exceeded
-	if quota.RequestCount >= quota.MaxRequests {
-		return false, fmt.Errorf("quota exceeded: %d requests per hour limit reached", quota.MaxRequests)
+// reportStore tracks store health and notifies onStoreChange on transitions
+func (t *Tracker) reportStore(err error) {
+	t.mu.Lock()
+	healthy := err == nil
+	changed := healthy != t.storeHealthy
+	t.storeHealthy = healthy
+	onChange := t.onStoreChange
+	t.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange(err)
 	}
+}
 
-	// Increment counter
-	quota.RequestCount++
-	return true, nil
+// currentQuota returns the quota for a key, starting a new window if the previous one ended.
+// Reservations survive a window reset since their requests are still in flight. Caller must hold t.mu.
+func (t *Tracker) currentQuota(virtualKey string) *models.QuotaInfo {
+	now := time.Now()
+	keyID := models.KeyID(virtualKey)
+	quota, exists := t.quotas[keyID]
+	if !exists {
+		quota = &models.QuotaInfo{
+			WindowStart: now.Truncate(QuotaWindow),
+			MaxRequests: t.quotaLimit,
+		}
+		t.quotas[keyID] = quota
+		return quota
+	}
+
+	if !now.Before(quota.WindowStart.Add(QuotaWindow)) {
+		quota.RequestCount = 0
+		quota.WindowStart = now.Truncate(QuotaWindow)
+	}
+	return quota
 }
 
 // RecordRequest records a completed request for statistics
@@ -90,6 +343,84 @@ func (t *Tracker) RecordRequest(provider models.Provider, durationMs int64) {
 	t.stats.LastUpdated = time.Now()
 }
 
+// RecordLatency records the latency breakdown of a completed request.
+// totalMs is the client-observed duration, upstreamMs the time spent waiting on the provider
+// and ttftMs the time until the provider's first response byte, or negative if it is unknown.
+func (t *Tracker) RecordLatency(provider models.Provider, model string, totalMs, upstreamMs, ttftMs int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := latencyKey{provider: provider, model: model}
+	series, exists := t.latencies[key]
+	if !exists {
+		series = &latencySeries{
+			total:    histogram.New(),
+			upstream: histogram.New(),
+			overhead: histogram.New(),
+			ttft:     histogram.New(),
+		}
+		t.latencies[key] = series
+	}
+
+	series.total.Observe(float64(totalMs))
+	series.upstream.Observe(float64(upstreamMs))
+	series.overhead.Observe(float64(max(totalMs-upstreamMs, 0)))
+	if ttftMs >= 0 {
+		series.ttft.Observe(float64(ttftMs))
+	}
+}
+
+// RecordOutcome counts how a request ended. provider and virtualKey may be empty when the
+// request was rejected before they were known; upstreamStatus is 0 if the provider was not reached.
+func (t *Tracker) RecordOutcome(provider models.Provider, virtualKey string, outcome models.Outcome, upstreamStatus int) {
+	if provider == "" {
+		provider = UnknownProvider
+	}
+	keyLabel := string(UnknownProvider)
+	if virtualKey != "" {
+		keyLabel = models.KeyID(virtualKey)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totalOutcomes++
+	if outcome != models.OutcomeSuccess {
+		t.errorOutcomes++
+	}
+	countOutcome(t.outcomesByProv, provider, outcome, upstreamStatus)
+	countOutcome(t.outcomesByKey, keyLabel, outcome, upstreamStatus)
+	t.stats.LastUpdated = time.Now()
+}
+
+// countOutcome increments the outcome and status counters under key
+func countOutcome[K comparable](counts map[K]*models.OutcomeCounts, key K, outcome models.Outcome, upstreamStatus int) {
+	c, exists := counts[key]
+	if !exists {
+		c = &models.OutcomeCounts{
+			Outcomes:    make(map[models.Outcome]int64),
+			StatusCodes: make(map[int]int64),
+		}
+		counts[key] = c
+	}
+	c.Outcomes[outcome]++
+	if upstreamStatus > 0 {
+		c.StatusCodes[upstreamStatus]++
+	}
+}
+
+// copyOutcomes deep-copies outcome counters
+func copyOutcomes[K comparable](counts map[K]*models.OutcomeCounts) map[K]models.OutcomeCounts {
+	result := make(map[K]models.OutcomeCounts, len(counts))
+	for key, c := range counts {
+		result[key] = models.OutcomeCounts{
+			Outcomes:    maps.Clone(c.Outcomes),
+			StatusCodes: maps.Clone(c.StatusCodes),
+		}
+	}
+	return result
+}
+
 // GetStats returns current usage statistics
 func (t *Tracker) GetStats() models.UsageStats {
 	t.mu.RLock()
@@ -104,6 +435,38 @@ func (t *Tracker) GetStats() models.UsageStats {
 	}
 
 	maps.Copy(statsCopy.RequestsByProvider, t.stats.RequestsByProvider)
+	statsCopy.Teams, statsCopy.Organizations = t.groupStats()
+
+	if t.totalOutcomes > 0 {
+		statsCopy.ErrorRate = float64(t.errorOutcomes) / float64(t.totalOutcomes)
+		statsCopy.OutcomesByProvider = copyOutcomes(t.outcomesByProv)
+		statsCopy.OutcomesByKey = copyOutcomes(t.outcomesByKey)
+	}
+
+	if len(t.latencies) > 0 {
+		statsCopy.Latency = make(map[models.Provider]map[string]models.LatencyStats)
+		for key, series := range t.latencies {
+			if statsCopy.Latency[key.provider] == nil {
+				statsCopy.Latency[key.provider] = make(map[string]models.LatencyStats)
+			}
+			statsCopy.Latency[key.provider][key.model] = models.LatencyStats{
+				Count:    series.total.Count(),
+				Total:    percentiles(series.total),
+				Upstream: percentiles(series.upstream),
+				Overhead: percentiles(series.overhead),
+				TTFT:     percentiles(series.ttft),
+			}
+		}
+	}
 
 	return statsCopy
 }
+
+// percentiles summarizes a histogram as p50/p90/p99
+func percentiles(h *histogram.Histogram) models.LatencyPercentiles {
+	return models.LatencyPercentiles{
+		P50: h.Quantile(0.50),
+		P90: h.Quantile(0.90),
+		P99: h.Quantile(0.99),
+	}
+}
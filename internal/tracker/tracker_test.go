@@ -1,6 +1,7 @@
 package tracker
 
 import (
+	"encoding/json"
 	"llmgateway/internal/models"
 	"testing"
 	"time"
@@ -16,53 +17,42 @@ func TestNewTracker(t *testing.T) {
 	assert.Equal(t, int64(100), tracker.quotaLimit)
 }
 
-func TestCheckQuotaDisabled(t *testing.T) {
-	tracker := NewTracker(false, 100)
-
-	// When quota is disabled, all requests should be allowed
-	for i := 0; i < 200; i++ {
-		allowed, err := tracker.CheckQuota("test_key")
-		assert.True(t, allowed, "Request %d should be allowed when quota disabled", i)
-		require.NoError(t, err)
+// charge reserves a slot for a request and settles it as a success, as the handler does
+func charge(tracker *Tracker, virtualKey string, groups ...Group) error {
+	res, err := tracker.Reserve(virtualKey, groups...)
+	if err != nil {
+		return err
 	}
+	tracker.Settle(res, models.OutcomeSuccess)
+	return nil
 }
 
-func TestCheckQuotaEnabled(t *testing.T) {
+func TestReserveEnforcesQuota(t *testing.T) {
 	tracker := NewTracker(true, 10)
 
 	// First 10 requests should be allowed
 	for i := 0; i < 10; i++ {
-		allowed, err := tracker.CheckQuota("test_key")
-		assert.True(t, allowed, "Request %d should be allowed", i)
-		require.NoError(t, err)
+		require.NoError(t, charge(tracker, "test_key"), "Request %d should be allowed", i)
 	}
 
 	// 11th request should be denied
-	allowed, err := tracker.CheckQuota("test_key")
-	assert.False(t, allowed, "11th request should be denied")
+	err := charge(tracker, "test_key")
 	require.Error(t, err, "Expected error for quota exceeded")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
 }
 
-func TestCheckQuotaPerKey(t *testing.T) {
+func TestReservePerKey(t *testing.T) {
 	tracker := NewTracker(true, 5)
 
 	// Each key should have its own quota
 	for i := 0; i < 5; i++ {
-		allowed1, err1 := tracker.CheckQuota("key1")
-		allowed2, err2 := tracker.CheckQuota("key2")
-
-		assert.True(t, allowed1, "key1 request %d should be allowed", i)
-		assert.True(t, allowed2, "key2 request %d should be allowed", i)
-		require.NoError(t, err1)
-		require.NoError(t, err2)
+		require.NoError(t, charge(tracker, "key1"), "key1 request %d should be allowed", i)
+		require.NoError(t, charge(tracker, "key2"), "key2 request %d should be allowed", i)
 	}
 
 	// Both keys should now be at limit
-	allowed1, _ := tracker.CheckQuota("key1")
-	allowed2, _ := tracker.CheckQuota("key2")
-
-	assert.False(t, allowed1, "key1 should exceed quota")
-	assert.False(t, allowed2, "key2 should exceed quota")
+	assert.Error(t, charge(tracker, "key1"), "key1 should exceed quota")
+	assert.Error(t, charge(tracker, "key2"), "key2 should exceed quota")
 }
 
 func TestRecordRequest(t *testing.T) {
@@ -86,19 +76,299 @@ func TestQuotaWindowReset(t *testing.T) {
 
 	// Use up quota
 	for i := 0; i < 5; i++ {
-		tracker.CheckQuota("test_key")
+		require.NoError(t, charge(tracker, "test_key"))
 	}
 
 	// Should be denied now
-	allowed, _ := tracker.CheckQuota("test_key")
-	assert.False(t, allowed, "Request should be denied")
+	assert.Error(t, charge(tracker, "test_key"), "Request should be denied")
 
 	// Manually set window start to past hour to simulate time passage
 	tracker.mu.Lock()
-	tracker.quotas["test_key"].WindowStart = time.Now().Add(-2 * time.Hour)
+	tracker.quotas[models.KeyID("test_key")].WindowStart = time.Now().Add(-2 * time.Hour)
 	tracker.mu.Unlock()
 
 	// Should be allowed again after window reset
-	allowed, _ = tracker.CheckQuota("test_key")
-	assert.True(t, allowed, "Request should be allowed after window reset")
+	assert.NoError(t, charge(tracker, "test_key"), "Request should be allowed after window reset")
+}
+
+func TestReserveDisabled(t *testing.T) {
+	tracker := NewTracker(false, 1)
+
+	for i := 0; i < 5; i++ {
+		res, err := tracker.Reserve("test_key")
+		require.NoError(t, err)
+		assert.Nil(t, res)
+		tracker.Settle(res, models.OutcomeSuccess)
+	}
+}
+
+func TestReserveCountsPendingReservations(t *testing.T) {
+	tracker := NewTracker(true, 2)
+
+	res1, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+	res2, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+
+	// Both slots are held by in-flight requests
+	_, err = tracker.Reserve("test_key")
+	require.Error(t, err)
+
+	tracker.Settle(res1, models.OutcomeSuccess)
+	tracker.Settle(res2, models.OutcomeSuccess)
+
+	_, err = tracker.Reserve("test_key")
+	require.Error(t, err, "committed requests should still count against quota")
+}
+
+func TestReservationStatus(t *testing.T) {
+	tracker := NewTracker(true, 3)
+
+	res1, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+	status, ok := res1.Status()
+	require.True(t, ok)
+	assert.Equal(t, int64(3), status.Limit)
+	assert.Equal(t, int64(2), status.Remaining)
+	assert.WithinDuration(t, time.Now().Truncate(QuotaWindow).Add(QuotaWindow), status.ResetAt, time.Minute, "windows end on the hour")
+
+	tracker.Settle(res1, models.OutcomeSuccess)
+	res2, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+	status, _ = res2.Status()
+	assert.Equal(t, int64(1), status.Remaining)
+
+	var disabled *Reservation
+	_, ok = disabled.Status()
+	assert.False(t, ok)
+}
+
+func TestSettleReleasesUnchargedOutcomes(t *testing.T) {
+	tracker := NewTracker(true, 1)
+
+	for _, outcome := range []models.Outcome{
+		models.OutcomeValidationError,
+		models.OutcomeTransportError,
+		models.OutcomeTimeout,
+	} {
+		res, err := tracker.Reserve("test_key")
+		require.NoError(t, err, "quota should be refunded after %s", outcome)
+		tracker.Settle(res, outcome)
+	}
+
+	tracker.mu.RLock()
+	assert.Equal(t, int64(0), tracker.quotas[models.KeyID("test_key")].RequestCount)
+	assert.Equal(t, int64(0), tracker.quotas[models.KeyID("test_key")].Reserved)
+	tracker.mu.RUnlock()
+}
+
+func TestSettleChargesUpstreamErrorsByDefault(t *testing.T) {
+	tracker := NewTracker(true, 1)
+
+	res, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+	tracker.Settle(res, models.OutcomeUpstreamError)
+
+	_, err = tracker.Reserve("test_key")
+	require.Error(t, err)
+}
+
+func TestSetChargePolicy(t *testing.T) {
+	tracker := NewTracker(true, 1)
+	tracker.SetChargePolicy([]models.Outcome{models.OutcomeSuccess, models.OutcomeTimeout})
+
+	res, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+	tracker.Settle(res, models.OutcomeUpstreamError)
+
+	res, err = tracker.Reserve("test_key")
+	require.NoError(t, err, "upstream errors should not be charged under this policy")
+	tracker.Settle(res, models.OutcomeTimeout)
+
+	_, err = tracker.Reserve("test_key")
+	require.Error(t, err)
+}
+
+func TestSettleIsIdempotent(t *testing.T) {
+	tracker := NewTracker(true, 2)
+
+	res, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+	tracker.Settle(res, models.OutcomeSuccess)
+	tracker.Settle(res, models.OutcomeSuccess)
+
+	tracker.mu.RLock()
+	assert.Equal(t, int64(1), tracker.quotas[models.KeyID("test_key")].RequestCount)
+	assert.Equal(t, int64(0), tracker.quotas[models.KeyID("test_key")].Reserved)
+	tracker.mu.RUnlock()
+}
+
+func TestReservationSurvivesWindowReset(t *testing.T) {
+	tracker := NewTracker(true, 1)
+
+	res, err := tracker.Reserve("test_key")
+	require.NoError(t, err)
+
+	tracker.mu.Lock()
+	tracker.quotas[models.KeyID("test_key")].WindowStart = time.Now().Add(-2 * time.Hour)
+	tracker.mu.Unlock()
+
+	// The in-flight reservation still holds the only slot in the new window
+	_, err = tracker.Reserve("test_key")
+	require.Error(t, err)
+
+	tracker.Settle(res, models.OutcomeTimeout)
+	_, err = tracker.Reserve("test_key")
+	require.NoError(t, err)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	tracker := NewTracker(true, 5)
+	require.NoError(t, charge(tracker, "key1"))
+	require.NoError(t, charge(tracker, "key1"))
+	_, err := tracker.Reserve("key1")
+	require.NoError(t, err)
+	tracker.RecordRequest(models.ProviderOpenAI, 100)
+	tracker.RecordRequest(models.ProviderAnthropic, 300)
+
+	snapshot := tracker.Snapshot()
+	assert.Equal(t, SnapshotVersion, snapshot.Version)
+	assert.Equal(t, int64(0), snapshot.Quotas[models.KeyID("key1")].Reserved, "reservations should not be persisted")
+
+	restored := NewTracker(true, 3)
+	require.NoError(t, restored.Restore(snapshot))
+
+	stats := restored.GetStats()
+	assert.Equal(t, int64(2), stats.TotalRequests)
+	assert.Equal(t, int64(1), stats.RequestsByProvider[models.ProviderOpenAI])
+	assert.Equal(t, 200.0, stats.AverageResponseMs)
+
+	restored.mu.RLock()
+	assert.Equal(t, int64(2), restored.quotas[models.KeyID("key1")].RequestCount)
+	assert.Equal(t, int64(3), restored.quotas[models.KeyID("key1")].MaxRequests, "configured limit should win")
+	restored.mu.RUnlock()
+
+	// Averages keep accumulating from the restored totals
+	restored.RecordRequest(models.ProviderOpenAI, 500)
+	assert.Equal(t, 300.0, restored.GetStats().AverageResponseMs)
+}
+
+func TestSnapshotKeysQuotasByKeyID(t *testing.T) {
+	tracker := NewTracker(true, 5)
+	require.NoError(t, charge(tracker, "vk_secret_openai"))
+
+	// Persisted quotas never carry the plaintext key
+	snapshot := tracker.Snapshot()
+	require.Contains(t, snapshot.Quotas, models.KeyID("vk_secret_openai"))
+	assert.NotContains(t, snapshot.Quotas, "vk_secret_openai")
+
+	// Snapshots keyed by the plaintext key, as older versions wrote them, are migrated on restore
+	legacy := tracker.Snapshot()
+	legacy.Quotas = map[string]models.QuotaInfo{"vk_secret_openai": snapshot.Quotas[models.KeyID("vk_secret_openai")]}
+	restored := NewTracker(true, 1)
+	require.NoError(t, restored.Restore(legacy))
+	quotas := restored.Snapshot().Quotas
+	require.Len(t, quotas, 1)
+	assert.Equal(t, int64(1), quotas[models.KeyID("vk_secret_openai")].RequestCount)
+	_, err := restored.Reserve("vk_secret_openai")
+	assert.ErrorIs(t, err, ErrQuotaExceeded, "the restored count still applies to the key")
+}
+
+func TestRestoreVersionMismatch(t *testing.T) {
+	tracker := NewTracker(true, 5)
+	snapshot := tracker.Snapshot()
+	snapshot.Version = SnapshotVersion + 1
+
+	require.Error(t, tracker.Restore(snapshot))
+}
+
+func TestRecordLatency(t *testing.T) {
+	tracker := NewTracker(false, 0)
+
+	for i := int64(1); i <= 100; i++ {
+		tracker.RecordLatency(models.ProviderOpenAI, "gpt-4o", i*10+5, i*10, i*5)
+	}
+	tracker.RecordLatency(models.ProviderAnthropic, "claude-3-haiku", 50, 60, -1)
+
+	stats := tracker.GetStats()
+	require.Contains(t, stats.Latency, models.ProviderOpenAI)
+
+	openai := stats.Latency[models.ProviderOpenAI]["gpt-4o"]
+	assert.Equal(t, int64(100), openai.Count)
+	assert.InEpsilon(t, 500.0, openai.Total.P50, 0.25)
+	assert.InEpsilon(t, 900.0, openai.Upstream.P90, 0.25)
+	assert.Equal(t, 1005.0, openai.Total.P99)
+	assert.InEpsilon(t, 5.0, openai.Overhead.P99, 0.25)
+	assert.InEpsilon(t, 250.0, openai.TTFT.P50, 0.25)
+
+	// Upstream longer than total (clock skew) never yields negative overhead
+	anthropic := stats.Latency[models.ProviderAnthropic]["claude-3-haiku"]
+	assert.Equal(t, 0.0, anthropic.Overhead.P50)
+	assert.Equal(t, models.LatencyPercentiles{}, anthropic.TTFT, "an unknown first byte time is not recorded")
+}
+
+func TestGetStatsWithoutLatency(t *testing.T) {
+	tracker := NewTracker(false, 0)
+	assert.Nil(t, tracker.GetStats().Latency)
+}
+
+func TestSnapshotRestoresLatency(t *testing.T) {
+	tracker := NewTracker(false, 0)
+	for i := int64(1); i <= 100; i++ {
+		tracker.RecordLatency(models.ProviderOpenAI, "gpt-4o", i*10+5, i*10, i*5)
+	}
+
+	// Round trip through JSON as the persister does
+	data, err := json.Marshal(tracker.Snapshot())
+	require.NoError(t, err)
+	var snapshot Snapshot
+	require.NoError(t, json.Unmarshal(data, &snapshot))
+
+	restored := NewTracker(false, 0)
+	require.NoError(t, restored.Restore(snapshot))
+	assert.Equal(t, tracker.GetStats().Latency, restored.GetStats().Latency)
+
+	// Restored histograms keep accumulating
+	restored.RecordLatency(models.ProviderOpenAI, "gpt-4o", 20, 10, 5)
+	assert.Equal(t, int64(101), restored.GetStats().Latency[models.ProviderOpenAI]["gpt-4o"].Count)
+}
+
+func TestRecordOutcome(t *testing.T) {
+	tracker := NewTracker(false, 0)
+
+	tracker.RecordOutcome(models.ProviderOpenAI, "vk_user1_openai", models.OutcomeSuccess, 200)
+	tracker.RecordOutcome(models.ProviderOpenAI, "vk_user1_openai", models.OutcomeUpstreamError, 500)
+	tracker.RecordOutcome(models.ProviderOpenAI, "vk_user1_openai", models.OutcomeUpstreamError, 429)
+	tracker.RecordOutcome(models.ProviderAnthropic, "vk_user2_anthropic", models.OutcomeQuotaExceeded, 0)
+	tracker.RecordOutcome("", "", models.OutcomeAuthFailure, 0)
+
+	stats := tracker.GetStats()
+	assert.Equal(t, 0.8, stats.ErrorRate)
+
+	openai := stats.OutcomesByProvider[models.ProviderOpenAI]
+	assert.Equal(t, int64(1), openai.Outcomes[models.OutcomeSuccess])
+	assert.Equal(t, int64(2), openai.Outcomes[models.OutcomeUpstreamError])
+	assert.Equal(t, int64(1), openai.StatusCodes[500])
+	assert.Equal(t, int64(1), openai.StatusCodes[429])
+
+	assert.Equal(t, int64(1), stats.OutcomesByProvider[UnknownProvider].Outcomes[models.OutcomeAuthFailure])
+	assert.Equal(t, int64(1), stats.OutcomesByKey[models.KeyID("vk_user2_anthropic")].Outcomes[models.OutcomeQuotaExceeded])
+	assert.NotContains(t, stats.OutcomesByKey, "vk_user1_openai", "keys are identified by key ID")
+
+	// GetStats returns copies
+	openai.Outcomes[models.OutcomeSuccess] = 100
+	assert.Equal(t, int64(1), tracker.GetStats().OutcomesByProvider[models.ProviderOpenAI].Outcomes[models.OutcomeSuccess])
+}
+
+func TestSnapshotRestoresOutcomes(t *testing.T) {
+	tracker := NewTracker(false, 0)
+	tracker.RecordOutcome(models.ProviderOpenAI, "vk_user1_openai", models.OutcomeSuccess, 200)
+	tracker.RecordOutcome(models.ProviderOpenAI, "vk_user1_openai", models.OutcomeTimeout, 0)
+
+	restored := NewTracker(false, 0)
+	require.NoError(t, restored.Restore(tracker.Snapshot()))
+
+	stats := restored.GetStats()
+	assert.Equal(t, 0.5, stats.ErrorRate)
+	assert.Equal(t, int64(1), stats.OutcomesByProvider[models.ProviderOpenAI].Outcomes[models.OutcomeTimeout])
 }
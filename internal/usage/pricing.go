@@ -0,0 +1,148 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"llmgateway/internal/models"
+	"maps"
+	"os"
+	"strings"
+)
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	InputPerMTok  float64 `json:"input_per_mtok"`
+	OutputPerMTok float64 `json:"output_per_mtok"`
+}
+
+// Pricing maps model name prefixes to prices. The longest matching prefix wins,
+// so dated model versions pick up the price of their family.
+type Pricing map[string]ModelPrice
+
+// OtherModel is the label models without a known price are recorded under, so that
+// clients cannot create unbounded metric series and usage entries by inventing models
+const OtherModel = "other"
+
+// DefaultPricing holds list prices for common models
+var DefaultPricing = Pricing{
+	"gpt-3.5-turbo":     {InputPerMTok: 0.50, OutputPerMTok: 1.50},
+	"gpt-4":             {InputPerMTok: 30.00, OutputPerMTok: 60.00},
+	"gpt-4-turbo":       {InputPerMTok: 10.00, OutputPerMTok: 30.00},
+	"gpt-4o":            {InputPerMTok: 2.50, OutputPerMTok: 10.00},
+	"gpt-4o-mini":       {InputPerMTok: 0.15, OutputPerMTok: 0.60},
+	"claude-3-haiku":    {InputPerMTok: 0.25, OutputPerMTok: 1.25},
+	"claude-3-sonnet":   {InputPerMTok: 3.00, OutputPerMTok: 15.00},
+	"claude-3-opus":     {InputPerMTok: 15.00, OutputPerMTok: 75.00},
+	"claude-3-5-haiku":  {InputPerMTok: 0.80, OutputPerMTok: 4.00},
+	"claude-3-5-sonnet": {InputPerMTok: 3.00, OutputPerMTok: 15.00},
+}
+
+// LoadPricing returns DefaultPricing with the entries of a JSON pricing file added or
+// overriding it, so that new models can be priced without a code change. The file maps
+// model name prefixes to prices, e.g. {"gpt-4.1": {"input_per_mtok": 2, "output_per_mtok": 8}}.
+// An empty path returns DefaultPricing.
+func LoadPricing(path string) (Pricing, error) {
+	pricing := maps.Clone(DefaultPricing)
+	if path == "" {
+		return pricing, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+	var overrides Pricing
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing file: %w", err)
+	}
+	for prefix, price := range overrides {
+		if prefix == "" {
+			return nil, fmt.Errorf("pricing file: model prefix must not be empty")
+		}
+		if price.InputPerMTok < 0 || price.OutputPerMTok < 0 {
+			return nil, fmt.Errorf("pricing file: %s: prices must not be negative", prefix)
+		}
+		pricing[prefix] = price
+	}
+	return pricing, nil
+}
+
+// Priced reports whether a model has a known price
+func (p Pricing) Priced(model string) bool {
+	_, ok := p.lookup(model)
+	return ok
+}
+
+// ModelLabel returns the model for labelling metrics and usage, or OtherModel if it has no known price
+func (p Pricing) ModelLabel(model string) string {
+	if !p.Priced(model) {
+		return OtherModel
+	}
+	return model
+}
+
+// Cost returns the USD cost of a request, or 0 for models without a known price
+func (p Pricing) Cost(model string, inputTokens, outputTokens int64) float64 {
+	price, ok := p.lookup(model)
+	if !ok {
+		return 0
+	}
+	return (float64(inputTokens)*price.InputPerMTok + float64(outputTokens)*price.OutputPerMTok) / 1e6
+}
+
+// lookup finds the price with the longest prefix matching the model
+func (p Pricing) lookup(model string) (ModelPrice, bool) {
+	var best string
+	for prefix := range p {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return p[best], true
+}
+
+// ExtractTokens reads token counts from a provider response body
+func ExtractTokens(provider models.Provider, response map[string]any) (inputTokens, outputTokens int64) {
+	usageData, ok := response["usage"].(map[string]any)
+	if !ok {
+		return 0, 0
+	}
+
+	switch provider {
+	case models.ProviderOpenAI:
+		return toInt64(usageData["prompt_tokens"]), toInt64(usageData["completion_tokens"])
+	case models.ProviderAnthropic:
+		return toInt64(usageData["input_tokens"]), toInt64(usageData["output_tokens"])
+	default:
+		return 0, 0
+	}
+}
+
+// ExtractCachedTokens reads how many input tokens the provider served from its prompt cache
+func ExtractCachedTokens(provider models.Provider, response map[string]any) int64 {
+	usageData, ok := response["usage"].(map[string]any)
+	if !ok {
+		return 0
+	}
+
+	switch provider {
+	case models.ProviderOpenAI:
+		details, _ := usageData["prompt_tokens_details"].(map[string]any)
+		return toInt64(details["cached_tokens"])
+	case models.ProviderAnthropic:
+		return toInt64(usageData["cache_read_input_tokens"])
+	default:
+		return 0
+	}
+}
+
+// toInt64 converts a decoded JSON number to int64
+func toInt64(value any) int64 {
+	if number, ok := value.(float64); ok {
+		return int64(number)
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package usage
+
+import (
+	"llmgateway/internal/histogram"
+	"llmgateway/internal/models"
+	"time"
+)
+
+// Snapshot is a point-in-time copy of the hourly aggregates that can be persisted
+type Snapshot struct {
+	Hours []HourSnapshot `json:"hours"`
+}
+
+// HourSnapshot is one key, provider and model's usage within an hour
+type HourSnapshot struct {
+	Hour         time.Time            `json:"hour"`
+	KeyID        string               `json:"key_id"`
+	VirtualKey   string               `json:"virtual_key"` // Masked
+	Provider     models.Provider      `json:"provider"`
+	Model        string               `json:"model"`
+	Requests     int64                `json:"requests"`
+	Errors       int64                `json:"errors"`
+	InputTokens  int64                `json:"input_tokens"`
+	OutputTokens int64                `json:"output_tokens"`
+	CostUSD      float64              `json:"cost_usd"`
+	Latency      *histogram.Histogram `json:"latency"`
+}
+
+// Snapshot returns a copy of the hourly aggregates
+func (s *Store) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := Snapshot{Hours: make([]HourSnapshot, 0, len(s.hours))}
+	for key, agg := range s.hours {
+		latency := histogram.New()
+		latency.Merge(agg.latency)
+		snapshot.Hours = append(snapshot.Hours, HourSnapshot{
+			Hour:         time.Unix(key.hour, 0).UTC(),
+			KeyID:        key.keyID,
+			VirtualKey:   agg.maskedKey,
+			Provider:     key.provider,
+			Model:        key.model,
+			Requests:     agg.requests,
+			Errors:       agg.errors,
+			InputTokens:  agg.inputTokens,
+			OutputTokens: agg.outputTokens,
+			CostUSD:      agg.costUSD,
+			Latency:      latency,
+		})
+	}
+	return snapshot
+}
+
+// Restore replaces the hourly aggregates with a previously taken snapshot,
+// dropping hours that are past the store's retention period
+func (s *Store) Restore(snapshot Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hours = make(map[groupKey]*aggregate, len(snapshot.Hours))
+	for _, hour := range snapshot.Hours {
+		latency := hour.Latency
+		if latency == nil {
+			latency = histogram.New()
+		}
+		s.hours[groupKey{
+			hour:     hour.Hour.UTC().Truncate(time.Hour).Unix(),
+			keyID:    hour.KeyID,
+			provider: hour.Provider,
+			model:    hour.Model,
+		}] = &aggregate{
+			maskedKey:    hour.VirtualKey,
+			requests:     hour.Requests,
+			errors:       hour.Errors,
+			inputTokens:  hour.InputTokens,
+			outputTokens: hour.OutputTokens,
+			costUSD:      hour.CostUSD,
+			latency:      latency,
+		}
+	}
+	s.prune(time.Now())
+}
@@ -0,0 +1,243 @@
+package usage
+
+import (
+	"fmt"
+	"llmgateway/internal/histogram"
+	"llmgateway/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Bucket sizes supported by Query
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+)
+
+// Record describes a single proxied request as seen by the usage store
+type Record struct {
+	Timestamp    time.Time
+	VirtualKey   string
+	Provider     models.Provider
+	Model        string
+	DurationMs   int64
+	InputTokens  int64
+	OutputTokens int64
+	Error        bool // Transport failure, timeout or upstream 4xx/5xx
+}
+
+// Filter restricts a Query to matching records; empty fields match everything
+type Filter struct {
+	KeyID    string // The models.KeyID of a virtual key, never the key itself
+	Provider models.Provider
+	Model    string
+}
+
+// groupKey identifies an aggregate within an hour. Keys are grouped by models.KeyID:
+// masked keys are only for display, since different keys can mask to the same string.
+type groupKey struct {
+	hour     int64 // Unix seconds of the hour start
+	keyID    string
+	provider models.Provider
+	model    string
+}
+
+// aggregate holds the counters for one groupKey
+type aggregate struct {
+	maskedKey    string // models.MaskKey of the virtual key, for display
+	requests     int64
+	errors       int64
+	inputTokens  int64
+	outputTokens int64
+	costUSD      float64
+	latency      *histogram.Histogram
+}
+
+// Store keeps hourly usage aggregates in memory for a retention period
+type Store struct {
+	mu        sync.RWMutex
+	retention time.Duration
+	hours     map[groupKey]*aggregate
+	pricing   Pricing
+}
+
+// NewStore creates a new usage store keeping data for the given retention period
+func NewStore(retention time.Duration, pricing Pricing) *Store {
+	return &Store{
+		retention: retention,
+		hours:     make(map[groupKey]*aggregate),
+		pricing:   pricing,
+	}
+}
+
+// Record adds a request to the hourly aggregates
+func (s *Store) Record(rec Record) {
+	key := groupKey{
+		hour:     rec.Timestamp.UTC().Truncate(time.Hour).Unix(),
+		keyID:    models.KeyID(rec.VirtualKey),
+		provider: rec.Provider,
+		model:    rec.Model,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agg, exists := s.hours[key]
+	if !exists {
+		agg = &aggregate{maskedKey: models.MaskKey(rec.VirtualKey), latency: histogram.New()}
+		s.hours[key] = agg
+		// New hours are rare, so this is a cheap place to drop expired ones
+		s.prune(rec.Timestamp)
+	}
+
+	agg.requests++
+	if rec.Error {
+		agg.errors++
+	}
+	agg.inputTokens += rec.InputTokens
+	agg.outputTokens += rec.OutputTokens
+	agg.costUSD += s.pricing.Cost(rec.Model, rec.InputTokens, rec.OutputTokens)
+	agg.latency.Observe(float64(rec.DurationMs))
+}
+
+// Cost estimates the cost of a request in US dollars using the store's pricing
+func (s *Store) Cost(model string, inputTokens, outputTokens int64) float64 {
+	return s.pricing.Cost(model, inputTokens, outputTokens)
+}
+
+// Priced reports whether the store's pricing knows the model
+func (s *Store) Priced(model string) bool {
+	return s.pricing.Priced(model)
+}
+
+// ModelLabel returns the model, or OtherModel if the store's pricing does not know it
+func (s *Store) ModelLabel(model string) string {
+	return s.pricing.ModelLabel(model)
+}
+
+// Query returns usage between from (inclusive) and to (exclusive) grouped into
+// hour or day buckets, broken down by virtual key, provider and model
+func (s *Store) Query(from, to time.Time, bucket string, filter Filter) (models.UsageReport, error) {
+	var size time.Duration
+	switch bucket {
+	case BucketHour:
+		size = time.Hour
+	case BucketDay:
+		size = 24 * time.Hour
+	default:
+		return models.UsageReport{}, fmt.Errorf("invalid bucket %q: must be %q or %q", bucket, BucketHour, BucketDay)
+	}
+	if !from.Before(to) {
+		return models.UsageReport{}, fmt.Errorf("invalid time range: from must be before to")
+	}
+
+	type bucketGroup struct {
+		start int64
+		group groupKey
+	}
+	merged := make(map[bucketGroup]*aggregate)
+
+	s.mu.RLock()
+	for key, agg := range s.hours {
+		hour := time.Unix(key.hour, 0).UTC()
+		if hour.Before(from.UTC().Truncate(time.Hour)) || !hour.Before(to) || !filter.matches(key) {
+			continue
+		}
+
+		bg := bucketGroup{
+			start: hour.Truncate(size).Unix(),
+			group: groupKey{keyID: key.keyID, provider: key.provider, model: key.model},
+		}
+		target, exists := merged[bg]
+		if !exists {
+			target = &aggregate{maskedKey: agg.maskedKey, latency: histogram.New()}
+			merged[bg] = target
+		}
+		target.requests += agg.requests
+		target.errors += agg.errors
+		target.inputTokens += agg.inputTokens
+		target.outputTokens += agg.outputTokens
+		target.costUSD += agg.costUSD
+		target.latency.Merge(agg.latency)
+	}
+	s.mu.RUnlock()
+
+	buckets := make(map[int64]*models.UsageBucket)
+	for bg, agg := range merged {
+		b, exists := buckets[bg.start]
+		if !exists {
+			b = &models.UsageBucket{Start: time.Unix(bg.start, 0).UTC()}
+			buckets[bg.start] = b
+		}
+		b.Groups = append(b.Groups, models.UsageGroup{
+			KeyID:        bg.group.keyID,
+			VirtualKey:   agg.maskedKey,
+			Provider:     bg.group.provider,
+			Model:        bg.group.model,
+			Requests:     agg.requests,
+			Errors:       agg.errors,
+			InputTokens:  agg.inputTokens,
+			OutputTokens: agg.outputTokens,
+			TotalTokens:  agg.inputTokens + agg.outputTokens,
+			CostUSD:      agg.costUSD,
+			Latency: models.LatencyPercentiles{
+				P50: agg.latency.Quantile(0.50),
+				P90: agg.latency.Quantile(0.90),
+				P99: agg.latency.Quantile(0.99),
+			},
+		})
+	}
+
+	report := models.UsageReport{
+		From:    from.UTC(),
+		To:      to.UTC(),
+		Bucket:  bucket,
+		Buckets: make([]models.UsageBucket, 0, len(buckets)),
+	}
+	for _, b := range buckets {
+		sort.Slice(b.Groups, func(i, j int) bool {
+			gi, gj := b.Groups[i], b.Groups[j]
+			if gi.VirtualKey != gj.VirtualKey {
+				return gi.VirtualKey < gj.VirtualKey
+			}
+			if gi.KeyID != gj.KeyID {
+				return gi.KeyID < gj.KeyID
+			}
+			if gi.Provider != gj.Provider {
+				return gi.Provider < gj.Provider
+			}
+			return gi.Model < gj.Model
+		})
+		report.Buckets = append(report.Buckets, *b)
+	}
+	sort.Slice(report.Buckets, func(i, j int) bool {
+		return report.Buckets[i].Start.Before(report.Buckets[j].Start)
+	})
+
+	return report, nil
+}
+
+// prune drops hours older than the retention period. Caller must hold s.mu.
+func (s *Store) prune(now time.Time) {
+	cutoff := now.Add(-s.retention).Unix()
+	for key := range s.hours {
+		if key.hour < cutoff {
+			delete(s.hours, key)
+		}
+	}
+}
+
+// matches reports whether an aggregate key passes the filter
+func (f Filter) matches(key groupKey) bool {
+	if f.KeyID != "" && f.KeyID != key.keyID {
+		return false
+	}
+	if f.Provider != "" && f.Provider != key.provider {
+		return false
+	}
+	if f.Model != "" && f.Model != key.model {
+		return false
+	}
+	return true
+}
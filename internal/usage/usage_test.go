@@ -0,0 +1,212 @@
+package usage
+
+import (
+	"encoding/json"
+	"llmgateway/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var baseTime = time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+func TestQueryHourlyBreakdown(t *testing.T) {
+	store := NewStore(24*time.Hour, DefaultPricing)
+
+	store.Record(Record{Timestamp: baseTime, VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o", DurationMs: 100, InputTokens: 1000, OutputTokens: 500})
+	store.Record(Record{Timestamp: baseTime.Add(10 * time.Minute), VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o", DurationMs: 300, Error: true})
+	store.Record(Record{Timestamp: baseTime.Add(time.Hour), VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o", DurationMs: 200})
+	store.Record(Record{Timestamp: baseTime, VirtualKey: "vk_user2_anthropic", Provider: models.ProviderAnthropic, Model: "claude-3-haiku-20240307", DurationMs: 50})
+
+	report, err := store.Query(baseTime.Add(-time.Hour), baseTime.Add(2*time.Hour), BucketHour, Filter{})
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 2)
+
+	first := report.Buckets[0]
+	assert.Equal(t, baseTime.Truncate(time.Hour), first.Start)
+	require.Len(t, first.Groups, 2)
+
+	openai := first.Groups[0]
+	assert.Equal(t, models.MaskKey("vk_user1_openai"), openai.VirtualKey)
+	assert.Equal(t, models.KeyID("vk_user1_openai"), openai.KeyID)
+	assert.Equal(t, int64(2), openai.Requests)
+	assert.Equal(t, int64(1), openai.Errors)
+	assert.Equal(t, int64(1500), openai.TotalTokens)
+	assert.InDelta(t, 0.0075, openai.CostUSD, 1e-9)
+	assert.Equal(t, 300.0, openai.Latency.P99)
+
+	assert.Equal(t, int64(1), report.Buckets[1].Groups[0].Requests)
+}
+
+func TestQueryDailyBucketsAndFilters(t *testing.T) {
+	store := NewStore(72*time.Hour, DefaultPricing)
+
+	store.Record(Record{Timestamp: baseTime, VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o", DurationMs: 100})
+	store.Record(Record{Timestamp: baseTime.Add(5 * time.Hour), VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o", DurationMs: 100})
+	store.Record(Record{Timestamp: baseTime.Add(5 * time.Hour), VirtualKey: "vk_user2_anthropic", Provider: models.ProviderAnthropic, Model: "claude-3-opus", DurationMs: 100})
+
+	report, err := store.Query(baseTime.Add(-24*time.Hour), baseTime.Add(24*time.Hour), BucketDay, Filter{KeyID: models.KeyID("vk_user1_openai")})
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 1)
+	require.Len(t, report.Buckets[0].Groups, 1)
+	assert.Equal(t, int64(2), report.Buckets[0].Groups[0].Requests)
+
+	report, err = store.Query(baseTime.Add(-24*time.Hour), baseTime.Add(24*time.Hour), BucketDay, Filter{Provider: models.ProviderAnthropic})
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 1)
+	assert.Equal(t, "claude-3-opus", report.Buckets[0].Groups[0].Model)
+}
+
+func TestQuerySeparatesKeysWithTheSameMask(t *testing.T) {
+	store := NewStore(24*time.Hour, DefaultPricing)
+	alpha, beta := "vk_team_alpha_openai", "vk_team_beta_openai"
+	require.Equal(t, models.MaskKey(alpha), models.MaskKey(beta))
+
+	store.Record(Record{Timestamp: baseTime, VirtualKey: alpha, Provider: models.ProviderOpenAI, Model: "gpt-4o", InputTokens: 1000})
+	store.Record(Record{Timestamp: baseTime, VirtualKey: beta, Provider: models.ProviderOpenAI, Model: "gpt-4o", InputTokens: 2000})
+	store.Record(Record{Timestamp: baseTime, VirtualKey: beta, Provider: models.ProviderOpenAI, Model: "gpt-4o", InputTokens: 2000})
+
+	report, err := store.Query(baseTime.Add(-time.Hour), baseTime.Add(time.Hour), BucketHour, Filter{})
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 1)
+	groups := report.Buckets[0].Groups
+	require.Len(t, groups, 2, "keys sharing a mask are reported separately")
+	byID := map[string]models.UsageGroup{groups[0].KeyID: groups[0], groups[1].KeyID: groups[1]}
+	assert.Equal(t, int64(1), byID[models.KeyID(alpha)].Requests)
+	assert.Equal(t, int64(2), byID[models.KeyID(beta)].Requests)
+	assert.Equal(t, int64(4000), byID[models.KeyID(beta)].InputTokens)
+
+	// Filtering by a key's ID selects only that key
+	report, err = store.Query(baseTime.Add(-time.Hour), baseTime.Add(time.Hour), BucketHour, Filter{KeyID: models.KeyID(alpha)})
+	require.NoError(t, err)
+	require.Len(t, report.Buckets[0].Groups, 1)
+	assert.Equal(t, models.KeyID(alpha), report.Buckets[0].Groups[0].KeyID)
+
+	// Neither the masked nor the plaintext key is accepted as a filter
+	for _, filter := range []string{models.MaskKey(alpha), alpha} {
+		report, err = store.Query(baseTime.Add(-time.Hour), baseTime.Add(time.Hour), BucketHour, Filter{KeyID: filter})
+		require.NoError(t, err)
+		assert.Empty(t, report.Buckets)
+	}
+}
+
+func TestQueryInvalidArguments(t *testing.T) {
+	store := NewStore(time.Hour, DefaultPricing)
+
+	_, err := store.Query(baseTime, baseTime.Add(time.Hour), "week", Filter{})
+	require.Error(t, err)
+
+	_, err = store.Query(baseTime, baseTime, BucketHour, Filter{})
+	require.Error(t, err)
+}
+
+func TestRetention(t *testing.T) {
+	store := NewStore(2*time.Hour, DefaultPricing)
+
+	store.Record(Record{Timestamp: baseTime, VirtualKey: "vk_old", Provider: models.ProviderOpenAI, Model: "gpt-4o"})
+	store.Record(Record{Timestamp: baseTime.Add(5 * time.Hour), VirtualKey: "vk_new", Provider: models.ProviderOpenAI, Model: "gpt-4o"})
+
+	report, err := store.Query(baseTime.Add(-time.Hour), baseTime.Add(6*time.Hour), BucketHour, Filter{})
+	require.NoError(t, err)
+	require.Len(t, report.Buckets, 1)
+	assert.Equal(t, models.MaskKey("vk_new"), report.Buckets[0].Groups[0].VirtualKey)
+}
+
+func TestExtractTokens(t *testing.T) {
+	openai := map[string]any{"usage": map[string]any{"prompt_tokens": 12.0, "completion_tokens": 34.0}}
+	in, out := ExtractTokens(models.ProviderOpenAI, openai)
+	assert.Equal(t, int64(12), in)
+	assert.Equal(t, int64(34), out)
+
+	anthropic := map[string]any{"usage": map[string]any{"input_tokens": 5.0, "output_tokens": 7.0}}
+	in, out = ExtractTokens(models.ProviderAnthropic, anthropic)
+	assert.Equal(t, int64(5), in)
+	assert.Equal(t, int64(7), out)
+
+	in, out = ExtractTokens(models.ProviderOpenAI, nil)
+	assert.Equal(t, int64(0), in+out)
+}
+
+func TestExtractCachedTokens(t *testing.T) {
+	openai := map[string]any{"usage": map[string]any{"prompt_tokens": 2048.0, "prompt_tokens_details": map[string]any{"cached_tokens": 1024.0}}}
+	assert.Equal(t, int64(1024), ExtractCachedTokens(models.ProviderOpenAI, openai))
+
+	anthropic := map[string]any{"usage": map[string]any{"input_tokens": 5.0, "cache_read_input_tokens": 900.0}}
+	assert.Equal(t, int64(900), ExtractCachedTokens(models.ProviderAnthropic, anthropic))
+
+	assert.Equal(t, int64(0), ExtractCachedTokens(models.ProviderOpenAI, map[string]any{"usage": map[string]any{"prompt_tokens": 12.0}}))
+	assert.Equal(t, int64(0), ExtractCachedTokens(models.ProviderAnthropic, nil))
+}
+
+func TestPricingLongestPrefix(t *testing.T) {
+	// gpt-4o-mini must not be priced as gpt-4o or gpt-4
+	assert.InDelta(t, 0.15, DefaultPricing.Cost("gpt-4o-mini-2024-07-18", 1_000_000, 0), 1e-9)
+	assert.InDelta(t, 2.50, DefaultPricing.Cost("gpt-4o-2024-08-06", 1_000_000, 0), 1e-9)
+	assert.Equal(t, 0.0, DefaultPricing.Cost("unknown-model", 1000, 1000))
+	assert.False(t, DefaultPricing.Priced("unknown-model"))
+}
+
+func TestModelLabel(t *testing.T) {
+	assert.Equal(t, "gpt-4o-2024-08-06", DefaultPricing.ModelLabel("gpt-4o-2024-08-06"))
+	assert.Equal(t, OtherModel, DefaultPricing.ModelLabel("made-up-model-123"))
+	assert.Equal(t, OtherModel, DefaultPricing.ModelLabel(""))
+}
+
+func TestLoadPricing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"gpt-4.1": {"input_per_mtok": 2.00, "output_per_mtok": 8.00},
+		"gpt-4o": {"input_per_mtok": 5.00, "output_per_mtok": 15.00}
+	}`), 0644))
+
+	pricing, err := LoadPricing(path)
+	require.NoError(t, err)
+	assert.True(t, pricing.Priced("gpt-4.1-2025-04-14"), "new models can be priced without a code change")
+	assert.InDelta(t, 8.00, pricing.Cost("gpt-4.1", 0, 1_000_000), 1e-9)
+	assert.InDelta(t, 5.00, pricing.Cost("gpt-4o", 1_000_000, 0), 1e-9, "file entries override the defaults")
+	assert.InDelta(t, 0.25, pricing.Cost("claude-3-haiku", 1_000_000, 0), 1e-9, "defaults not in the file are kept")
+	assert.InDelta(t, 2.50, DefaultPricing.Cost("gpt-4o", 1_000_000, 0), 1e-9, "the defaults are not modified")
+
+	pricing, err = LoadPricing("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPricing, pricing)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"gpt-4.1": {"input_per_mtok": -1}}`), 0644))
+	_, err = LoadPricing(path)
+	assert.Error(t, err)
+	_, err = LoadPricing(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	now := time.Now().UTC()
+	store := NewStore(24*time.Hour, DefaultPricing)
+	store.Record(Record{Timestamp: now, VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o", DurationMs: 870, InputTokens: 1000, OutputTokens: 500})
+	store.Record(Record{Timestamp: now, VirtualKey: "vk_user1_openai", Provider: models.ProviderOpenAI, Model: "gpt-4o", DurationMs: 120, Error: true})
+	store.Record(Record{Timestamp: now.Add(-2 * time.Hour), VirtualKey: "vk_user2_anthropic", Provider: models.ProviderAnthropic, Model: "claude-3-opus", DurationMs: 50})
+
+	// Snapshots survive a JSON round trip, as they do in the state file
+	data, err := json.Marshal(store.Snapshot())
+	require.NoError(t, err)
+	var snapshot Snapshot
+	require.NoError(t, json.Unmarshal(data, &snapshot))
+
+	restored := NewStore(24*time.Hour, DefaultPricing)
+	restored.Restore(snapshot)
+
+	from, to := now.Add(-3*time.Hour), now.Add(time.Hour)
+	want, err := store.Query(from, to, BucketDay, Filter{})
+	require.NoError(t, err)
+	got, err := restored.Query(from, to, BucketDay, Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Hours past the retention period are dropped on restore
+	shortLived := NewStore(time.Hour, DefaultPricing)
+	shortLived.Restore(snapshot)
+	assert.Len(t, shortLived.Snapshot().Hours, 1)
+}
@@ -1,20 +1,41 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"llmgateway/config"
 	"llmgateway/internal/handler"
+	"llmgateway/internal/jwtauth"
 	"llmgateway/internal/logger"
+	"llmgateway/internal/metrics"
 	"llmgateway/internal/middleware"
+	"llmgateway/internal/persistence"
+	"llmgateway/internal/reload"
+	"llmgateway/internal/scheduler"
+	"llmgateway/internal/shutdown"
+	"llmgateway/internal/tlsutil"
+	"llmgateway/internal/tracing"
 	"llmgateway/internal/tracker"
+	"llmgateway/internal/usage"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "mint-key":
+			os.Exit(runMintKey(os.Args[2:]))
+		case "encrypt-secret":
+			os.Exit(runEncryptSecret(os.Args[2:]))
+		}
+	}
+
 	// Load configuration from KEYS_FILE_PATH env var or default "keys.json"
 	cfg, err := config.Load()
 	if err != nil {
@@ -32,65 +53,207 @@ func main() {
 		"port":          cfg.ServerPort,
 		"quota_enabled": cfg.QuotaEnabled,
 		"quota_limit":   cfg.QuotaLimit,
+		"quota_backend": cfg.QuotaBackend,
+		"tracing":       cfg.TracingEnabled,
+		"jwt_auth":      cfg.JWTEnabled(),
 	})
 
+	// Export OpenTelemetry spans if enabled
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.TracingEnabled {
+		shutdownTracing, err = tracing.Setup(context.Background(), cfg.TracingServiceName)
+		if err != nil {
+			log.Fatalf("Failed to initialize tracing: %v", err)
+		}
+	}
+
 	// Initialize usage tracker
 	usageTracker := tracker.NewTracker(cfg.QuotaEnabled, cfg.QuotaLimit)
+	usageTracker.SetChargePolicy(cfg.QuotaChargedOutcomes)
+
+	// Share quotas across replicas through redis, falling back to in-memory counters when unreachable
+	if cfg.QuotaBackend == "redis" {
+		store := tracker.NewRedisStore(tracker.RedisOptions{
+			Addr:      cfg.RedisAddr,
+			Password:  cfg.RedisPassword,
+			DB:        cfg.RedisDB,
+			KeyPrefix: cfg.RedisKeyPrefix,
+			Timeout:   time.Duration(cfg.RedisTimeoutMs) * time.Millisecond,
+		})
+		usageTracker.SetStore(store, func(err error) {
+			if err != nil {
+				appLogger.LogError("Quota store unreachable, falling back to in-memory quotas", err)
+				return
+			}
+			appLogger.LogInfo("Quota store recovered", map[string]any{"addr": cfg.RedisAddr})
+		})
+	}
+
+	// Initialize usage store for /usage reports, priced from the pricing file if one is configured
+	pricing, err := usage.LoadPricing(cfg.PricingFile)
+	if err != nil {
+		log.Fatalf("Failed to load pricing: %v", err)
+	}
+	usageStore := usage.NewStore(time.Duration(cfg.UsageRetentionHours)*time.Hour, pricing)
+
+	// Restore and periodically persist tracker state and usage history if enabled
+	var statePersister *persistence.Persister
+	if cfg.StateFilePath != "" {
+		statePersister = persistence.NewPersister(cfg.StateFilePath, time.Duration(cfg.StateSaveInterval)*time.Second, usageTracker, usageStore, appLogger)
+		if err := statePersister.Load(); err != nil {
+			log.Fatalf("Failed to load tracker state: %v", err)
+		}
+		statePersister.Start()
+	}
+
+	// Pick up keys file changes without a restart and warn about expiring keys
+	keysReloader := reload.NewReloader(cfg, time.Duration(cfg.KeysReloadInterval)*time.Second, appLogger)
+	keysReloader.Start()
+	defer keysReloader.Stop()
+
+	// SIGHUP forces a reload even when polling is disabled
+	go func() {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		for range hupChan {
+			keysReloader.Reload()
+		}
+	}()
+
+	// Initialize upstream scheduler
+	upstreamScheduler := scheduler.NewScheduler(cfg.SchedulerMaxConcurrent, scheduler.Policy(cfg.SchedulerPolicy))
 
 	// Initialize handler
-	h := handler.NewHandler(cfg, appLogger, usageTracker)
+	h := handler.NewHandler(cfg, appLogger, usageTracker, upstreamScheduler, usageStore, metrics.NewRegistry())
 
 	// Create HTTP server with routes
 	mux := http.NewServeMux()
 
+	// Accept JWTs signed by the identity provider alongside virtual keys if configured
+	var jwtVerifier *jwtauth.Verifier
+	if cfg.JWTEnabled() {
+		jwtVerifier, err = jwtauth.NewVerifier(jwtauth.Options{
+			JWKSURL:         cfg.JWTJWKSURL,
+			JWKSFile:        cfg.JWTJWKSFile,
+			RefreshInterval: time.Duration(cfg.JWTJWKSRefresh) * time.Second,
+			Issuer:          cfg.JWTIssuer,
+			Audience:        cfg.JWTAudience,
+			GroupsClaim:     cfg.JWTGroupsClaim,
+			Leeway:          time.Duration(cfg.JWTLeewaySeconds) * time.Second,
+		})
+		if err != nil {
+			log.Fatalf("Failed to load JWKS: %v", err)
+		}
+	}
+
 	// Main endpoint - requires authentication
-	authMiddleware := middleware.AuthMiddleware(cfg)
-	mux.Handle("/chat/completions", authMiddleware(http.HandlerFunc(h.ChatCompletions)))
+	authMiddleware := middleware.AuthMiddleware(cfg, usageTracker, jwtVerifier, appLogger)
+	mux.Handle("/chat/completions", tracing.Middleware("POST /chat/completions", authMiddleware(http.HandlerFunc(h.ChatCompletions))))
 
-	// Health and metrics endpoints - no authentication required
+	// Health, readiness and metrics endpoints - no authentication required
+	readiness := &shutdown.Readiness{}
 	mux.HandleFunc("/health", h.Health)
+	mux.Handle("/ready", readiness)
 	mux.HandleFunc("/metrics", h.Metrics)
+	mux.HandleFunc("/metrics/prometheus", h.PrometheusMetrics)
 
-	// Add a simple root handler
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"service":"LLM Gateway","version":"1.0.0","endpoints":["/chat/completions","/health","/metrics"]}`)
-	})
+	// Usage reports and the key management API - only exposed when an admin key is configured
+	if cfg.AdminAPIKey != "" {
+		adminAuth := middleware.AdminAuthMiddleware(cfg)
+		mux.Handle("/usage", adminAuth(http.HandlerFunc(h.Usage)))
+		mux.Handle("/admin/keys", adminAuth(http.HandlerFunc(h.AdminKeys)))
+		mux.Handle("/admin/keys/", adminAuth(http.HandlerFunc(h.AdminKey)))
+	}
+
+	// Describe the service at / and answer unknown paths with a not_found error
+	mux.HandleFunc("/", h.Root)
 
 	// Create server
 	server := &http.Server{
 		Addr:    ":" + cfg.ServerPort,
-		Handler: mux,
+		Handler: middleware.RequestID(middleware.CleanPaths(mux)),
+	}
+
+	// Serve HTTPS if a certificate is configured, picking up renewed certificates without a restart
+	scheme := "http"
+	if cfg.TLSEnabled() {
+		certReloader, err := tlsutil.NewCertReloader(tlsutil.Options{
+			CertFile:     cfg.TLSCertFile,
+			KeyFile:      cfg.TLSKeyFile,
+			ClientCAFile: cfg.TLSClientCAFile,
+			ClientAuth:   cfg.TLSClientAuth,
+		}, appLogger)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		certReloader.Start(time.Duration(cfg.TLSReloadInterval) * time.Second)
+		defer certReloader.Stop()
+		server.TLSConfig = certReloader.TLSConfig()
+		scheme = "https"
 	}
 
-	// Handle graceful shutdown
+	// Handle graceful shutdown: fail readiness, stop accepting connections and let
+	// in-flight requests finish before the state below is saved
+	drained := make(chan struct{})
 	go func() {
+		defer close(drained)
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		<-sigChan
 
-		appLogger.LogInfo("Shutting down server...", nil)
-		if err := server.Close(); err != nil {
+		appLogger.LogInfo("Shutting down server, draining in-flight requests...", map[string]any{
+			"drain_delay_seconds": cfg.ShutdownDrainDelay,
+			"timeout_seconds":     cfg.ShutdownTimeout,
+		})
+		err := shutdown.Drain(server, readiness, shutdown.Options{
+			Delay:   time.Duration(cfg.ShutdownDrainDelay) * time.Second,
+			Timeout: time.Duration(cfg.ShutdownTimeout) * time.Second,
+		})
+		if err != nil {
 			appLogger.LogError("Error during server shutdown", err)
 		}
 	}()
 
 	// Start server
 	appLogger.LogInfo("Server started", map[string]any{
-		"address": "http://localhost:" + cfg.ServerPort,
+		"address":     scheme + "://localhost:" + cfg.ServerPort,
+		"client_auth": cfg.TLSClientCAFile != "" && cfg.TLSClientAuth != "none",
 	})
 	fmt.Printf("LLM Gateway listening on port %s\n", cfg.ServerPort)
 	fmt.Printf("Endpoints:\n")
 	fmt.Printf("  POST /chat/completions\n")
 	fmt.Printf("  GET  /health\n")
+	fmt.Printf("  GET  /ready\n")
 	fmt.Printf("  GET  /metrics\n")
+	fmt.Printf("  GET  /metrics/prometheus\n")
+	if cfg.AdminAPIKey != "" {
+		fmt.Printf("  GET  /usage\n")
+		fmt.Printf("  *    /admin/keys\n")
+	}
 
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	if cfg.TLSEnabled() {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		appLogger.LogError("Server failed to start", err)
 		log.Fatalf("Server error: %v", err)
 	}
+	<-drained
+
+	// Persist final tracker state before exiting
+	if statePersister != nil {
+		if err := statePersister.Stop(); err != nil {
+			appLogger.LogError("Failed to persist tracker state on shutdown", err)
+		}
+	}
+
+	// Flush pending spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		appLogger.LogError("Failed to flush traces on shutdown", err)
+	}
+	appLogger.LogInfo("Shutdown complete", nil)
 }
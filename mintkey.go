@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"llmgateway/config"
+	"llmgateway/internal/models"
+	"os"
+	"strings"
+)
+
+// runMintKey implements "gateway mint-key": it generates a virtual key and prints
+// the keys.json entry storing only its hash
+func runMintKey(args []string) int {
+	return mintKey(args, os.Stdin, os.Stdout, os.Stderr)
+}
+
+// mintKey is runMintKey with its streams passed in. The provider API key is read from
+// stdin; when stdin is empty the entry gets a placeholder to fill in.
+func mintKey(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("mint-key", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: gateway mint-key [flags] < api-key.txt\n")
+		flags.PrintDefaults()
+	}
+	provider := flags.String("provider", string(models.ProviderOpenAI), "provider the key routes to (openai or anthropic)")
+	priority := flags.String("priority", "", "priority class (interactive, default or batch)")
+	name := flags.String("name", "", "human-readable owner or purpose")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	keyConfig := models.VirtualKeyConfig{
+		Provider: models.Provider(*provider),
+		Priority: models.Priority(*priority),
+		Name:     *name,
+	}
+	if keyConfig.Provider.Endpoint() == "" {
+		fmt.Fprintf(stderr, "unsupported provider %q\n", *provider)
+		return 2
+	}
+	if !keyConfig.Priority.IsValid() {
+		fmt.Fprintf(stderr, "unknown priority %q\n", *priority)
+		return 2
+	}
+
+	// The API key is read from stdin so it does not end up in shell history or ps output
+	if f, ok := stdin.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprintf(stderr, "Provider API key (empty to fill in later): ")
+		}
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(stderr, "failed to read API key from stdin: %v\n", err)
+		return 1
+	}
+	keyConfig.APIKey = strings.TrimSpace(line)
+	if keyConfig.APIKey == "" {
+		keyConfig.APIKey = "<provider API key>"
+	}
+
+	virtualKey, lookupName := config.MintVirtualKey()
+	keyConfig.KeyHash = config.HashVirtualKey(virtualKey)
+
+	fmt.Fprintf(stdout, "Virtual key (shown only once, give it to the client):\n\n  %s\n\n", virtualKey)
+	fmt.Fprintf(stdout, "Add this entry to \"virtual_keys\" in keys.json:\n\n")
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(map[string]models.VirtualKeyConfig{lookupName: keyConfig}); err != nil {
+		fmt.Fprintf(stderr, "failed to encode entry: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"llmgateway/config"
+	"llmgateway/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runMintKeyWith runs mint-key with stdin and returns the printed virtual key and entry
+func runMintKeyWith(t *testing.T, stdin string, args ...string) (string, map[string]models.VirtualKeyConfig) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, mintKey(args, strings.NewReader(stdin), &stdout, &stderr), stderr.String())
+
+	// The key is the indented line after the first header, the entry is the JSON after the second
+	output := stdout.String()
+	virtualKey := strings.TrimSpace(strings.Split(output, "\n")[2])
+	start := strings.Index(output, "{")
+	require.GreaterOrEqual(t, start, 0, output)
+
+	var entry map[string]models.VirtualKeyConfig
+	require.NoError(t, json.Unmarshal([]byte(output[start:]), &entry))
+	return virtualKey, entry
+}
+
+func TestMintKey(t *testing.T) {
+	virtualKey, entry := runMintKeyWith(t, "sk-from-stdin\n", "-provider", "anthropic", "-priority", "batch", "-name", "search team")
+
+	lookupName := config.KeyLookupName(virtualKey)
+	require.Contains(t, entry, lookupName)
+	keyConfig := entry[lookupName]
+	assert.Equal(t, models.ProviderAnthropic, keyConfig.Provider)
+	assert.Equal(t, "sk-from-stdin", keyConfig.APIKey)
+	assert.Equal(t, models.PriorityBatch, keyConfig.Priority)
+	assert.Equal(t, "search team", keyConfig.Name)
+
+	// The entry stores a salted hash that authenticates the printed key and nothing else
+	assert.True(t, strings.HasPrefix(keyConfig.KeyHash, "sha256:"))
+	assert.NotContains(t, keyConfig.KeyHash, virtualKey)
+	cfg := &config.Config{}
+	_, err := cfg.SetKeysConfig(models.KeysConfig{VirtualKeys: entry})
+	require.NoError(t, err)
+	name, _, err := cfg.AuthenticateVirtualKey(virtualKey)
+	require.NoError(t, err)
+	assert.Equal(t, lookupName, name)
+	_, _, err = cfg.AuthenticateVirtualKey(lookupName + "_wrong")
+	assert.Error(t, err)
+
+	// Minting again uses a fresh salt
+	otherKey, other := runMintKeyWith(t, "sk-from-stdin\n")
+	assert.NotEqual(t, keyConfig.KeyHash, other[config.KeyLookupName(otherKey)].KeyHash)
+}
+
+func TestMintKeyWithoutAPIKey(t *testing.T) {
+	virtualKey, entry := runMintKeyWith(t, "")
+
+	assert.Equal(t, "<provider API key>", entry[config.KeyLookupName(virtualKey)].APIKey)
+}
+
+func TestMintKeyRejectsUnknownProvider(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, mintKey([]string{"-provider", "mistral"}, strings.NewReader("sk\n"), &stdout, &stderr))
+	assert.Empty(t, stdout.String())
+}
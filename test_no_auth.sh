@@ -1,5 +1,5 @@
 #!/bin/bash
-echo "Testing without Authorization header (should return 401)..."
+echo "Testing without credentials (should return 401)..."
 echo ""
 
 curl -X POST http://localhost:8080/chat/completions \
@@ -12,4 +12,4 @@ curl -X POST http://localhost:8080/chat/completions \
   }' | jq '.'
 
 echo ""
-echo "Expected: 401 Unauthorized - missing Authorization header"
+echo "Expected: 401 Unauthorized - missing credentials"